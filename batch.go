@@ -0,0 +1,129 @@
+package protocmd
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+// BatchCmdId is the cmdId of batch frames. The payload of a batch frame is
+// a sequence of frames, each carrying one message, and it may be compressed
+// as a whole. Batch frames are reserved like control messages, but they
+// have no registered message type.
+const BatchCmdId uint16 = 0xffff
+
+var (
+	ErrNotBatch    = errors.New("protocmd: not a batch frame")
+	ErrNestedBatch = errors.New("protocmd: nested batch frame")
+)
+
+// EncodeBatch packs msgs into one batch frame.
+func (c *FrameCodec) EncodeBatch(msgs []CmdMessage) (*Frame, error) {
+	var payload []byte
+	for _, msg := range msgs {
+		var err error
+		if payload, err = c.appendBatchItem(payload, msg); err != nil {
+			return nil, err
+		}
+	}
+	return c.batchFrame(payload)
+}
+
+// DecodeBatch unpacks the messages of a batch frame in order.
+func (c *FrameCodec) DecodeBatch(f *Frame) ([]CmdMessage, error) {
+	items, err := c.splitBatch(f)
+	if err != nil {
+		return nil, err
+	}
+	msgs := make([]CmdMessage, 0, len(items))
+	for _, item := range items {
+		msg, err := c.Decode(item)
+		if err != nil {
+			return nil, err
+		}
+		msgs = append(msgs, msg)
+	}
+	return msgs, nil
+}
+
+// DecodeBatchLazy is like DecodeBatch, but returns LazyMessages.
+func (c *FrameCodec) DecodeBatchLazy(f *Frame) ([]*LazyMessage, error) {
+	items, err := c.splitBatch(f)
+	if err != nil {
+		return nil, err
+	}
+	msgs := make([]*LazyMessage, 0, len(items))
+	for _, item := range items {
+		msg, err := c.DecodeLazy(item)
+		if err != nil {
+			return nil, err
+		}
+		msgs = append(msgs, msg)
+	}
+	return msgs, nil
+}
+
+// splitBatch parses the items of a batch frame.
+func (c *FrameCodec) splitBatch(f *Frame) ([]*Frame, error) {
+	if f.CmdId != BatchCmdId {
+		return nil, ErrNotBatch
+	}
+	payload, err := c.decodePayload(f)
+	if err != nil {
+		return nil, err
+	}
+
+	// Items never carry sequence numbers or checksums of their own.
+	codec := &FrameCodec{MaxFrameSize: c.maxFrameSize()}
+	var items []*Frame
+	for len(payload) > 0 {
+		item, n, err := codec.ParseFrame(payload)
+		if err != nil {
+			return nil, err
+		}
+		if item.CmdId == BatchCmdId {
+			return nil, ErrNestedBatch
+		}
+		items = append(items, item)
+		payload = payload[n:]
+	}
+	return items, nil
+}
+
+// appendBatchItem appends msg to the payload of a batch frame. Items aren't
+// compressed, as the whole batch is.
+func (c *FrameCodec) appendBatchItem(payload []byte, msg CmdMessage) ([]byte, error) {
+	start := len(payload)
+	headerSize := FrameHeaderSize
+	if c.payloadCodec().Id() != ProtoPayloadCodecId {
+		headerSize++
+	}
+	payload = append(payload, make([]byte, headerSize)...)
+	payload, codec, err := c.appendMarshal(payload, msg)
+	if err != nil {
+		return nil, err
+	}
+
+	length := len(payload) - start - FrameHeaderSize
+	if length > c.maxFrameSize() {
+		return nil, ErrFrameTooLarge
+	}
+	binary.BigEndian.PutUint32(payload[start:], uint32(length))
+	binary.BigEndian.PutUint16(payload[start+4:], msg.CmdId())
+	payload[start+6] = 0
+	if codec != ProtoPayloadCodecId {
+		payload[start+6] = byte(FlagPayloadCodec)
+		payload[start+7] = codec
+	}
+	return payload, nil
+}
+
+func (c *FrameCodec) batchFrame(payload []byte) (*Frame, error) {
+	if len(payload) > c.maxFrameSize() {
+		return nil, ErrFrameTooLarge
+	}
+	payload, flags, err := c.compress(BatchCmdId, payload)
+	if err != nil {
+		return nil, err
+	}
+	return &Frame{CmdId: BatchCmdId, Flags: flags, Payload: payload}, nil
+}
@@ -0,0 +1,96 @@
+package protocmd_test
+
+import (
+	"fmt"
+	"github.com/stalomeow/protocmd"
+	"github.com/stalomeow/protocmd/examples/go/protos"
+	"google.golang.org/protobuf/proto"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestBatch(t *testing.T) {
+	var msgs []protocmd.CmdMessage
+	for i := 0; i < 100; i++ {
+		msgs = append(msgs, &protos.TestReq{Uid: fmt.Sprint(i)})
+	}
+	msgs = append(msgs, &protocmd.Ping{Time: 1})
+
+	codec := &protocmd.FrameCodec{Compressor: protocmd.ZlibCompressor}
+	f, err := codec.EncodeBatch(msgs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.CmdId != protocmd.BatchCmdId || f.Flags != protocmd.FlagCompressed {
+		t.Fatalf("EncodeBatch() = cmdId %v, flags %v", f.CmdId, f.Flags)
+	}
+
+	got, err := protocmd.DefaultFrameCodec.DecodeBatch(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != len(msgs) {
+		t.Fatalf("DecodeBatch() returned %v messages, want %v", len(got), len(msgs))
+	}
+	for i := range msgs {
+		if !proto.Equal(got[i], msgs[i]) {
+			t.Fatalf("message %v: got %v, want %v", i, protocmd.CmdString(got[i]), protocmd.CmdString(msgs[i]))
+		}
+	}
+
+	nested, _ := protocmd.DefaultFrameCodec.AppendFrame(nil, &protocmd.Frame{CmdId: protocmd.BatchCmdId})
+	if _, err := protocmd.DefaultFrameCodec.DecodeBatch(&protocmd.Frame{CmdId: protocmd.BatchCmdId, Payload: nested}); err != protocmd.ErrNestedBatch {
+		t.Fatalf("DecodeBatch() of a nested batch returned %v", err)
+	}
+}
+
+// countingTransport counts the frames written.
+type countingTransport struct {
+	protocmd.Transport
+	frames atomic.Int32
+}
+
+func (t *countingTransport) WriteFrame(f *protocmd.Frame) error {
+	t.frames.Add(1)
+	return t.Transport.WriteFrame(f)
+}
+
+func TestSessionBatching(t *testing.T) {
+	const n = 50
+
+	received := make(chan protocmd.CmdMessage, n)
+	h := protocmd.HandlerFunc(func(s *protocmd.Session, msg protocmd.CmdMessage) { received <- msg })
+
+	a, b := net.Pipe()
+	ct := &countingTransport{Transport: protocmd.NewStreamTransport(a, nil)}
+	s1 := protocmd.NewTransportSession(ct, nil, &protocmd.SessionConfig{BatchWindow: 50 * time.Millisecond, SendQueueSize: n})
+	s2 := protocmd.NewSession(b, h, nil)
+	s1.Start()
+	s2.Start()
+	t.Cleanup(func() {
+		s1.Close()
+		s2.Close()
+	})
+
+	for i := 0; i < n; i++ {
+		if err := s1.Send(&protos.TestReq{Uid: fmt.Sprint(i)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for i := 0; i < n; i++ {
+		select {
+		case msg := <-received:
+			if uid := msg.(*protos.TestReq).Uid; uid != fmt.Sprint(i) {
+				t.Fatalf("got uid %v, want %v", uid, i)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for message %v", i)
+		}
+	}
+	if frames := ct.frames.Load(); frames >= n {
+		t.Fatalf("%v messages were written in %v frames", n, frames)
+	}
+}
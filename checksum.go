@@ -0,0 +1,194 @@
+package protocmd
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"math/bits"
+)
+
+// Checksum selects the checksum appended to encoded frames.
+type Checksum uint8
+
+const (
+	ChecksumNone  Checksum = iota
+	ChecksumCRC32          // CRC-32 of IEEE
+	ChecksumXXH32          // XXH32 with seed 0
+)
+
+func (c Checksum) String() string {
+	switch c {
+	case ChecksumNone:
+		return "none"
+	case ChecksumCRC32:
+		return "crc32"
+	case ChecksumXXH32:
+		return "xxh32"
+	default:
+		return fmt.Sprintf("Checksum(%d)", uint8(c))
+	}
+}
+
+func (c Checksum) flag() FrameFlags {
+	switch c {
+	case ChecksumCRC32:
+		return FlagCRC32
+	case ChecksumXXH32:
+		return FlagXXH32
+	default:
+		return 0
+	}
+}
+
+func (c Checksum) sum(b []byte) uint32 {
+	if c == ChecksumCRC32 {
+		return crc32.ChecksumIEEE(b)
+	}
+	return xxh32(b)
+}
+
+func checksumOfFlags(flags FrameFlags) (Checksum, error) {
+	switch flags & (FlagCRC32 | FlagXXH32) {
+	case 0:
+		return ChecksumNone, nil
+	case FlagCRC32:
+		return ChecksumCRC32, nil
+	case FlagXXH32:
+		return ChecksumXXH32, nil
+	default:
+		return ChecksumNone, fmt.Errorf("%w: both checksum flags are set", ErrFrameCorrupted)
+	}
+}
+
+var (
+	ErrFrameCorrupted  = errors.New("protocmd: frame corrupted")
+	ErrSequenceGap     = errors.New("protocmd: sequence gap")
+	ErrSequenceReplay  = errors.New("protocmd: sequence replay")
+	ErrMissingChecksum = errors.New("protocmd: missing checksum")
+	ErrMissingSequence = errors.New("protocmd: missing sequence number")
+)
+
+// ChecksumError is returned when the checksum of a frame doesn't match.
+// It wraps ErrFrameCorrupted.
+type ChecksumError struct {
+	CmdId    uint16
+	Checksum Checksum
+	Expected uint32 // carried by the frame
+	Actual   uint32 // computed from the frame
+}
+
+func (e *ChecksumError) Error() string {
+	return fmt.Sprintf("protocmd: %v mismatch of cmdId '%v': 0x%08x != 0x%08x", e.Checksum, e.CmdId, e.Actual, e.Expected)
+}
+
+func (e *ChecksumError) Unwrap() error {
+	return ErrFrameCorrupted
+}
+
+// SequenceError is returned when a frame doesn't carry the next sequence
+// number. It wraps ErrSequenceGap if some frames were skipped, and
+// ErrSequenceReplay if the number was seen before.
+type SequenceError struct {
+	CmdId    uint16
+	Expected uint32
+	Actual   uint32
+}
+
+func (e *SequenceError) Error() string {
+	return fmt.Sprintf("%v of cmdId '%v': got %v, want %v", e.Unwrap(), e.CmdId, e.Actual, e.Expected)
+}
+
+func (e *SequenceError) Unwrap() error {
+	if int32(e.Actual-e.Expected) > 0 {
+		return ErrSequenceGap
+	}
+	return ErrSequenceReplay
+}
+
+// Sequencer numbers the frames of a stream. Sequence numbers start from 1,
+// increase by one per frame, and skip 0 when they wrap around. A Sequencer
+// holds the state of both directions; Stamp and Check may be called by
+// different goroutines.
+type Sequencer struct {
+	sent     uint32
+	received uint32
+}
+
+// Stamp sets the next sequence number to f.
+func (s *Sequencer) Stamp(f *Frame) {
+	if s.sent++; s.sent == 0 {
+		s.sent = 1
+	}
+	f.Seq = s.sent
+}
+
+// Check returns a *SequenceError unless f carries the next sequence number
+// of the inbound frames, and ErrMissingSequence if it has none.
+func (s *Sequencer) Check(f *Frame) error {
+	if f.Seq == 0 {
+		return ErrMissingSequence
+	}
+
+	expected := s.received + 1
+	if expected == 0 {
+		expected = 1
+	}
+	if f.Seq != expected {
+		return &SequenceError{CmdId: f.CmdId, Expected: expected, Actual: f.Seq}
+	}
+	s.received = expected
+	return nil
+}
+
+const (
+	xxhPrime1 uint32 = 2654435761
+	xxhPrime2 uint32 = 2246822519
+	xxhPrime3 uint32 = 3266489917
+	xxhPrime4 uint32 = 668265263
+	xxhPrime5 uint32 = 374761393
+)
+
+// xxh32 computes XXH32 of b with seed 0.
+func xxh32(b []byte) uint32 {
+	n := len(b)
+
+	var seed, h uint32
+	if n >= 16 {
+		v1 := seed + xxhPrime1 + xxhPrime2
+		v2 := seed + xxhPrime2
+		v3 := seed
+		v4 := seed - xxhPrime1
+		for ; len(b) >= 16; b = b[16:] {
+			v1 = xxh32Round(v1, binary.LittleEndian.Uint32(b[0:]))
+			v2 = xxh32Round(v2, binary.LittleEndian.Uint32(b[4:]))
+			v3 = xxh32Round(v3, binary.LittleEndian.Uint32(b[8:]))
+			v4 = xxh32Round(v4, binary.LittleEndian.Uint32(b[12:]))
+		}
+		h = bits.RotateLeft32(v1, 1) + bits.RotateLeft32(v2, 7) + bits.RotateLeft32(v3, 12) + bits.RotateLeft32(v4, 18)
+	} else {
+		h = seed + xxhPrime5
+	}
+
+	h += uint32(n)
+	for ; len(b) >= 4; b = b[4:] {
+		h += binary.LittleEndian.Uint32(b) * xxhPrime3
+		h = bits.RotateLeft32(h, 17) * xxhPrime4
+	}
+	for _, c := range b {
+		h += uint32(c) * xxhPrime5
+		h = bits.RotateLeft32(h, 11) * xxhPrime1
+	}
+
+	h ^= h >> 15
+	h *= xxhPrime2
+	h ^= h >> 13
+	h *= xxhPrime3
+	h ^= h >> 16
+	return h
+}
+
+func xxh32Round(acc, lane uint32) uint32 {
+	acc += lane * xxhPrime2
+	return bits.RotateLeft32(acc, 13) * xxhPrime1
+}
@@ -0,0 +1,112 @@
+package protocmd_test
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"github.com/stalomeow/protocmd"
+	"github.com/stalomeow/protocmd/examples/go/protos"
+	"testing"
+)
+
+func TestFrameChecksum(t *testing.T) {
+	tests := []struct {
+		checksum protocmd.Checksum
+		seq      uint32
+		wire     string
+	}{
+		{protocmd.ChecksumCRC32, 0, "00000007000108616263149ccf77"},
+		{protocmd.ChecksumCRC32, 1, "0000000b00010c00000001616263c097003a"},
+		{protocmd.ChecksumXXH32, 0, "0000000700011061626389b5425f"},
+		{protocmd.ChecksumXXH32, 1, "0000000b000114000000016162634d473b54"},
+	}
+
+	for _, tt := range tests {
+		codec := &protocmd.FrameCodec{Checksum: tt.checksum}
+		b, err := codec.AppendFrame(nil, &protocmd.Frame{CmdId: 1, Seq: tt.seq, Payload: []byte("abc")})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := hex.EncodeToString(b); got != tt.wire {
+			t.Errorf("%v: AppendFrame() = %s, want %s", tt.checksum, got, tt.wire)
+		}
+
+		// Checksums are verified even if the codec doesn't ask for them.
+		f, n, err := protocmd.DefaultFrameCodec.ParseFrame(b)
+		if err != nil {
+			t.Fatalf("%v: %v", tt.checksum, err)
+		}
+		if n != len(b) || f.Flags != 0 || f.Seq != tt.seq || string(f.Payload) != "abc" {
+			t.Errorf("%v: ParseFrame() = %+v, %v", tt.checksum, f, n)
+		}
+
+		b[len(b)-5] ^= 1
+		_, _, err = codec.ParseFrame(b)
+		var ce *protocmd.ChecksumError
+		if !errors.Is(err, protocmd.ErrFrameCorrupted) || !errors.As(err, &ce) || ce.Checksum != tt.checksum {
+			t.Errorf("%v: ParseFrame() of a corrupted frame returned %v", tt.checksum, err)
+		}
+	}
+
+	b, _ := protocmd.DefaultFrameCodec.AppendFrame(nil, &protocmd.Frame{CmdId: 1, Payload: []byte("abc")})
+	if _, _, err := (&protocmd.FrameCodec{Checksum: protocmd.ChecksumCRC32}).ParseFrame(b); err != protocmd.ErrMissingChecksum {
+		t.Errorf("ParseFrame() of a frame without checksum returned %v", err)
+	}
+}
+
+func TestFrameSequence(t *testing.T) {
+	codec := &protocmd.FrameCodec{Sequence: true}
+
+	var stream bytes.Buffer
+	w := protocmd.NewFrameWriter(&stream, codec)
+	for i := 0; i < 3; i++ {
+		if err := w.WriteMessage(&protos.TestReq{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	r := protocmd.NewFrameReader(&stream, codec)
+	for i := uint32(1); i <= 3; i++ {
+		f, err := r.ReadFrame()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if f.Seq != i {
+			t.Fatalf("Seq = %v, want %v", f.Seq, i)
+		}
+	}
+}
+
+func TestFrameSequenceErrors(t *testing.T) {
+	tests := []struct {
+		seqs []uint32
+		err  error
+	}{
+		{[]uint32{1, 1}, protocmd.ErrSequenceReplay},
+		{[]uint32{1, 3}, protocmd.ErrSequenceGap},
+		{[]uint32{2}, protocmd.ErrSequenceGap},
+		{[]uint32{1, 0}, protocmd.ErrMissingSequence},
+	}
+
+	for _, tt := range tests {
+		var b []byte
+		for _, seq := range tt.seqs {
+			b, _ = protocmd.DefaultFrameCodec.AppendFrame(b, &protocmd.Frame{CmdId: protos.TestReq_CmdId, Seq: seq})
+		}
+
+		r := protocmd.NewFrameReader(bytes.NewReader(b), &protocmd.FrameCodec{Sequence: true})
+		var err error
+		for range tt.seqs {
+			if _, err = r.ReadFrame(); err != nil {
+				break
+			}
+		}
+		if !errors.Is(err, tt.err) {
+			t.Errorf("%v: ReadFrame() returned %v, want %v", tt.seqs, err, tt.err)
+		}
+
+		var se *protocmd.SequenceError
+		if errors.As(err, &se) && se.Actual != tt.seqs[len(tt.seqs)-1] {
+			t.Errorf("%v: SequenceError.Actual = %v", tt.seqs, se.Actual)
+		}
+	}
+}
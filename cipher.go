@@ -0,0 +1,181 @@
+package protocmd
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+)
+
+// SessionKeySize is the size of the keys derived by DeriveSessionKeys.
+const SessionKeySize = 32
+
+var (
+	ErrFrameEncrypted = errors.New("protocmd: frame is encrypted")
+	ErrNotEncrypted   = errors.New("protocmd: frame is not encrypted")
+	ErrAuthFailed     = errors.New("protocmd: message authentication failed")
+	ErrNonceExhausted = errors.New("protocmd: nonce exhausted")
+)
+
+// Cipher encrypts the frames of one direction of a session. Nonces come from
+// a counter, so frames must be opened in the order they were sealed: a lost,
+// replayed or reordered frame fails to open. A Cipher isn't safe for
+// concurrent use.
+type Cipher interface {
+	// Seal appends the encrypted and authenticated plaintext to dst.
+	// additionalData is authenticated but not encrypted.
+	Seal(dst, plaintext, additionalData []byte) ([]byte, error)
+
+	// Open appends the decrypted ciphertext to dst. It returns ErrAuthFailed
+	// if ciphertext or additionalData was tampered with.
+	Open(dst, ciphertext, additionalData []byte) ([]byte, error)
+}
+
+// aesGCMCipher uses the counter of sealed or opened frames as the nonce.
+type aesGCMCipher struct {
+	aead    cipher.AEAD
+	counter uint64
+	nonce   [12]byte
+}
+
+// NewAESGCMCipher creates a Cipher of AES-GCM. The key must be 16, 24 or
+// 32 bytes long. The nonce of the n-th frame is 4 zero bytes followed by n
+// in big-endian, starting from zero, so a key must never be used in more
+// than one direction.
+func NewAESGCMCipher(key []byte) (Cipher, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &aesGCMCipher{aead: aead}, nil
+}
+
+func (c *aesGCMCipher) nextNonce() ([]byte, error) {
+	if c.counter == math.MaxUint64 {
+		return nil, ErrNonceExhausted
+	}
+	binary.BigEndian.PutUint64(c.nonce[4:], c.counter)
+	return c.nonce[:], nil
+}
+
+func (c *aesGCMCipher) Seal(dst, plaintext, additionalData []byte) ([]byte, error) {
+	nonce, err := c.nextNonce()
+	if err != nil {
+		return nil, err
+	}
+	c.counter++
+	return c.aead.Seal(dst, nonce, plaintext, additionalData), nil
+}
+
+func (c *aesGCMCipher) Open(dst, ciphertext, additionalData []byte) ([]byte, error) {
+	nonce, err := c.nextNonce()
+	if err != nil {
+		return nil, err
+	}
+	out, err := c.aead.Open(dst, nonce, ciphertext, additionalData)
+	if err != nil {
+		return nil, ErrAuthFailed
+	}
+	c.counter++
+	return out, nil
+}
+
+// SealFrame encrypts the payload of f with c and sets FlagEncrypted.
+// The cmdId, flags and payload codec are authenticated as well.
+func SealFrame(c Cipher, f *Frame) error {
+	if f.Flags&FlagEncrypted != 0 {
+		return ErrFrameEncrypted
+	}
+
+	flags := f.Flags | FlagEncrypted
+	payload, err := c.Seal(nil, f.Payload, frameAdditionalData(f, flags))
+	if err != nil {
+		return fmt.Errorf("failed to encrypt cmdId '%v': %w", f.CmdId, err)
+	}
+	f.Flags = flags
+	f.Payload = payload
+	return nil
+}
+
+// OpenFrame decrypts the payload of f with c and clears FlagEncrypted.
+func OpenFrame(c Cipher, f *Frame) error {
+	if f.Flags&FlagEncrypted == 0 {
+		return ErrNotEncrypted
+	}
+
+	payload, err := c.Open(nil, f.Payload, frameAdditionalData(f, f.Flags))
+	if err != nil {
+		return fmt.Errorf("failed to decrypt cmdId '%v': %w", f.CmdId, err)
+	}
+	f.Flags &^= FlagEncrypted
+	f.Payload = payload
+	return nil
+}
+
+// frameAdditionalData returns the cmdId and flags of f, followed by the
+// payload codec unless it's ProtoPayloadCodec.
+func frameAdditionalData(f *Frame, flags FrameFlags) []byte {
+	ad := []byte{byte(f.CmdId >> 8), byte(f.CmdId), byte(flags)}
+	if f.Codec != ProtoPayloadCodecId {
+		ad = append(ad, f.Codec)
+	}
+	return ad
+}
+
+// DeriveSessionKeys derives the keys of both directions after a key
+// exchange: sendKey for the frames sent with priv, and recvKey for the frames
+// sent by the owner of peerPublicKey. Each key is HKDF-SHA256 of the X25519
+// shared secret with an empty salt, and the info is "protocmd session key"
+// followed by the public keys of the sender and the receiver.
+func DeriveSessionKeys(priv *ecdh.PrivateKey, peerPublicKey []byte) (sendKey, recvKey []byte, err error) {
+	peer, err := ecdh.X25519().NewPublicKey(peerPublicKey)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to parse the public key of the peer: %w", err)
+	}
+	secret, err := priv.ECDH(peer)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	pub := priv.PublicKey().Bytes()
+	sendKey = hkdfSHA256(secret, nil, sessionKeyInfo(pub, peerPublicKey), SessionKeySize)
+	recvKey = hkdfSHA256(secret, nil, sessionKeyInfo(peerPublicKey, pub), SessionKeySize)
+	return sendKey, recvKey, nil
+}
+
+func sessionKeyInfo(sender, receiver []byte) []byte {
+	info := []byte("protocmd session key")
+	info = append(info, sender...)
+	return append(info, receiver...)
+}
+
+// hkdfSHA256 implements HKDF of RFC 5869. length must not exceed 255*32.
+func hkdfSHA256(secret, salt, info []byte, length int) []byte {
+	if salt == nil {
+		salt = make([]byte, sha256.Size)
+	}
+	extractor := hmac.New(sha256.New, salt)
+	extractor.Write(secret)
+	prk := extractor.Sum(nil)
+
+	expander := hmac.New(sha256.New, prk)
+	var out, t []byte
+	for i := byte(1); len(out) < length; i++ {
+		expander.Reset()
+		expander.Write(t)
+		expander.Write(info)
+		expander.Write([]byte{i})
+		t = expander.Sum(nil)
+		out = append(out, t...)
+	}
+	return out[:length]
+}
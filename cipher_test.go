@@ -0,0 +1,189 @@
+package protocmd_test
+
+import (
+	"bytes"
+	"crypto/ecdh"
+	"encoding/hex"
+	"errors"
+	"github.com/stalomeow/protocmd"
+	"github.com/stalomeow/protocmd/examples/go/protos"
+	"google.golang.org/protobuf/proto"
+	"testing"
+	"time"
+)
+
+func unhex(t *testing.T, s string) []byte {
+	t.Helper()
+
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+// The first frame of a direction uses a zero nonce, so it matches the test
+// cases of the GCM specification with a zero IV.
+func TestAESGCMCipher(t *testing.T) {
+	tests := []struct {
+		key, plaintext, ciphertext string
+	}{
+		{
+			key:        "00000000000000000000000000000000",
+			plaintext:  "",
+			ciphertext: "58e2fccefa7e3061367f1d57a4e7455a",
+		},
+		{
+			key:        "00000000000000000000000000000000",
+			plaintext:  "00000000000000000000000000000000",
+			ciphertext: "0388dace60b6a392f328c2b971b2fe78ab6e47d42cec13bdf53a67b21257bddf",
+		},
+		{
+			key:        "0000000000000000000000000000000000000000000000000000000000000000",
+			plaintext:  "00000000000000000000000000000000",
+			ciphertext: "cea7403d4d606b6e074ec5d3baf39d18d0d1c8a799996bf0265b98b5d48ab919",
+		},
+	}
+
+	for _, tt := range tests {
+		sealer, err := protocmd.NewAESGCMCipher(unhex(t, tt.key))
+		if err != nil {
+			t.Fatal(err)
+		}
+		opener, _ := protocmd.NewAESGCMCipher(unhex(t, tt.key))
+
+		ciphertext, err := sealer.Seal(nil, unhex(t, tt.plaintext), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := hex.EncodeToString(ciphertext); got != tt.ciphertext {
+			t.Errorf("Seal() = %s, want %s", got, tt.ciphertext)
+		}
+
+		plaintext, err := opener.Open(nil, ciphertext, nil)
+		if err != nil || !bytes.Equal(plaintext, unhex(t, tt.plaintext)) {
+			t.Errorf("Open() = %x, %v", plaintext, err)
+		}
+
+		// The counter has moved on, so the same ciphertext can't be opened twice.
+		if _, err := opener.Open(nil, ciphertext, nil); err != protocmd.ErrAuthFailed {
+			t.Errorf("replayed Open() returned %v, want %v", err, protocmd.ErrAuthFailed)
+		}
+	}
+}
+
+// Keys of RFC 7748, section 6.1.
+func TestDeriveSessionKeys(t *testing.T) {
+	alice, err := ecdh.X25519().NewPrivateKey(unhex(t, "77076d0a7318a57d3c16c17251b26645df4c2f87ebc0992ab177fba51db92c2a"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	bob, err := ecdh.X25519().NewPrivateKey(unhex(t, "5dab087e624a8a4b79e17f8b83800ee66f3bb1292618b6fd1c2f8b27ff88e0eb"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	aliceSend, aliceRecv, err := protocmd.DeriveSessionKeys(alice, bob.PublicKey().Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	bobSend, bobRecv, err := protocmd.DeriveSessionKeys(bob, alice.PublicKey().Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const aliceToBob = "c8778349b3ef37cb63fed9ed048ed8acba10211b6331b64802afafc7e30df18c"
+	const bobToAlice = "7dd389aae04092b7900dcca245bab4a897ca256bacb5ca266225a1f585c72224"
+	if hex.EncodeToString(aliceSend) != aliceToBob || hex.EncodeToString(bobRecv) != aliceToBob {
+		t.Errorf("keys from Alice to Bob are %x and %x, want %s", aliceSend, bobRecv, aliceToBob)
+	}
+	if hex.EncodeToString(bobSend) != bobToAlice || hex.EncodeToString(aliceRecv) != bobToAlice {
+		t.Errorf("keys from Bob to Alice are %x and %x, want %s", bobSend, aliceRecv, bobToAlice)
+	}
+
+	if _, _, err := protocmd.DeriveSessionKeys(alice, make([]byte, 32)); err == nil {
+		t.Error("DeriveSessionKeys accepted a low-order public key")
+	}
+}
+
+func TestSealFrame(t *testing.T) {
+	key := make([]byte, protocmd.SessionKeySize)
+	sealer, _ := protocmd.NewAESGCMCipher(key)
+	opener, _ := protocmd.NewAESGCMCipher(key)
+
+	msg := &protos.TestReq{Uid: "123321"}
+	f, err := protocmd.DefaultFrameCodec.Encode(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := protocmd.SealFrame(sealer, f); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := protocmd.DefaultFrameCodec.Decode(f); err != protocmd.ErrFrameEncrypted {
+		t.Fatalf("Decode returned %v, want %v", err, protocmd.ErrFrameEncrypted)
+	}
+
+	// The cmdId is authenticated.
+	tampered := *f
+	tampered.CmdId++
+	if err := protocmd.OpenFrame(opener, &tampered); !errors.Is(err, protocmd.ErrAuthFailed) {
+		t.Fatalf("OpenFrame returned %v, want %v", err, protocmd.ErrAuthFailed)
+	}
+
+	if err := protocmd.OpenFrame(opener, f); err != nil {
+		t.Fatal(err)
+	}
+	got, err := protocmd.DefaultFrameCodec.Decode(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !proto.Equal(got, msg) {
+		t.Fatalf("got %v", protocmd.CmdString(got))
+	}
+}
+
+func TestSessionEncryption(t *testing.T) {
+	received := make(chan protocmd.CmdMessage, 10)
+	h := protocmd.HandlerFunc(func(s *protocmd.Session, msg protocmd.CmdMessage) { received <- msg })
+	s1, s2 := pipeSessions(t, h, h, nil, nil)
+
+	if err := s1.Send(&protos.TestReq{Uid: "before"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s1.StartEncryption(); err != nil {
+		t.Fatal(err)
+	}
+	if err := s1.StartEncryption(); err != protocmd.ErrEncryptionStarted {
+		t.Fatalf("second StartEncryption returned %v", err)
+	}
+	if err := s1.Send(&protos.TestReq{Uid: "after"}); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, uid := range []string{"before", "after"} {
+		select {
+		case msg := <-received:
+			if msg.(*protos.TestReq).Uid != uid {
+				t.Fatalf("got %v, want uid %q", protocmd.CmdString(msg), uid)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for %q", uid)
+		}
+	}
+	if !s1.Encrypted() || !s2.Encrypted() {
+		t.Fatal("sessions aren't encrypted")
+	}
+
+	// The answering side encrypts as well.
+	if err := s2.Send(&protos.TestReq{Uid: "reply"}); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case msg := <-received:
+		if msg.(*protos.TestReq).Uid != "reply" {
+			t.Fatalf("got %v", protocmd.CmdString(msg))
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the reply")
+	}
+}
@@ -0,0 +1,264 @@
+package protocmd
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultDialTimeout = 10 * time.Second
+	DefaultMinBackoff  = 100 * time.Millisecond
+	DefaultMaxBackoff  = 30 * time.Second
+)
+
+var (
+	ErrClientClosed = errors.New("protocmd: client closed")
+	ErrNotConnected = errors.New("protocmd: not connected")
+)
+
+// Client connects to a server and runs a Session over the connection.
+// If Reconnect is set, a new session is started whenever the current one
+// ends, until Close is called.
+type Client struct {
+	// Addr is the TCP address of the server.
+	Addr string
+
+	// Handler handles the messages of the sessions.
+	Handler Handler
+
+	// SessionConfig configures the sessions. Its OnClose is still called.
+	SessionConfig *SessionConfig
+
+	// DialTimeout limits each attempt to connect. If it's zero,
+	// DefaultDialTimeout is used.
+	DialTimeout time.Duration
+
+	// DialContext connects to the server. If it's nil, net.Dialer is used.
+	DialContext func(ctx context.Context, network, addr string) (net.Conn, error)
+
+	// DialTransport connects to the server over a transport other than TCP,
+	// such as WebSocket. If it's not nil, Addr and DialContext are ignored.
+	DialTransport func(ctx context.Context) (Transport, error)
+
+	// Reconnect enables reconnecting after the session ends. The delay between
+	// attempts starts at MinBackoff and doubles after each failure up to
+	// MaxBackoff. If they are zero, DefaultMinBackoff and DefaultMaxBackoff
+	// are used.
+	Reconnect  bool
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+
+	// OnConnect is called before a new session starts.
+	OnConnect func(s *Session)
+
+	mu      sync.Mutex
+	session *Session
+	closed  bool
+	closing chan struct{}
+	done    chan struct{}
+}
+
+// Connect dials the server and starts the first session. If Reconnect is
+// set, it also starts reconnecting in the background. Otherwise, Connect
+// can be called again once the session has ended.
+func (c *Client) Connect(ctx context.Context) error {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return ErrClientClosed
+	}
+	if c.closing != nil {
+		c.mu.Unlock()
+		return errors.New("protocmd: client already connected")
+	}
+	closing, done := make(chan struct{}), make(chan struct{})
+	c.closing, c.done = closing, done
+	c.mu.Unlock()
+
+	s, err := c.connect(ctx)
+	if err != nil {
+		c.mu.Lock()
+		c.closing, c.done = nil, nil
+		c.mu.Unlock()
+		close(done) // in case Close is waiting
+		return err
+	}
+
+	go c.run(s, closing, done)
+	return nil
+}
+
+// Session returns the current session, or nil if it's not connected.
+func (c *Client) Session() *Session {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.session
+}
+
+// Send queues msg into the current session.
+func (c *Client) Send(msg CmdMessage) error {
+	s := c.Session()
+	if s == nil {
+		return ErrNotConnected
+	}
+	return s.Send(msg)
+}
+
+// Close stops reconnecting, closes the current session gracefully and
+// waits for it to finish.
+func (c *Client) Close() error {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return nil
+	}
+	c.closed = true
+	closing, done := c.closing, c.done
+	c.mu.Unlock()
+
+	if closing == nil {
+		return nil // never connected
+	}
+	close(closing)
+	<-done
+	return nil
+}
+
+// run waits for the sessions to end and reconnects.
+func (c *Client) run(s *Session, closing, done chan struct{}) {
+	defer close(done)
+	defer func() {
+		// Allow connecting again if the client isn't closed.
+		c.mu.Lock()
+		if !c.closed && c.closing == closing {
+			c.closing, c.done = nil, nil
+		}
+		c.mu.Unlock()
+	}()
+
+	backoff := c.minBackoff()
+	for {
+		select {
+		case <-s.Done():
+		case <-closing:
+			s.Close()
+			<-s.Done()
+			return
+		}
+
+		if !c.Reconnect {
+			return
+		}
+
+		for {
+			select {
+			case <-time.After(backoff):
+			case <-closing:
+				return
+			}
+
+			ctx, cancel := context.WithCancel(context.Background())
+			go func() {
+				select {
+				case <-closing:
+					cancel()
+				case <-ctx.Done():
+				}
+			}()
+			next, err := c.connect(ctx)
+			cancel()
+
+			if err == nil {
+				s = next
+				backoff = c.minBackoff()
+				break
+			}
+			if err == ErrClientClosed {
+				return
+			}
+			if backoff *= 2; backoff > c.maxBackoff() {
+				backoff = c.maxBackoff()
+			}
+		}
+	}
+}
+
+func (c *Client) connect(ctx context.Context) (*Session, error) {
+	timeout := c.DialTimeout
+	if timeout <= 0 {
+		timeout = DefaultDialTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	t, err := c.dial(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	config := &SessionConfig{}
+	if c.SessionConfig != nil {
+		*config = *c.SessionConfig
+	}
+	onClose := config.OnClose
+	config.OnClose = func(s *Session, err error) {
+		c.mu.Lock()
+		if c.session == s {
+			c.session = nil
+		}
+		c.mu.Unlock()
+
+		if onClose != nil {
+			onClose(s, err)
+		}
+	}
+	s := NewTransportSession(t, c.Handler, config)
+
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		t.Close()
+		return nil, ErrClientClosed
+	}
+	c.session = s
+	c.mu.Unlock()
+
+	if c.OnConnect != nil {
+		c.OnConnect(s)
+	}
+	s.Start()
+	return s, nil
+}
+
+func (c *Client) dial(ctx context.Context) (Transport, error) {
+	if c.DialTransport != nil {
+		return c.DialTransport(ctx)
+	}
+
+	dial := c.DialContext
+	if dial == nil {
+		dial = (&net.Dialer{}).DialContext
+	}
+	conn, err := dial(ctx, "tcp", c.Addr)
+	if err != nil {
+		return nil, err
+	}
+	return NewStreamTransport(conn, c.SessionConfig.codec()), nil
+}
+
+func (c *Client) minBackoff() time.Duration {
+	if c.MinBackoff <= 0 {
+		return DefaultMinBackoff
+	}
+	return c.MinBackoff
+}
+
+func (c *Client) maxBackoff() time.Duration {
+	if c.MaxBackoff <= 0 {
+		return DefaultMaxBackoff
+	}
+	return c.MaxBackoff
+}
@@ -0,0 +1,110 @@
+package protocmd_test
+
+import (
+	"context"
+	"github.com/stalomeow/protocmd"
+	"github.com/stalomeow/protocmd/examples/go/protos"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestClientReconnect(t *testing.T) {
+	// The server drops every session after echoing its first message.
+	addr := startServer(t, &protocmd.Server{
+		Handler: protocmd.HandlerFunc(func(s *protocmd.Session, msg protocmd.CmdMessage) {
+			s.Send(msg)
+			s.Close()
+		}),
+	})
+
+	connected := make(chan *protocmd.Session, 10)
+	h, ch := collect()
+	c := &protocmd.Client{
+		Addr:       addr,
+		Handler:    h,
+		Reconnect:  true,
+		MinBackoff: 10 * time.Millisecond,
+		OnConnect:  func(s *protocmd.Session) { connected <- s },
+	}
+	if err := c.Connect(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, uid := range []string{"first", "second", "third"} {
+		var s *protocmd.Session
+		select {
+		case s = <-connected:
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out waiting for reconnecting")
+		}
+
+		if err := s.Send(&protos.TestReq{Uid: uid}); err != nil {
+			t.Fatal(err)
+		}
+		if req := receive(t, ch).(*protos.TestReq); req.Uid != uid {
+			t.Fatalf("got %q, want %q", req.Uid, uid)
+		}
+	}
+
+	c.Close()
+	if err := c.Send(&protos.TestReq{}); err != protocmd.ErrNotConnected {
+		t.Fatalf("Send after Close returned %v", err)
+	}
+	if err := c.Connect(context.Background()); err != protocmd.ErrClientClosed {
+		t.Fatalf("Connect after Close returned %v", err)
+	}
+}
+
+func TestClientDialTimeout(t *testing.T) {
+	c := &protocmd.Client{
+		DialTimeout: 10 * time.Millisecond,
+		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			<-ctx.Done()
+			return nil, ctx.Err()
+		},
+	}
+	if err := c.Connect(context.Background()); err != context.DeadlineExceeded {
+		t.Fatalf("Connect returned %v, want %v", err, context.DeadlineExceeded)
+	}
+	if s := c.Session(); s != nil {
+		t.Fatal("expected no session after failing to connect")
+	}
+}
+
+func TestClientConnectAgain(t *testing.T) {
+	// The server drops every session after echoing its first message.
+	addr := startServer(t, &protocmd.Server{
+		Handler: protocmd.HandlerFunc(func(s *protocmd.Session, msg protocmd.CmdMessage) {
+			s.Send(msg)
+			s.Close()
+		}),
+	})
+
+	h, ch := collect()
+	c := &protocmd.Client{Addr: addr, Handler: h}
+	defer c.Close()
+
+	for _, uid := range []string{"first", "second"} {
+		// The previous session has ended, but the client may still be
+		// cleaning up after it.
+		deadline := time.Now().Add(5 * time.Second)
+		err := c.Connect(context.Background())
+		for err != nil && time.Now().Before(deadline) {
+			time.Sleep(10 * time.Millisecond)
+			err = c.Connect(context.Background())
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		s := c.Session()
+		if err := s.Send(&protos.TestReq{Uid: uid}); err != nil {
+			t.Fatal(err)
+		}
+		if req := receive(t, ch).(*protos.TestReq); req.Uid != uid {
+			t.Fatalf("got %q, want %q", req.Uid, uid)
+		}
+		waitDone(t, s)
+	}
+}
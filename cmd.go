@@ -4,6 +4,8 @@ import (
 	"fmt"
 	"google.golang.org/protobuf/proto"
 	"google.golang.org/protobuf/reflect/protoreflect"
+	"sync"
+	"sync/atomic"
 )
 
 type CmdMessage interface {
@@ -13,8 +15,13 @@ type CmdMessage interface {
 }
 
 type cmdInfo struct {
-	name       string
-	factory    func() CmdMessage
+	name    string
+	factory func() CmdMessage
+	pool    atomic.Pointer[sync.Pool] // set by EnablePool
+
+	// descriptor is resolved on first use, because Register may be called
+	// by an init function running before the file descriptor is built.
+	descOnce   sync.Once
 	descriptor protoreflect.MessageDescriptor
 }
 
@@ -26,10 +33,10 @@ func Register(factory func() CmdMessage) {
 	cmdId := msg.CmdId()
 
 	cmdInfoMap[cmdId] = &cmdInfo{
-		name:       msg.CmdName(),
-		factory:    factory,
-		descriptor: msg.ProtoReflect().Descriptor(),
+		name:    msg.CmdName(),
+		factory: factory,
 	}
+	cmdIdMap[msg.CmdName()] = cmdId
 }
 
 func NewMessageByCmdId(cmdId uint16) (CmdMessage, error) {
@@ -45,19 +52,32 @@ func MessageDescriptorByCmdId(cmdId uint16) (protoreflect.MessageDescriptor, err
 	if !ok {
 		return nil, fmt.Errorf("failed to get MessageDescriptor with cmdId '%v' which was not registered", cmdId)
 	}
+	info.descOnce.Do(func() {
+		info.descriptor = info.factory().ProtoReflect().Descriptor()
+	})
 	return info.descriptor, nil
 }
 
+// CmdCount returns the number of registered messages, not counting the
+// control messages.
 func CmdCount() int {
-	return len(cmdInfoMap)
+	n := 0
+	for k := range cmdInfoMap {
+		if !IsControlCmdId(k) {
+			n++
+		}
+	}
+	return n
 }
 
+// AllCmdIds returns the cmdIds of the registered messages, not counting
+// the control messages.
 func AllCmdIds() []uint16 {
-	ids := make([]uint16, len(cmdInfoMap))
-	i := 0
+	ids := make([]uint16, 0, len(cmdInfoMap))
 	for k := range cmdInfoMap {
-		ids[i] = k
-		i++
+		if !IsControlCmdId(k) {
+			ids = append(ids, k)
+		}
 	}
 	return ids
 }
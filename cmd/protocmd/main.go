@@ -0,0 +1,7 @@
+package main
+
+import "github.com/stalomeow/protocmd/cmdtool"
+
+func main() {
+	cmdtool.Main()
+}
@@ -0,0 +1,28 @@
+package protocmd_test
+
+import (
+	"github.com/stalomeow/protocmd"
+	"github.com/stalomeow/protocmd/examples/go/protos"
+	"slices"
+	"testing"
+)
+
+func TestAllCmdIds(t *testing.T) {
+	ids := protocmd.AllCmdIds()
+	if len(ids) != protocmd.CmdCount() {
+		t.Errorf("got %d cmdIds, but CmdCount returned %d", len(ids), protocmd.CmdCount())
+	}
+	if !slices.Contains(ids, protos.TestReq_CmdId) {
+		t.Errorf("got %v, want %v in it", ids, protos.TestReq_CmdId)
+	}
+
+	// Control messages are registered, but not counted.
+	for _, id := range ids {
+		if protocmd.IsControlCmdId(id) {
+			t.Errorf("got control cmdId %#x", id)
+		}
+	}
+	if name, ok := protocmd.CmdName(protocmd.Ping_CmdId); !ok || name != "Ping" {
+		t.Errorf("CmdName(Ping_CmdId) returned %q, %v", name, ok)
+	}
+}
@@ -0,0 +1,199 @@
+// Package cmdlog records cmd sessions into .cmdlog files and replays them.
+//
+// A .cmdlog file starts with an 8-byte magic "CMDLOG\x01\x00" followed by the
+// time of the first record in Unix nanoseconds (int64, big-endian). Each record
+// then consists of:
+//
+//	uvarint  nanoseconds since the previous record
+//	byte     direction (0 = client to server, 1 = server to client)
+//	uvarint  session id
+//	uvarint  length of the frame
+//	bytes    the frame in its wire form
+package cmdlog
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"github.com/stalomeow/protocmd"
+	"io"
+	"sync"
+	"time"
+)
+
+const magic = "CMDLOG\x01\x00"
+
+var ErrInvalidFormat = errors.New("cmdlog: invalid format")
+
+type Direction uint8
+
+const (
+	ClientToServer Direction = iota
+	ServerToClient
+)
+
+func (d Direction) String() string {
+	if d == ClientToServer {
+		return "c2s"
+	}
+	return "s2c"
+}
+
+type Record struct {
+	Time      time.Time
+	Direction Direction
+	SessionId uint64
+	Frame     *protocmd.Frame
+}
+
+// Writer writes records into a .cmdlog file. It's safe for concurrent use.
+type Writer struct {
+	mu       sync.Mutex
+	w        *bufio.Writer
+	codec    *protocmd.FrameCodec
+	started  bool
+	lastTime time.Time
+	buf      []byte
+}
+
+// NewWriter creates a Writer. codec is used to serialize frames; if it's nil,
+// protocmd.DefaultFrameCodec is used.
+func NewWriter(w io.Writer, codec *protocmd.FrameCodec) *Writer {
+	return &Writer{w: bufio.NewWriter(w), codec: codec}
+}
+
+// Write appends a record. Records must be written in time order; a record
+// older than the previous one is stored with the time of the previous one.
+func (w *Writer) Write(rec *Record) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	frame, err := w.codec.AppendFrame(nil, rec.Frame)
+	if err != nil {
+		return err
+	}
+
+	buf := w.buf[:0]
+	if !w.started {
+		buf = append(buf, magic...)
+		buf = binary.BigEndian.AppendUint64(buf, uint64(rec.Time.UnixNano()))
+		w.lastTime = rec.Time
+		w.started = true
+	}
+
+	var delta time.Duration
+	if rec.Time.After(w.lastTime) {
+		delta = rec.Time.Sub(w.lastTime)
+		w.lastTime = rec.Time
+	}
+
+	buf = binary.AppendUvarint(buf, uint64(delta))
+	buf = append(buf, byte(rec.Direction))
+	buf = binary.AppendUvarint(buf, rec.SessionId)
+	buf = binary.AppendUvarint(buf, uint64(len(frame)))
+	buf = append(buf, frame...)
+
+	w.buf = buf
+	_, err = w.w.Write(buf)
+	return err
+}
+
+// Flush writes buffered records to the underlying writer.
+func (w *Writer) Flush() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.w.Flush()
+}
+
+// Reader reads records from a .cmdlog file.
+type Reader struct {
+	r        *bufio.Reader
+	codec    *protocmd.FrameCodec
+	started  bool
+	lastTime time.Time
+}
+
+// NewReader creates a Reader. codec is used to parse frames; if it's nil,
+// protocmd.DefaultFrameCodec is used.
+func NewReader(r io.Reader, codec *protocmd.FrameCodec) *Reader {
+	return &Reader{r: bufio.NewReader(r), codec: codec}
+}
+
+// Read returns the next record, or io.EOF at the end of the file.
+func (r *Reader) Read() (*Record, error) {
+	if !r.started {
+		var header [len(magic) + 8]byte
+		if _, err := io.ReadFull(r.r, header[:]); err != nil {
+			if err == io.ErrUnexpectedEOF {
+				return nil, ErrInvalidFormat
+			}
+			return nil, err // io.EOF if the file is empty
+		}
+		if string(header[:len(magic)]) != magic {
+			return nil, ErrInvalidFormat
+		}
+		r.lastTime = time.Unix(0, int64(binary.BigEndian.Uint64(header[len(magic):])))
+		r.started = true
+	}
+
+	delta, err := binary.ReadUvarint(r.r)
+	if err != nil {
+		return nil, err // io.EOF at a record boundary
+	}
+
+	dir, err := r.r.ReadByte()
+	if err != nil {
+		return nil, truncated(err)
+	}
+	if Direction(dir) != ClientToServer && Direction(dir) != ServerToClient {
+		return nil, ErrInvalidFormat
+	}
+
+	sessionId, err := binary.ReadUvarint(r.r)
+	if err != nil {
+		return nil, truncated(err)
+	}
+
+	length, err := binary.ReadUvarint(r.r)
+	if err != nil {
+		return nil, truncated(err)
+	}
+	if length > uint64(protocmd.FrameHeaderSize+r.codecMaxFrameSize()) {
+		return nil, protocmd.ErrFrameTooLarge
+	}
+
+	buf := make([]byte, length)
+	if _, err := io.ReadFull(r.r, buf); err != nil {
+		return nil, truncated(err)
+	}
+
+	f, n, err := r.codec.ParseFrame(buf)
+	if err != nil {
+		return nil, err
+	}
+	if n != len(buf) {
+		return nil, ErrInvalidFormat
+	}
+
+	r.lastTime = r.lastTime.Add(time.Duration(delta))
+	return &Record{
+		Time:      r.lastTime,
+		Direction: Direction(dir),
+		SessionId: sessionId,
+		Frame:     f,
+	}, nil
+}
+
+func (r *Reader) codecMaxFrameSize() int {
+	if r.codec == nil || r.codec.MaxFrameSize <= 0 {
+		return protocmd.DefaultMaxFrameSize
+	}
+	return r.codec.MaxFrameSize
+}
+
+func truncated(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
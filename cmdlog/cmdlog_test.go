@@ -0,0 +1,286 @@
+package cmdlog_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"github.com/stalomeow/protocmd"
+	"github.com/stalomeow/protocmd/cmdlog"
+	"github.com/stalomeow/protocmd/examples/go/protos"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func frame(t *testing.T, msg protocmd.CmdMessage) *protocmd.Frame {
+	t.Helper()
+
+	f, err := protocmd.DefaultFrameCodec.Encode(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return f
+}
+
+func readAll(r *cmdlog.Reader) ([]*cmdlog.Record, error) {
+	var records []*cmdlog.Record
+	for {
+		rec, err := r.Read()
+		if err != nil {
+			return records, err
+		}
+		records = append(records, rec)
+	}
+}
+
+func TestWriterReader(t *testing.T) {
+	codec := &protocmd.FrameCodec{Checksum: protocmd.ChecksumCRC32}
+	start := time.Unix(1700000000, 5)
+	records := []*cmdlog.Record{
+		{Time: start, Direction: cmdlog.ClientToServer, SessionId: 1, Frame: frame(t, &protos.TestReq{Uid: "1"})},
+		{Time: start.Add(time.Millisecond), Direction: cmdlog.ServerToClient, SessionId: 1, Frame: frame(t, &protos.TestRsp{RetCode: 1})},
+		{Time: start.Add(time.Hour), Direction: cmdlog.ClientToServer, SessionId: 1 << 40, Frame: &protocmd.Frame{CmdId: 1010, Seq: 3}},
+		// Records older than the previous one keep the previous time.
+		{Time: start, Direction: cmdlog.ServerToClient, SessionId: 2, Frame: frame(t, &protos.TestReq{Uid: "2"})},
+	}
+
+	var buf bytes.Buffer
+	w := cmdlog.NewWriter(&buf, codec)
+	for _, rec := range records {
+		if err := w.Write(rec); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := readAll(cmdlog.NewReader(&buf, codec))
+	if err != io.EOF {
+		t.Fatalf("got %v, want io.EOF", err)
+	}
+	if len(got) != len(records) {
+		t.Fatalf("got %d records, want %d", len(got), len(records))
+	}
+	for i, want := range records {
+		wantTime := want.Time
+		if i == 3 {
+			wantTime = records[2].Time
+		}
+		r := got[i]
+		if !r.Time.Equal(wantTime) || r.Direction != want.Direction || r.SessionId != want.SessionId {
+			t.Errorf("record %d: got %v %v %v, want %v %v %v", i, r.Time, r.Direction, r.SessionId, wantTime, want.Direction, want.SessionId)
+		}
+		if r.Frame.CmdId != want.Frame.CmdId || r.Frame.Seq != want.Frame.Seq || !bytes.Equal(r.Frame.Payload, want.Frame.Payload) {
+			t.Errorf("record %d: got frame %+v, want %+v", i, r.Frame, want.Frame)
+		}
+	}
+}
+
+func TestReaderTruncated(t *testing.T) {
+	var buf bytes.Buffer
+	w := cmdlog.NewWriter(&buf, nil)
+	start := time.Unix(1700000000, 0)
+
+	// ends holds the offsets at which a file ends at a record boundary.
+	var ends []int
+	for i := 0; i < 3; i++ {
+		rec := &cmdlog.Record{Time: start.Add(time.Duration(i) * time.Second), SessionId: 300, Frame: frame(t, &protos.TestReq{Uid: "uid"})}
+		if err := w.Write(rec); err != nil {
+			t.Fatal(err)
+		}
+		w.Flush()
+		if i == 0 {
+			ends = append(ends, 16)
+		}
+		ends = append(ends, buf.Len())
+	}
+	file := buf.Bytes()
+
+	for n := 0; n < len(file); n++ {
+		records, err := readAll(cmdlog.NewReader(bytes.NewReader(file[:n]), nil))
+
+		complete := -1
+		for i, end := range ends {
+			if end <= n {
+				complete = i
+			}
+		}
+		switch {
+		case n == 0:
+			if err != io.EOF {
+				t.Fatalf("empty file: got %v, want io.EOF", err)
+			}
+		case n < 16:
+			if err != cmdlog.ErrInvalidFormat {
+				t.Fatalf("%d bytes: got %v, want ErrInvalidFormat", n, err)
+			}
+		case n == ends[complete]:
+			if err != io.EOF || len(records) != complete {
+				t.Fatalf("%d bytes: got %d records and %v, want %d records and io.EOF", n, len(records), err, complete)
+			}
+		default:
+			if err != io.ErrUnexpectedEOF || len(records) != complete {
+				t.Fatalf("%d bytes: got %d records and %v, want %d records and io.ErrUnexpectedEOF", n, len(records), err, complete)
+			}
+		}
+	}
+}
+
+func TestReaderInvalid(t *testing.T) {
+	var buf bytes.Buffer
+	w := cmdlog.NewWriter(&buf, nil)
+	w.Write(&cmdlog.Record{Time: time.Unix(0, 0), Frame: frame(t, &protos.TestReq{})})
+	w.Flush()
+	file := buf.Bytes()
+
+	badMagic := bytes.Clone(file)
+	badMagic[0] = 'X'
+	badDirection := bytes.Clone(file)
+	badDirection[17] = 2
+
+	for _, b := range [][]byte{badMagic, badDirection} {
+		if _, err := cmdlog.NewReader(bytes.NewReader(b), nil).Read(); err != cmdlog.ErrInvalidFormat {
+			t.Errorf("got %v, want ErrInvalidFormat", err)
+		}
+	}
+}
+
+func TestTee(t *testing.T) {
+	a, b := net.Pipe()
+	defer a.Close()
+	defer b.Close()
+
+	var buf bytes.Buffer
+	log := cmdlog.NewWriter(&buf, nil)
+	tc := cmdlog.Tee(a, log, 7, cmdlog.ClientSide)
+
+	// The server answers every request.
+	go func() {
+		r := protocmd.NewFrameReader(b, nil)
+		fw := protocmd.NewFrameWriter(b, nil)
+		for {
+			msg, err := r.ReadMessage()
+			if err != nil {
+				return
+			}
+			fw.WriteMessage(&protos.TestRsp{RetCode: int32(len(msg.(*protos.TestReq).Uid))})
+		}
+	}()
+
+	fw := protocmd.NewFrameWriter(tc, nil)
+	fr := protocmd.NewFrameReader(tc, nil)
+	for _, uid := range []string{"a", "bb"} {
+		if err := fw.WriteMessage(&protos.TestReq{Uid: uid}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := fr.ReadMessage(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tc.Err(); err != nil {
+		t.Fatal(err)
+	}
+	log.Flush()
+
+	records, err := readAll(cmdlog.NewReader(&buf, nil))
+	if err != io.EOF {
+		t.Fatal(err)
+	}
+	want := []struct {
+		dir   cmdlog.Direction
+		cmdId uint16
+	}{
+		{cmdlog.ClientToServer, protos.TestReq_CmdId},
+		{cmdlog.ServerToClient, protos.TestRsp_CmdId},
+		{cmdlog.ClientToServer, protos.TestReq_CmdId},
+		{cmdlog.ServerToClient, protos.TestRsp_CmdId},
+	}
+	if len(records) != len(want) {
+		t.Fatalf("got %d records, want %d", len(records), len(want))
+	}
+	for i, rec := range records {
+		if rec.Direction != want[i].dir || rec.Frame.CmdId != want[i].cmdId || rec.SessionId != 7 {
+			t.Errorf("record %d: got %v cmdId %v of session %v", i, rec.Direction, rec.Frame.CmdId, rec.SessionId)
+		}
+	}
+
+	// Bytes which aren't frames stop the recording, but not the connection.
+	go io.Copy(io.Discard, b)
+	if _, err := tc.Write([]byte{0xff, 0xff, 0xff, 0xff, 0, 0, 0}); err != nil {
+		t.Fatal(err)
+	}
+	if !errors.Is(tc.Err(), protocmd.ErrFrameTooLarge) {
+		t.Fatalf("got %v, want ErrFrameTooLarge", tc.Err())
+	}
+}
+
+func TestReplay(t *testing.T) {
+	var buf bytes.Buffer
+	w := cmdlog.NewWriter(&buf, nil)
+	start := time.Unix(1700000000, 0)
+	for i, rec := range []*cmdlog.Record{
+		{Direction: cmdlog.ClientToServer, SessionId: 1, Frame: frame(t, &protos.TestReq{Uid: "a"})},
+		{Direction: cmdlog.ServerToClient, SessionId: 1, Frame: frame(t, &protos.TestRsp{})},
+		{Direction: cmdlog.ClientToServer, SessionId: 2, Frame: frame(t, &protos.TestReq{Uid: "other"})},
+		{Direction: cmdlog.ClientToServer, SessionId: 1, Frame: frame(t, &protos.TestReq{Uid: "bb"})},
+	} {
+		rec.Time = start.Add(time.Duration(i) * 20 * time.Millisecond)
+		w.Write(rec)
+	}
+	w.Flush()
+
+	a, b := net.Pipe()
+	defer a.Close()
+
+	// The server answers each request and closes the connection after the last one.
+	received := make(chan string, 10)
+	go func() {
+		defer b.Close()
+		r := protocmd.NewFrameReader(b, nil)
+		fw := protocmd.NewFrameWriter(b, nil)
+		for i := 0; i < 2; i++ {
+			msg, err := r.ReadMessage()
+			if err != nil {
+				return
+			}
+			uid := msg.(*protos.TestReq).Uid
+			received <- uid
+			fw.WriteMessage(&protos.TestRsp{RetCode: int32(len(uid))})
+		}
+	}()
+
+	var responses []int32
+	rp := &cmdlog.Replayer{
+		Speed: 2,
+		OnFrame: func(f *protocmd.Frame) {
+			msg, _ := protocmd.DefaultFrameCodec.Decode(f)
+			responses = append(responses, msg.(*protos.TestRsp).RetCode)
+		},
+	}
+	begin := time.Now()
+	if err := rp.Replay(context.Background(), cmdlog.NewReader(&buf, nil), 1, a); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(begin); elapsed < 25*time.Millisecond {
+		t.Errorf("replayed 60ms at double speed in %v", elapsed)
+	}
+
+	select {
+	case <-rp.Drained():
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the server to close the connection")
+	}
+	close(received)
+	var uids []string
+	for uid := range received {
+		uids = append(uids, uid)
+	}
+	if len(uids) != 2 || uids[0] != "a" || uids[1] != "bb" {
+		t.Errorf("the server received %v, want [a bb]", uids)
+	}
+	if len(responses) != 2 || responses[0] != 1 || responses[1] != 2 {
+		t.Errorf("got responses %v, want [1 2]", responses)
+	}
+}
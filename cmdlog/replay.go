@@ -0,0 +1,118 @@
+package cmdlog
+
+import (
+	"context"
+	"github.com/stalomeow/protocmd"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// Replayer re-sends the client side of a recorded session.
+type Replayer struct {
+	// Speed scales the intervals between frames: 1 replays at the original
+	// speed, 2 twice as fast. If it's zero or negative, frames are sent
+	// without waiting.
+	Speed float64
+
+	// Codec is used to write frames to and read frames from the server.
+	// If it's nil, protocmd.DefaultFrameCodec is used.
+	Codec *protocmd.FrameCodec
+
+	// OnFrame, if not nil, is called with every frame received from the server
+	// until conn is closed, possibly after Replay returns.
+	OnFrame func(f *protocmd.Frame)
+
+	mu      sync.Mutex
+	drained chan struct{}
+}
+
+// Drained returns a channel which is closed after the connection passed to
+// Replay is closed, by either side, and OnFrame has returned for the last
+// frame received from the server.
+func (rp *Replayer) Drained() <-chan struct{} {
+	return rp.drainedChan()
+}
+
+func (rp *Replayer) drainedChan() chan struct{} {
+	rp.mu.Lock()
+	defer rp.mu.Unlock()
+	if rp.drained == nil {
+		rp.drained = make(chan struct{})
+	}
+	return rp.drained
+}
+
+// Replay reads records from r and sends the client-to-server frames of the
+// session to conn, keeping their original intervals scaled by Speed. It
+// returns after the last frame is sent; the caller owns conn and should
+// close it. A Replayer replays a single session.
+func (rp *Replayer) Replay(ctx context.Context, r *Reader, sessionId uint64, conn net.Conn) error {
+	drained := rp.drainedChan()
+	go func() {
+		defer close(drained)
+		rp.drain(conn)
+	}()
+
+	w := protocmd.NewFrameWriter(conn, rp.Codec)
+	var first time.Time
+	var start time.Time
+	for {
+		rec, err := r.Read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if rec.SessionId != sessionId || rec.Direction != ClientToServer {
+			continue
+		}
+
+		if first.IsZero() {
+			first, start = rec.Time, time.Now()
+		} else if rp.Speed > 0 {
+			target := start.Add(time.Duration(float64(rec.Time.Sub(first)) / rp.Speed))
+			if err := sleepUntil(ctx, target); err != nil {
+				return err
+			}
+		}
+
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := w.WriteFrame(rec.Frame); err != nil {
+			return err
+		}
+	}
+}
+
+func (rp *Replayer) drain(conn net.Conn) {
+	r := protocmd.NewFrameReader(conn, rp.Codec)
+	for {
+		f, err := r.ReadFrame()
+		if err != nil {
+			return
+		}
+		if rp.OnFrame != nil {
+			rp.OnFrame(f)
+		}
+	}
+}
+
+func sleepUntil(ctx context.Context, t time.Time) error {
+	d := time.Until(t)
+	if d <= 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,112 @@
+package cmdlog
+
+import (
+	"errors"
+	"github.com/stalomeow/protocmd"
+	"net"
+	"sync"
+	"time"
+)
+
+// Side is the side of a connection where it is recorded.
+type Side uint8
+
+const (
+	ClientSide Side = iota
+	ServerSide
+)
+
+// Tee wraps conn so that every frame read from or written to it is recorded
+// into w with sessionId. side tells the direction of the frames: on the client
+// side, written frames go from client to server; on the server side, they go
+// from server to client.
+//
+// Recording never fails the connection. If the bytes in a direction can't be
+// split into frames, the rest of that direction is not recorded, and the error
+// is reported by the Err method of the returned connection.
+func Tee(conn net.Conn, w *Writer, sessionId uint64, side Side) *TeeConn {
+	readDir, writeDir := ServerToClient, ClientToServer
+	if side == ServerSide {
+		readDir, writeDir = ClientToServer, ServerToClient
+	}
+
+	tc := &TeeConn{Conn: conn}
+	tc.read = &frameSplitter{conn: tc, w: w, sessionId: sessionId, dir: readDir}
+	tc.write = &frameSplitter{conn: tc, w: w, sessionId: sessionId, dir: writeDir}
+	return tc
+}
+
+type TeeConn struct {
+	net.Conn
+	read, write *frameSplitter
+
+	mu  sync.Mutex
+	err error
+}
+
+func (c *TeeConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	c.read.feed(p[:n])
+	return n, err
+}
+
+func (c *TeeConn) Write(p []byte) (int, error) {
+	n, err := c.Conn.Write(p)
+	c.write.feed(p[:n])
+	return n, err
+}
+
+// Err returns the first error encountered while recording.
+func (c *TeeConn) Err() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.err
+}
+
+func (c *TeeConn) setErr(err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.err == nil {
+		c.err = err
+	}
+}
+
+// frameSplitter splits a byte stream in one direction into frames.
+type frameSplitter struct {
+	conn      *TeeConn
+	w         *Writer
+	sessionId uint64
+	dir       Direction
+	buf       []byte
+	broken    bool
+}
+
+func (s *frameSplitter) feed(p []byte) {
+	if s.broken || len(p) <= 0 {
+		return
+	}
+
+	s.buf = append(s.buf, p...)
+	now := time.Now()
+	for {
+		f, n, err := s.w.codec.ParseFrame(s.buf)
+		if errors.Is(err, protocmd.ErrShortFrame) {
+			break
+		}
+
+		if err == nil {
+			err = s.w.Write(&Record{Time: now, Direction: s.dir, SessionId: s.sessionId, Frame: f})
+		}
+		if err != nil {
+			s.broken = true
+			s.buf = nil
+			s.conn.setErr(err)
+			return
+		}
+		s.buf = s.buf[n:]
+	}
+
+	if len(s.buf) == 0 {
+		s.buf = nil
+	}
+}
@@ -0,0 +1,147 @@
+package cmdtool
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/stalomeow/protocmd"
+	"github.com/stalomeow/protocmd/cmdlog"
+	"io"
+	"net"
+	"os"
+	"os/signal"
+	"time"
+)
+
+type cmdlogRecord struct {
+	Time      string          `json:"time"`
+	Direction string          `json:"direction"`
+	Session   uint64          `json:"session"`
+	Message   json.RawMessage `json:"message,omitempty"`
+	Cmd       *uint16         `json:"cmd,omitempty"` // set with Error
+	Error     string          `json:"error,omitempty"`
+}
+
+// frameError is written in place of the messages of a frame which can't be
+// decoded. Records hold its fields at the top level.
+type frameError struct {
+	Cmd   uint16 `json:"cmd"`
+	Error string `json:"error"`
+}
+
+// runLog decodes the records in a .cmdlog file into JSON lines.
+func runLog(tool *tool, in io.Reader, out io.Writer) error {
+	w := bufio.NewWriter(out)
+	defer w.Flush()
+
+	r := cmdlog.NewReader(in, tool.codec)
+	for {
+		rec, err := r.Read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		// A batch frame is written as a record per message.
+		out := cmdlogRecord{
+			Time:      rec.Time.UTC().Format(time.RFC3339Nano),
+			Direction: rec.Direction.String(),
+			Session:   rec.SessionId,
+		}
+		write := func() error {
+			buf, err := json.Marshal(&out)
+			if err != nil {
+				return err
+			}
+			_, err = fmt.Fprintf(w, "%s\n", buf)
+			return err
+		}
+
+		envelopes, err := tool.framesJSON(rec.Frame)
+		if err != nil {
+			out.Cmd, out.Error = &rec.Frame.CmdId, err.Error()
+			if err := write(); err != nil {
+				return err
+			}
+			continue
+		}
+		for _, js := range envelopes {
+			out.Message = js
+			if err := write(); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// runReplay re-sends the client side of a session in a .cmdlog file to a server,
+// and writes the frames received from the server as JSON lines.
+func runReplay(tool *tool, in io.Reader, out io.Writer) error {
+	if tool.addr == "" {
+		return fmt.Errorf("addr is not specified")
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", tool.addr)
+	if err != nil {
+		return err
+	}
+
+	w := bufio.NewWriter(out)
+	rp := &cmdlog.Replayer{
+		Speed: tool.speed,
+		Codec: tool.codec,
+		OnFrame: func(f *protocmd.Frame) {
+			envelopes, err := tool.framesJSON(f)
+			if err != nil {
+				js, _ := json.Marshal(&frameError{Cmd: f.CmdId, Error: err.Error()})
+				envelopes = []json.RawMessage{js}
+			}
+			for _, js := range envelopes {
+				fmt.Fprintf(w, "%s\n", js)
+			}
+		},
+	}
+	err = rp.Replay(ctx, cmdlog.NewReader(in, tool.codec), tool.session, conn)
+	if err == nil {
+		// Wait for the responses of the last frames, unless the server
+		// closes the connection first.
+		select {
+		case <-time.After(tool.wait):
+		case <-rp.Drained():
+		case <-ctx.Done():
+		}
+	}
+
+	// Frames are written until the connection is closed, so the output is
+	// flushed after that.
+	conn.Close()
+	<-rp.Drained()
+	if ferr := w.Flush(); err == nil {
+		err = ferr
+	}
+	return err
+}
+
+// framesJSON decodes f into a JSON envelope per message.
+func (tool *tool) framesJSON(f *protocmd.Frame) ([]json.RawMessage, error) {
+	msgs, err := decodeFrame(tool.codec, f)
+	if err != nil {
+		return nil, err
+	}
+	envelopes := make([]json.RawMessage, 0, len(msgs))
+	for _, msg := range msgs {
+		js, err := protocmd.MarshalCmdJSON(msg)
+		if err != nil {
+			return nil, err
+		}
+		envelopes = append(envelopes, js)
+	}
+	return envelopes, nil
+}
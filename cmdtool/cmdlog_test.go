@@ -0,0 +1,146 @@
+package cmdtool_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/stalomeow/protocmd"
+	"github.com/stalomeow/protocmd/cmdlog"
+	"github.com/stalomeow/protocmd/cmdtool"
+	"github.com/stalomeow/protocmd/examples/go/protos"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeLog writes records into a .cmdlog file in a temporary directory.
+func writeLog(t *testing.T, records ...*cmdlog.Record) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "test.cmdlog")
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	w := cmdlog.NewWriter(f, nil)
+	for _, rec := range records {
+		if err := w.Write(rec); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func encode(t *testing.T, msg protocmd.CmdMessage) *protocmd.Frame {
+	t.Helper()
+
+	f, err := protocmd.DefaultFrameCodec.Encode(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return f
+}
+
+func TestLog(t *testing.T) {
+	batch, err := protocmd.DefaultFrameCodec.EncodeBatch([]protocmd.CmdMessage{&protos.TestRsp{RetCode: 1}, &protos.TestRsp{RetCode: 2}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	path := writeLog(t,
+		&cmdlog.Record{Time: start, Direction: cmdlog.ClientToServer, SessionId: 1, Frame: encode(t, &protos.TestReq{Uid: "a"})},
+		&cmdlog.Record{Time: start.Add(time.Second), Direction: cmdlog.ServerToClient, SessionId: 1, Frame: batch},
+		&cmdlog.Record{Time: start.Add(time.Second), Direction: cmdlog.ServerToClient, SessionId: 1, Frame: &protocmd.Frame{CmdId: 1010, Payload: []byte{0xff}}},
+	)
+
+	var out bytes.Buffer
+	if err := cmdtool.Run([]string{"log", path}, nil, &out); err != nil {
+		t.Fatal(err)
+	}
+
+	// A batch frame is written as a line per message, and undecodable frames as errors.
+	want := []string{
+		`{"time":"2024-01-02T03:04:05Z","direction":"c2s","session":1,"message":{"cmd":1010,"name":"TestReq","body":{"uid":"a"}}}`,
+		`{"time":"2024-01-02T03:04:06Z","direction":"s2c","session":1,"message":{"cmd":1011,"name":"TestRsp","body":{"retCode":1}}}`,
+		`{"time":"2024-01-02T03:04:06Z","direction":"s2c","session":1,"message":{"cmd":1011,"name":"TestRsp","body":{"retCode":2}}}`,
+	}
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != len(want)+1 {
+		t.Fatalf("got %d lines, want %d:\n%s", len(lines), len(want)+1, out.String())
+	}
+	for i, line := range want {
+		if lines[i] != line {
+			t.Errorf("line %d: got %s, want %s", i, lines[i], line)
+		}
+	}
+	// Errors are at the top level of records, like in the output of pcap.
+	var last struct {
+		Message json.RawMessage `json:"message"`
+		Cmd     uint16          `json:"cmd"`
+		Error   string          `json:"error"`
+	}
+	if err := json.Unmarshal([]byte(lines[len(want)]), &last); err != nil || last.Message != nil || last.Cmd != 1010 || last.Error == "" {
+		t.Errorf("got %s, want an error of cmd 1010", lines[len(want)])
+	}
+}
+
+func TestReplay(t *testing.T) {
+	start := time.Unix(1700000000, 0)
+	path := writeLog(t,
+		&cmdlog.Record{Time: start, Direction: cmdlog.ClientToServer, SessionId: 3, Frame: encode(t, &protos.TestReq{Uid: "a"})},
+		&cmdlog.Record{Time: start, Direction: cmdlog.ClientToServer, SessionId: 4, Frame: encode(t, &protos.TestReq{Uid: "other"})},
+		&cmdlog.Record{Time: start, Direction: cmdlog.ClientToServer, SessionId: 3, Frame: encode(t, &protos.TestReq{Uid: "bb"})},
+	)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	// The server answers the last request late, and closes the connection.
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		r := protocmd.NewFrameReader(conn, nil)
+		w := protocmd.NewFrameWriter(conn, nil)
+		for i := 0; i < 2; i++ {
+			msg, err := r.ReadMessage()
+			if err != nil {
+				return
+			}
+			if i == 1 {
+				time.Sleep(100 * time.Millisecond)
+			}
+			w.WriteMessage(&protos.TestRsp{RetCode: int32(len(msg.(*protos.TestReq).Uid))})
+		}
+	}()
+
+	var out bytes.Buffer
+	begin := time.Now()
+	args := []string{"replay", "-addr=" + l.Addr().String(), "-session=3", "-speed=0", "-wait=1m", path}
+	if err := cmdtool.Run(args, nil, &out); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(begin); elapsed > 30*time.Second {
+		t.Errorf("replay waited %v after the server closed the connection", elapsed)
+	}
+
+	want := `{"cmd":1011,"name":"TestRsp","body":{"retCode":1}}
+{"cmd":1011,"name":"TestRsp","body":{"retCode":2}}
+`
+	if out.String() != want {
+		t.Errorf("got\n%s\nwant\n%s", out.String(), want)
+	}
+}
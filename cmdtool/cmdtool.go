@@ -0,0 +1,239 @@
+// Package cmdtool implements the protocmd command-line tool, which decodes
+// binary frames into JSON and encodes JSON back into frames.
+//
+// Messages are registered from descriptor sets and the configuration file
+// given on the command line. Programs with generated messages can also embed
+// the tool to use their compiled-in registry:
+//
+//	import (
+//		_ "github.com/stalomeow/protocmd/examples/go/protos"
+//		"github.com/stalomeow/protocmd/cmdtool"
+//	)
+//
+//	func main() {
+//		cmdtool.Main()
+//	}
+package cmdtool
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/stalomeow/protocmd"
+	"github.com/stalomeow/protocmd/cmdyaml"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const usage = `usage: protocmd <command> [options] [FILE]
+
+commands:
+  decode    decode frames into JSON lines
+  encode    encode JSON into frames
+  pcap      decode cmd traffic in a pcap or pcapng file into JSON lines
+  log       decode records in a .cmdlog file into JSON lines
+  replay    re-send the client side of a session in a .cmdlog file to a server
+
+Reads FILE, or stdin if FILE is not given, and writes to stdout.
+Run 'protocmd <command> -h' for the options of a command.
+`
+
+type command struct {
+	name string
+	run  func(tool *tool, in io.Reader, out io.Writer) error
+}
+
+var commands = []*command{
+	{name: "decode", run: runDecode},
+	{name: "encode", run: runEncode},
+	{name: "pcap", run: runPcap},
+	{name: "log", run: runLog},
+	{name: "replay", run: runReplay},
+}
+
+// Main runs the tool with os.Args and exits the process on failure.
+func Main() {
+	if err := Run(os.Args[1:], os.Stdin, os.Stdout); err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", filepath.Base(os.Args[0]), err)
+		os.Exit(1)
+	}
+}
+
+// Run runs the tool with args, which don't include the program name. Help
+// is written to stdout.
+func Run(args []string, stdin io.Reader, stdout io.Writer) error {
+	if len(args) <= 0 {
+		return errors.New("command is not specified\n" + usage)
+	}
+
+	for _, cmd := range commands {
+		if cmd.name != args[0] {
+			continue
+		}
+
+		tool := &tool{}
+		flags := tool.flagSet(cmd.name)
+		flags.SetOutput(io.Discard) // errors are returned instead
+		if err := flags.Parse(args[1:]); err != nil {
+			if errors.Is(err, flag.ErrHelp) {
+				flags.SetOutput(stdout)
+				flags.Usage()
+				return nil
+			}
+			return err
+		}
+		if err := tool.init(); err != nil {
+			return err
+		}
+
+		in := stdin
+		if flags.NArg() > 0 {
+			f, err := os.Open(flags.Arg(0))
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			in = f
+		}
+		return cmd.run(tool, in, stdout)
+	}
+
+	if args[0] == "-h" || args[0] == "-help" || args[0] == "--help" {
+		_, err := fmt.Fprint(stdout, usage)
+		return err
+	}
+	return fmt.Errorf("unknown command %q\n%s", args[0], usage)
+}
+
+// tool holds the options shared by all commands.
+type tool struct {
+	descriptorSetIn string
+	config          string
+	format          string
+	maxFrameSize    int
+	compress        string
+	compressMin     int
+	checksum        string
+	sequence        bool
+	payloadCodec    string
+	ports           string
+	addr            string
+	session         uint64
+	speed           float64
+	wait            time.Duration
+	codec           *protocmd.FrameCodec
+}
+
+func (tool *tool) flagSet(name string) *flag.FlagSet {
+	flags := flag.NewFlagSet(name, flag.ContinueOnError)
+	flags.StringVar(&tool.descriptorSetIn, "descriptor_set_in", "", "FileDescriptorSets to read, delimited by '"+string(os.PathListSeparator)+"'; the compiled-in registry is used if not given")
+	flags.StringVar(&tool.config, "config", "cmd.yaml", "the configuration file, used with descriptor_set_in")
+	flags.IntVar(&tool.maxFrameSize, "max_frame_size", protocmd.DefaultMaxFrameSize, "the limit of the length field of a frame")
+	switch name {
+	case "decode", "encode":
+		flags.StringVar(&tool.format, "format", "hex", "format of frames: hex, base64 or raw")
+		if name == "encode" {
+			flags.StringVar(&tool.compress, "compress", "none", "compressor of payloads: gzip, zlib or none")
+			flags.IntVar(&tool.compressMin, "compress_threshold", protocmd.DefaultCompressThreshold, "size below which payloads aren't compressed")
+			flags.StringVar(&tool.checksum, "checksum", "none", "checksum of frames: crc32, xxh32 or none")
+			flags.BoolVar(&tool.sequence, "sequence", false, "number frames with sequence numbers starting from 1")
+			flags.StringVar(&tool.payloadCodec, "payload_codec", "proto", "codec of payloads: proto or json")
+		}
+	case "pcap":
+		flags.StringVar(&tool.ports, "ports", "", "comma-separated TCP ports of servers")
+	case "replay":
+		flags.StringVar(&tool.addr, "addr", "", "TCP address of the server")
+		flags.Uint64Var(&tool.session, "session", 0, "id of the session to replay")
+		flags.Float64Var(&tool.speed, "speed", 1, "speed of the replay; 0 sends frames without waiting")
+		flags.DurationVar(&tool.wait, "wait", time.Second, "time to wait for responses after the last frame is sent")
+	}
+	return flags
+}
+
+func (tool *tool) init() error {
+	switch tool.format {
+	case "", "hex", "base64", "raw":
+	default:
+		return fmt.Errorf("unknown format %q", tool.format)
+	}
+
+	tool.codec = &protocmd.FrameCodec{
+		MaxFrameSize:      tool.maxFrameSize,
+		CompressThreshold: tool.compressMin,
+
+		// Debugging tools read frames in any built-in payload codec.
+		AcceptPayloadCodecs: []uint8{protocmd.ProtoPayloadCodecId, protocmd.JSONPayloadCodecId},
+	}
+	switch tool.compress {
+	case "", "none":
+	case "gzip":
+		tool.codec.Compressor = protocmd.GzipCompressor
+	case "zlib":
+		tool.codec.Compressor = protocmd.ZlibCompressor
+	default:
+		return fmt.Errorf("unknown compressor %q", tool.compress)
+	}
+
+	switch tool.checksum {
+	case "", "none":
+	case "crc32":
+		tool.codec.Checksum = protocmd.ChecksumCRC32
+	case "xxh32":
+		tool.codec.Checksum = protocmd.ChecksumXXH32
+	default:
+		return fmt.Errorf("unknown checksum %q", tool.checksum)
+	}
+	tool.codec.Sequence = tool.sequence
+
+	switch tool.payloadCodec {
+	case "", "proto":
+	case "json":
+		tool.codec.PayloadCodec = protocmd.JSONPayloadCodec
+	default:
+		return fmt.Errorf("unknown payload codec %q", tool.payloadCodec)
+	}
+
+	if tool.descriptorSetIn == "" {
+		return nil
+	}
+	return registerDescriptorSets(filepath.SplitList(tool.descriptorSetIn), tool.config)
+}
+
+// registerDescriptorSets registers the messages in descriptor sets with cmdIds in the configuration file.
+func registerDescriptorSets(paths []string, configPath string) error {
+	set := &descriptorpb.FileDescriptorSet{}
+	seen := make(map[string]interface{})
+	for _, p := range paths {
+		buf, err := os.ReadFile(p)
+		if err != nil {
+			return err
+		}
+
+		s := &descriptorpb.FileDescriptorSet{}
+		if err := proto.Unmarshal(buf, s); err != nil {
+			return fmt.Errorf("invalid FileDescriptorSet %q: %v", p, err)
+		}
+		for _, f := range s.File {
+			if _, ok := seen[f.GetName()]; !ok {
+				seen[f.GetName()] = nil
+				set.File = append(set.File, f)
+			}
+		}
+	}
+
+	files, err := protodesc.NewFiles(set)
+	if err != nil {
+		return err
+	}
+
+	config, err := cmdyaml.Load(configPath)
+	if err != nil {
+		return err
+	}
+	return protocmd.RegisterDescriptors(files, config.CmdIdMap)
+}
@@ -0,0 +1,181 @@
+package cmdtool_test
+
+import (
+	"bytes"
+	"github.com/stalomeow/protocmd/cmdtool"
+	_ "github.com/stalomeow/protocmd/examples/go/protos"
+	"strings"
+	"testing"
+)
+
+// run runs the tool and returns what it writes.
+func run(t *testing.T, in string, args ...string) (string, error) {
+	t.Helper()
+
+	var out bytes.Buffer
+	err := cmdtool.Run(args, strings.NewReader(in), &out)
+	return out.String(), err
+}
+
+const (
+	reqJSON = `{"cmd":1010,"name":"TestReq","body":{"uid":"1"}}`
+	rspJSON = `{"cmd":1011,"name":"TestRsp","body":{"retCode":2}}`
+)
+
+func TestEncodeDecode(t *testing.T) {
+	tests := []struct {
+		name    string
+		options []string
+	}{
+		{"hex", nil},
+		{"base64", []string{"-format=base64"}},
+		{"raw", []string{"-format=raw"}},
+		{"checksum", []string{"-checksum=crc32"}},
+		{"xxh32", []string{"-checksum=xxh32", "-format=raw"}},
+		{"sequence", []string{"-sequence"}},
+		{"gzip", []string{"-compress=gzip", "-compress_threshold=1"}},
+		{"zlib", []string{"-compress=zlib", "-compress_threshold=1", "-format=base64"}},
+		{"json payload", []string{"-payload_codec=json", "-checksum=crc32", "-sequence"}},
+	}
+
+	in := reqJSON + "\n" + rspJSON + "\n"
+	for _, tt := range tests {
+		encoded, err := run(t, in, append([]string{"encode"}, tt.options...)...)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+
+		// Decoding only needs the format; the rest is in the frames.
+		var decodeOptions []string
+		for _, o := range tt.options {
+			if strings.HasPrefix(o, "-format=") {
+				decodeOptions = append(decodeOptions, o)
+			}
+		}
+		decoded, err := run(t, encoded, append([]string{"decode"}, decodeOptions...)...)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if decoded != in {
+			t.Errorf("%s: got\n%s\nwant\n%s", tt.name, decoded, in)
+		}
+	}
+}
+
+func TestDecodeHex(t *testing.T) {
+	req, err := run(t, reqJSON, "encode")
+	if err != nil {
+		t.Fatal(err)
+	}
+	req = strings.TrimSpace(req)
+
+	var pairs []string
+	for i := 0; i < len(req); i += 2 {
+		pairs = append(pairs, req[i:i+2])
+	}
+
+	tests := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{"plain", req, reqJSON + "\n"},
+		{"0x prefix", "0x" + req, reqJSON + "\n"},
+		{"spaces", strings.Join(pairs, " "), reqJSON + "\n"},
+		{"colons", strings.Join(pairs, ":"), reqJSON + "\n"},
+		{"frames on a line", req + req, reqJSON + "\n" + reqJSON + "\n"},
+		{"comments and blank lines", "# a request\n\n  " + req + "  \n\n", reqJSON + "\n"},
+		{"no trailing newline", req + "\n" + req, reqJSON + "\n" + reqJSON + "\n"},
+	}
+	for _, tt := range tests {
+		got, err := run(t, tt.in, "decode")
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.name, got, tt.want)
+		}
+	}
+
+	errorTests := []struct {
+		name    string
+		in      string
+		options []string
+		want    string
+	}{
+		{"invalid hex", "zz", nil, "line 1"},
+		{"partial frame", req + "\n" + req[:len(req)-2], nil, "line 2"},
+		{"invalid base64", "!!", []string{"-format=base64"}, "line 1"},
+		{"unregistered cmdId", "00000000" + "1234" + "00", nil, "line 1"},
+	}
+	for _, tt := range errorTests {
+		_, err := run(t, tt.in, append([]string{"decode"}, tt.options...)...)
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: got %v, want an error of %s", tt.name, err, tt.want)
+		}
+	}
+}
+
+func TestEncodeJSON(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want string // decoded output, or a part of the error
+		ok   bool
+	}{
+		{"by cmd", `{"cmd":1010,"body":{"uid":"1"}}`, reqJSON + "\n", true},
+		{"by name", `{"name":"TestReq","body":{"uid":"1"}}`, reqJSON + "\n", true},
+		{"stream", reqJSON + " " + rspJSON, reqJSON + "\n" + rspJSON + "\n", true},
+		{"multi-line", "{\n  \"cmd\": 1011,\n  \"body\": {\"retCode\": 2}\n}\n", rspJSON + "\n", true},
+		{"empty input", "", "", true},
+		{"invalid JSON", reqJSON + "\n{", "message 1", false},
+		{"unknown cmd", `{"cmd":4321}`, "message 0", false},
+		{"invalid body", `{"cmd":1010,"body":{"uid":1}}`, "message 0", false},
+	}
+	for _, tt := range tests {
+		encoded, err := run(t, tt.in, "encode")
+		if !tt.ok {
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("%s: got %v, want an error of %s", tt.name, err, tt.want)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		decoded, err := run(t, encoded, "decode")
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+		} else if decoded != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.name, decoded, tt.want)
+		}
+	}
+}
+
+func TestRunUsage(t *testing.T) {
+	tests := []struct {
+		args []string
+		want string
+		ok   bool
+	}{
+		{[]string{"-h"}, "usage: protocmd <command>", true},
+		{[]string{"decode", "-h"}, "-format", true},
+		{[]string{}, "command is not specified", false},
+		{[]string{"bogus"}, `unknown command "bogus"`, false},
+		{[]string{"decode", "-bogus"}, "-bogus", false},
+		{[]string{"decode", "-format=yaml"}, `unknown format "yaml"`, false},
+		{[]string{"encode", "-checksum=md5"}, `unknown checksum "md5"`, false},
+	}
+	for _, tt := range tests {
+		out, err := run(t, "", tt.args...)
+		if tt.ok {
+			if err != nil || !strings.Contains(out, tt.want) {
+				t.Errorf("%v: got %q, %v, want help with %q", tt.args, out, err, tt.want)
+			}
+		} else if err == nil || !strings.Contains(err.Error(), tt.want) || out != "" {
+			t.Errorf("%v: got %q, %v, want an error with %q", tt.args, out, err, tt.want)
+		}
+	}
+}
@@ -0,0 +1,164 @@
+package cmdtool
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/stalomeow/protocmd"
+	"io"
+	"strings"
+)
+
+// runDecode decodes frames into JSON envelopes, one per line.
+// In hex and base64 formats, each line of the input holds one or more frames.
+func runDecode(tool *tool, in io.Reader, out io.Writer) error {
+	w := bufio.NewWriter(out)
+	defer w.Flush()
+
+	if tool.format == "raw" {
+		r := protocmd.NewFrameReader(in, tool.codec)
+		for i := 0; ; i++ {
+			f, err := r.ReadFrame()
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				return fmt.Errorf("frame %d: %w", i, err)
+			}
+			if err := writeFrameJSON(w, tool.codec, f); err != nil {
+				return fmt.Errorf("frame %d: %w", i, err)
+			}
+		}
+	}
+
+	r := bufio.NewReader(in)
+	for lineNum := 1; ; lineNum++ {
+		line, err := r.ReadString('\n')
+		if err != nil && err != io.EOF {
+			return err
+		}
+
+		if buf, decodeErr := tool.decodeText(line); decodeErr != nil {
+			return fmt.Errorf("line %d: %w", lineNum, decodeErr)
+		} else if decodeErr = writeFramesJSON(w, tool.codec, buf); decodeErr != nil {
+			return fmt.Errorf("line %d: %w", lineNum, decodeErr)
+		}
+
+		if err == io.EOF {
+			return nil
+		}
+	}
+}
+
+func (tool *tool) decodeText(line string) ([]byte, error) {
+	line = strings.TrimSpace(line)
+	if line == "" || strings.HasPrefix(line, "#") {
+		return nil, nil
+	}
+
+	if tool.format == "base64" {
+		return base64.StdEncoding.DecodeString(line)
+	}
+
+	// Hex dumps usually separate bytes with spaces or colons.
+	line = strings.NewReplacer(" ", "", "\t", "", ":", "").Replace(line)
+	return hex.DecodeString(strings.TrimPrefix(line, "0x"))
+}
+
+func writeFramesJSON(w io.Writer, codec *protocmd.FrameCodec, buf []byte) error {
+	for len(buf) > 0 {
+		f, n, err := codec.ParseFrame(buf)
+		if err != nil {
+			return err
+		}
+		if err := writeFrameJSON(w, codec, f); err != nil {
+			return err
+		}
+		buf = buf[n:]
+	}
+	return nil
+}
+
+// decodeFrame decodes the message of f, or every message of a batch frame.
+func decodeFrame(codec *protocmd.FrameCodec, f *protocmd.Frame) ([]protocmd.CmdMessage, error) {
+	if f.CmdId == protocmd.BatchCmdId {
+		return codec.DecodeBatch(f)
+	}
+	msg, err := codec.Decode(f)
+	if err != nil {
+		return nil, err
+	}
+	return []protocmd.CmdMessage{msg}, nil
+}
+
+// writeFrameJSON writes the message of f, or every message of a batch frame.
+func writeFrameJSON(w io.Writer, codec *protocmd.FrameCodec, f *protocmd.Frame) error {
+	msgs, err := decodeFrame(codec, f)
+	if err != nil {
+		return err
+	}
+
+	for _, msg := range msgs {
+		js, err := protocmd.MarshalCmdJSON(msg)
+		if err != nil {
+			return err
+		}
+		if _, err := fmt.Fprintf(w, "%s\n", js); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// runEncode encodes a stream of JSON envelopes into frames. In hex and base64
+// formats, each frame is written as a line.
+func runEncode(tool *tool, in io.Reader, out io.Writer) error {
+	w := bufio.NewWriter(out)
+	defer w.Flush()
+
+	dec := json.NewDecoder(in)
+	var seq protocmd.Sequencer
+	var buf []byte
+	for i := 0; ; i++ {
+		var raw json.RawMessage
+		if err := dec.Decode(&raw); err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return fmt.Errorf("message %d: %w", i, err)
+		}
+
+		msg, err := protocmd.UnmarshalCmdJSON(raw)
+		if err != nil {
+			return fmt.Errorf("message %d: %w", i, err)
+		}
+
+		f, err := tool.codec.Encode(msg)
+		if err != nil {
+			return fmt.Errorf("message %d: %w", i, err)
+		}
+		if tool.codec.Sequence {
+			seq.Stamp(f)
+		}
+
+		buf, err = tool.codec.AppendFrame(buf[:0], f)
+		if err != nil {
+			return fmt.Errorf("message %d: %w", i, err)
+		}
+
+		switch tool.format {
+		case "raw":
+			_, err = w.Write(buf)
+		case "base64":
+			_, err = fmt.Fprintln(w, base64.StdEncoding.EncodeToString(buf))
+		default:
+			_, err = fmt.Fprintln(w, hex.EncodeToString(buf))
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package cmdtool
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"github.com/stalomeow/protocmd/pcap"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type pcapRecord struct {
+	Time      string          `json:"time"`
+	Direction string          `json:"direction"`
+	Src       string          `json:"src"`
+	Dst       string          `json:"dst"`
+	Message   json.RawMessage `json:"message,omitempty"`
+	Cmd       *uint16         `json:"cmd,omitempty"` // set with Error if the frame is known
+	Error     string          `json:"error,omitempty"`
+}
+
+// runPcap decodes the cmd traffic in a pcap or pcapng file into JSON lines.
+func runPcap(tool *tool, in io.Reader, out io.Writer) error {
+	ports, err := parsePorts(tool.ports)
+	if err != nil {
+		return err
+	}
+
+	w := bufio.NewWriter(out)
+	defer w.Flush()
+
+	d := &pcap.Decoder{ServerPorts: ports, Codec: tool.codec}
+	write := func(rec *pcapRecord) error {
+		buf, err := json.Marshal(rec)
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintf(w, "%s\n", buf)
+		return err
+	}
+
+	return d.Decode(in, func(r *pcap.Record) error {
+		rec := pcapRecord{
+			Time:      r.Time.UTC().Format(time.RFC3339Nano),
+			Direction: r.Direction.String(),
+			Src:       r.Src.String(),
+			Dst:       r.Dst.String(),
+		}
+		if r.Err != nil {
+			rec.Error = r.Err.Error()
+			return write(&rec)
+		}
+
+		// A batch frame is written as a record per message.
+		envelopes, err := tool.framesJSON(r.Frame)
+		if err != nil {
+			rec.Cmd, rec.Error = &r.Frame.CmdId, err.Error()
+			return write(&rec)
+		}
+		for _, js := range envelopes {
+			rec.Message = js
+			if err := write(&rec); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func parsePorts(s string) ([]uint16, error) {
+	if s == "" {
+		return nil, fmt.Errorf("ports are not specified")
+	}
+
+	ports := make([]uint16, 0)
+	for _, p := range strings.Split(s, ",") {
+		port, err := strconv.ParseUint(strings.TrimSpace(p), 10, 16)
+		if err != nil {
+			return nil, fmt.Errorf("invalid port %q", p)
+		}
+		ports = append(ports, uint16(port))
+	}
+	return ports, nil
+}
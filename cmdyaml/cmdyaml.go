@@ -0,0 +1,161 @@
+// Package cmdyaml loads the configuration file mapping message full names to cmdIds.
+//
+// The file is a YAML mapping. The comment right above an entry names a group;
+// the entry and all entries after it belong to that group until the next group
+// comment appears:
+//
+//	# First Group
+//	protocmd.examples.TestReq: 1010
+//	protocmd.examples.TestRsp: 1011
+//
+//	# Second Group
+//	protocmd.examples.TestRsp.TransformInfo: 2010
+//
+// CmdIds from 0xff00 to 0xffff are reserved for the control messages in the
+// package protocmd.
+package cmdyaml
+
+import (
+	"fmt"
+	"github.com/stalomeow/protocmd"
+	"gopkg.in/yaml.v3"
+	"os"
+	"slices"
+	"strings"
+)
+
+type Config struct {
+	CmdIdMap    map[string]uint16 // message full name -> cmdId
+	CmdGroupMap map[string]string // message full name -> group name
+	Groups      []string          // group names in the order they appear
+}
+
+// Load reads and parses the configuration file at path.
+func Load(path string) (*Config, error) {
+	buf, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return Parse(buf)
+}
+
+// Parse parses the content of a configuration file.
+func Parse(buf []byte) (*Config, error) {
+	config := &Config{CmdIdMap: make(map[string]uint16)}
+	err := yaml.Unmarshal(buf, &config.CmdIdMap)
+	if err != nil {
+		return nil, err
+	}
+
+	err = parseGroups(buf, config)
+	if err != nil {
+		return nil, err
+	}
+
+	// Find duplicated cmdId
+	ids := make(map[uint16]interface{})
+	for _, v := range config.CmdIdMap {
+		if _, ok := ids[v]; ok {
+			return nil, fmt.Errorf("duplicated cmdId %v", v)
+		}
+		ids[v] = nil
+	}
+
+	for k, v := range config.CmdIdMap {
+		if v >= protocmd.MinControlCmdId && controlCmdIds[k] != v {
+			return nil, fmt.Errorf("cmdId %v of %s is reserved for control messages", v, k)
+		}
+	}
+	return config, nil
+}
+
+// GroupRange returns the smallest range holding the cmdIds of the group.
+// It returns false if the group has no entries.
+func (c *Config) GroupRange(group string) (protocmd.CmdIdRange, bool) {
+	var r protocmd.CmdIdRange
+	found := false
+	for name, g := range c.CmdGroupMap {
+		if g != group {
+			continue
+		}
+		cmdId := c.CmdIdMap[name]
+		if !found || cmdId < r.Min {
+			r.Min = cmdId
+		}
+		if !found || cmdId > r.Max {
+			r.Max = cmdId
+		}
+		found = true
+	}
+	return r, found
+}
+
+// GatewayRoutes creates a route for each group of config found in addrs,
+// which maps group names to backend addresses. It fails if a group isn't
+// in config, or if the cmdId ranges of two routes overlap.
+func GatewayRoutes(config *Config, addrs map[string]string) ([]protocmd.GatewayRoute, error) {
+	var routes []protocmd.GatewayRoute
+	var groups []string
+	for _, group := range config.Groups {
+		addr, ok := addrs[group]
+		if !ok {
+			continue
+		}
+		r, ok := config.GroupRange(group)
+		if !ok {
+			continue
+		}
+		for i, other := range routes {
+			if r.Min <= other.Max && other.Min <= r.Max {
+				return nil, fmt.Errorf("cmdIds of group '%s' overlap with group '%s'", group, groups[i])
+			}
+		}
+		routes = append(routes, protocmd.GatewayRoute{CmdIdRange: r, Addr: addr})
+		groups = append(groups, group)
+	}
+
+	for group := range addrs {
+		if _, ok := config.GroupRange(group); !ok {
+			return nil, fmt.Errorf("group '%s' not found", group)
+		}
+	}
+	return routes, nil
+}
+
+// controlCmdIds holds the control messages, which are the only ones
+// allowed to use the reserved cmdIds.
+var controlCmdIds = map[string]uint16{
+	"protocmd.Ping":        protocmd.Ping_CmdId,
+	"protocmd.Pong":        protocmd.Pong_CmdId,
+	"protocmd.Disconnect":  protocmd.Disconnect_CmdId,
+	"protocmd.KeyExchange": protocmd.KeyExchange_CmdId,
+}
+
+func parseGroups(buf []byte, config *Config) error {
+	config.CmdGroupMap = make(map[string]string)
+	config.Groups = make([]string, 0)
+
+	var doc yaml.Node
+	if err := yaml.Unmarshal(buf, &doc); err != nil {
+		return err
+	}
+	if len(doc.Content) <= 0 || doc.Content[0].Kind != yaml.MappingNode {
+		return nil
+	}
+
+	group := ""
+	content := doc.Content[0].Content
+	for i := 0; i+1 < len(content); i += 2 {
+		key := content[i]
+		if lines := strings.Split(strings.TrimSpace(key.HeadComment), "\n"); lines[len(lines)-1] != "" {
+			group = strings.TrimSpace(strings.TrimPrefix(lines[len(lines)-1], "#"))
+			if !slices.Contains(config.Groups, group) {
+				config.Groups = append(config.Groups, group)
+			}
+		}
+		if group != "" {
+			config.CmdGroupMap[key.Value] = group
+		}
+	}
+	return nil
+}
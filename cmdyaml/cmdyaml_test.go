@@ -0,0 +1,159 @@
+package cmdyaml_test
+
+import (
+	"github.com/stalomeow/protocmd/cmdyaml"
+	"reflect"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name   string
+		yaml   string
+		cmdIds map[string]uint16
+		groups map[string]string
+		order  []string
+	}{
+		{
+			name: "groups",
+			yaml: `# First Group
+a.A: 1
+a.B: 2
+
+# Second Group
+b.C: 3
+`,
+			cmdIds: map[string]uint16{"a.A": 1, "a.B": 2, "b.C": 3},
+			groups: map[string]string{"a.A": "First Group", "a.B": "First Group", "b.C": "Second Group"},
+			order:  []string{"First Group", "Second Group"},
+		},
+		{
+			name:   "no groups",
+			yaml:   "a.A: 1\na.B: 2\n",
+			cmdIds: map[string]uint16{"a.A": 1, "a.B": 2},
+			groups: map[string]string{},
+			order:  []string{},
+		},
+		{
+			name: "entries before the first group",
+			yaml: `a.A: 1
+# Group
+a.B: 2
+`,
+			cmdIds: map[string]uint16{"a.A": 1, "a.B": 2},
+			groups: map[string]string{"a.B": "Group"},
+			order:  []string{"Group"},
+		},
+		{
+			name: "last comment line names the group",
+			yaml: `# Some notes
+# about the file
+#   Group
+a.A: 1
+`,
+			cmdIds: map[string]uint16{"a.A": 1},
+			groups: map[string]string{"a.A": "Group"},
+			order:  []string{"Group"},
+		},
+		{
+			name: "repeated group",
+			yaml: `# X
+a.A: 1
+# Y
+a.B: 2
+# X
+a.C: 3
+`,
+			cmdIds: map[string]uint16{"a.A": 1, "a.B": 2, "a.C": 3},
+			groups: map[string]string{"a.A": "X", "a.B": "Y", "a.C": "X"},
+			order:  []string{"X", "Y"},
+		},
+		{
+			name: "commented out entries",
+			yaml: `# Group
+a.A: 1
+# a.B: 2
+`,
+			cmdIds: map[string]uint16{"a.A": 1},
+			groups: map[string]string{"a.A": "Group"},
+			order:  []string{"Group"},
+		},
+		{
+			name:   "control messages",
+			yaml:   "protocmd.Ping: 65280\n",
+			cmdIds: map[string]uint16{"protocmd.Ping": 0xff00},
+			groups: map[string]string{},
+			order:  []string{},
+		},
+		{
+			name:   "empty",
+			yaml:   "",
+			cmdIds: map[string]uint16{},
+			groups: map[string]string{},
+			order:  []string{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config, err := cmdyaml.Parse([]byte(tt.yaml))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(config.CmdIdMap, tt.cmdIds) {
+				t.Errorf("got cmdIds %v, want %v", config.CmdIdMap, tt.cmdIds)
+			}
+			if !reflect.DeepEqual(config.CmdGroupMap, tt.groups) {
+				t.Errorf("got groups %v, want %v", config.CmdGroupMap, tt.groups)
+			}
+			if !reflect.DeepEqual(config.Groups, tt.order) {
+				t.Errorf("got group order %v, want %v", config.Groups, tt.order)
+			}
+		})
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		yaml string
+	}{
+		{"duplicated cmdId", "a.A: 1\na.B: 1\n"},
+		{"reserved cmdId", "a.A: 65280\n"},
+		{"reserved cmdId in package protocmd", "protocmd.sub.A: 65535\n"},
+		{"unknown control message", "protocmd.Other: 65284\n"},
+		{"control message with another cmdId", "protocmd.Ping: 65281\n"},
+		{"cmdId out of range", "a.A: 65536\n"},
+		{"not a mapping", "- a.A\n"},
+		{"invalid yaml", "a.A: [1\n"},
+	}
+
+	for _, tt := range tests {
+		if _, err := cmdyaml.Parse([]byte(tt.yaml)); err == nil {
+			t.Errorf("%s: Parse succeeded", tt.name)
+		}
+	}
+}
+
+func TestGroupRange(t *testing.T) {
+	config, err := cmdyaml.Load("../examples/cmd.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		group    string
+		min, max uint16
+		ok       bool
+	}{
+		{"First Group", 1010, 1011, true},
+		{"Second Group", 2010, 2010, true},
+		{"Third Group", 0, 0, false},
+	}
+	for _, tt := range tests {
+		r, ok := config.GroupRange(tt.group)
+		if ok != tt.ok || r.Min != tt.min || r.Max != tt.max {
+			t.Errorf("%s: got %v-%v, %v, want %v-%v, %v", tt.group, r.Min, r.Max, ok, tt.min, tt.max, tt.ok)
+		}
+	}
+}
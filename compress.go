@@ -0,0 +1,201 @@
+package protocmd
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// DefaultCompressThreshold is the default size below which payloads
+// aren't compressed.
+const DefaultCompressThreshold = 256
+
+// Ids of the built-in compressors. Ids from 128 to 255 are free for
+// user-defined compressors.
+const (
+	GzipCompressorId uint8 = 1
+	ZlibCompressorId uint8 = 2
+)
+
+var ErrUnknownCompressor = errors.New("protocmd: unknown compressor")
+
+// Compressor compresses payloads of frames. The payload of a compressed frame
+// starts with the id of its compressor, so the receiver can decompress it
+// as long as the compressor is registered.
+type Compressor interface {
+	Id() uint8
+
+	// Compress appends the compressed src to dst.
+	Compress(dst, src []byte) ([]byte, error)
+
+	// Decompress appends the decompressed src to dst. It returns
+	// ErrFrameTooLarge if the result exceeds maxSize bytes.
+	Decompress(dst, src []byte, maxSize int) ([]byte, error)
+}
+
+var (
+	GzipCompressor Compressor = &gzipCompressor{}
+	ZlibCompressor Compressor = &zlibCompressor{}
+)
+
+var (
+	compressorsMu sync.RWMutex
+	compressors   = map[uint8]Compressor{
+		GzipCompressorId: GzipCompressor,
+		ZlibCompressorId: ZlibCompressor,
+	}
+)
+
+// RegisterCompressor makes c available for decompressing frames.
+func RegisterCompressor(c Compressor) {
+	compressorsMu.Lock()
+	defer compressorsMu.Unlock()
+
+	if _, ok := compressors[c.Id()]; ok {
+		panic(fmt.Sprintf("protocmd: compressor %v is already registered", c.Id()))
+	}
+	compressors[c.Id()] = c
+}
+
+func compressorById(id uint8) (Compressor, bool) {
+	compressorsMu.RLock()
+	defer compressorsMu.RUnlock()
+	c, ok := compressors[id]
+	return c, ok
+}
+
+// compress compresses the payload of msg if the codec asks for it.
+func (c *FrameCodec) compress(cmdId uint16, payload []byte) ([]byte, FrameFlags, error) {
+	if c == nil {
+		return payload, 0, nil
+	}
+
+	comp := c.Compressor
+	if override, ok := c.CmdCompressors[cmdId]; ok {
+		comp = override
+	}
+	if comp == nil || len(payload) < c.compressThreshold() {
+		return payload, 0, nil
+	}
+
+	compressed, err := comp.Compress([]byte{comp.Id()}, payload)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to compress cmdId '%v': %w", cmdId, err)
+	}
+	if len(compressed) >= len(payload) {
+		return payload, 0, nil // not worth it
+	}
+	return compressed, FlagCompressed, nil
+}
+
+func (c *FrameCodec) decompress(f *Frame) ([]byte, error) {
+	if len(f.Payload) == 0 {
+		return nil, ErrShortFrame
+	}
+
+	comp, ok := compressorById(f.Payload[0])
+	if !ok {
+		return nil, ErrUnknownCompressor
+	}
+	payload, err := comp.Decompress(nil, f.Payload[1:], c.maxFrameSize())
+	if err != nil {
+		return nil, fmt.Errorf("failed to decompress cmdId '%v': %w", f.CmdId, err)
+	}
+	return payload, nil
+}
+
+func (c *FrameCodec) compressThreshold() int {
+	if c.CompressThreshold <= 0 {
+		return DefaultCompressThreshold
+	}
+	return c.CompressThreshold
+}
+
+type gzipCompressor struct {
+	writers sync.Pool
+}
+
+func (*gzipCompressor) Id() uint8 {
+	return GzipCompressorId
+}
+
+func (c *gzipCompressor) Compress(dst, src []byte) ([]byte, error) {
+	buf := bytes.NewBuffer(dst)
+	w, ok := c.writers.Get().(*gzip.Writer)
+	if ok {
+		w.Reset(buf)
+	} else {
+		w = gzip.NewWriter(buf)
+	}
+	defer c.writers.Put(w)
+
+	if _, err := w.Write(src); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (*gzipCompressor) Decompress(dst, src []byte, maxSize int) ([]byte, error) {
+	r, err := gzip.NewReader(bytes.NewReader(src))
+	if err != nil {
+		return nil, err
+	}
+	return readLimited(dst, r, maxSize)
+}
+
+type zlibCompressor struct {
+	writers sync.Pool
+}
+
+func (*zlibCompressor) Id() uint8 {
+	return ZlibCompressorId
+}
+
+func (c *zlibCompressor) Compress(dst, src []byte) ([]byte, error) {
+	buf := bytes.NewBuffer(dst)
+	w, ok := c.writers.Get().(*zlib.Writer)
+	if ok {
+		w.Reset(buf)
+	} else {
+		w = zlib.NewWriter(buf)
+	}
+	defer c.writers.Put(w)
+
+	if _, err := w.Write(src); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (*zlibCompressor) Decompress(dst, src []byte, maxSize int) ([]byte, error) {
+	r, err := zlib.NewReader(bytes.NewReader(src))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return readLimited(dst, r, maxSize)
+}
+
+// readLimited appends everything in r to dst, failing if it exceeds maxSize
+// bytes, so that small frames can't decompress into huge payloads.
+func readLimited(dst []byte, r io.Reader, maxSize int) ([]byte, error) {
+	buf := bytes.NewBuffer(dst)
+	n, err := buf.ReadFrom(io.LimitReader(r, int64(maxSize)+1))
+	if err != nil {
+		return nil, err
+	}
+	if n > int64(maxSize) {
+		return nil, ErrFrameTooLarge
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,74 @@
+package protocmd_test
+
+import (
+	"errors"
+	"github.com/stalomeow/protocmd"
+	"github.com/stalomeow/protocmd/examples/go/protos"
+	"google.golang.org/protobuf/proto"
+	"strings"
+	"testing"
+)
+
+func TestCompression(t *testing.T) {
+	large := &protos.TestReq{Uid: strings.Repeat("transform", 100)}
+	small := &protos.TestReq{Uid: "123321"}
+
+	for _, comp := range []protocmd.Compressor{protocmd.GzipCompressor, protocmd.ZlibCompressor} {
+		codec := &protocmd.FrameCodec{Compressor: comp}
+
+		f, err := codec.Encode(large)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if f.Flags != protocmd.FlagCompressed || f.Payload[0] != comp.Id() {
+			t.Fatalf("compressor %v: large payload was not compressed", comp.Id())
+		}
+
+		// Any codec decompresses registered compressors.
+		msg, err := protocmd.DefaultFrameCodec.Decode(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !proto.Equal(msg, large) {
+			t.Fatalf("compressor %v: got %v", comp.Id(), protocmd.CmdString(msg))
+		}
+
+		if f, _ := codec.Encode(small); f.Flags != 0 {
+			t.Fatalf("compressor %v: payload below the threshold was compressed", comp.Id())
+		}
+	}
+}
+
+func TestCompressionCmdCompressors(t *testing.T) {
+	large := &protos.TestReq{Uid: strings.Repeat("transform", 100)}
+	codec := &protocmd.FrameCodec{
+		Compressor:     protocmd.GzipCompressor,
+		CmdCompressors: map[uint16]protocmd.Compressor{large.CmdId(): nil},
+	}
+
+	f, err := codec.Encode(large)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.Flags != 0 {
+		t.Fatal("compression wasn't disabled by CmdCompressors")
+	}
+}
+
+func TestDecompressionErrors(t *testing.T) {
+	large := &protos.TestReq{Uid: strings.Repeat("transform", 100)}
+	f, err := (&protocmd.FrameCodec{Compressor: protocmd.ZlibCompressor}).Encode(large)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The decompressed payload must not exceed MaxFrameSize.
+	if _, err := (&protocmd.FrameCodec{MaxFrameSize: 100}).Decode(f); !errors.Is(err, protocmd.ErrFrameTooLarge) {
+		t.Fatalf("Decode returned %v, want %v", err, protocmd.ErrFrameTooLarge)
+	}
+
+	f.Payload[0] = 200
+	if _, err := protocmd.DefaultFrameCodec.Decode(f); err != protocmd.ErrUnknownCompressor {
+		t.Fatalf("Decode returned %v, want %v", err, protocmd.ErrUnknownCompressor)
+	}
+}
@@ -0,0 +1,46 @@
+// Code generated by protoc-gen-cmdid. DO NOT EDIT.
+// versions:
+// 	protoc-gen-cmdid v1.0.0
+// 	protoc           (unknown)
+// source: control.proto
+
+package protocmd
+
+const (
+	Ping_CmdId   uint16 = 65280
+	Ping_CmdName string = "Ping"
+)
+
+func (*Ping) CmdId() uint16   { return Ping_CmdId }
+func (*Ping) CmdName() string { return Ping_CmdName }
+
+const (
+	Pong_CmdId   uint16 = 65281
+	Pong_CmdName string = "Pong"
+)
+
+func (*Pong) CmdId() uint16   { return Pong_CmdId }
+func (*Pong) CmdName() string { return Pong_CmdName }
+
+const (
+	Disconnect_CmdId   uint16 = 65282
+	Disconnect_CmdName string = "Disconnect"
+)
+
+func (*Disconnect) CmdId() uint16   { return Disconnect_CmdId }
+func (*Disconnect) CmdName() string { return Disconnect_CmdName }
+
+const (
+	KeyExchange_CmdId   uint16 = 65283
+	KeyExchange_CmdName string = "KeyExchange"
+)
+
+func (*KeyExchange) CmdId() uint16   { return KeyExchange_CmdId }
+func (*KeyExchange) CmdName() string { return KeyExchange_CmdName }
+
+func init() {
+	Register(func() CmdMessage { return new(Ping) })
+	Register(func() CmdMessage { return new(Pong) })
+	Register(func() CmdMessage { return new(Disconnect) })
+	Register(func() CmdMessage { return new(KeyExchange) })
+}
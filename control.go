@@ -0,0 +1,44 @@
+package protocmd
+
+import (
+	"errors"
+	"fmt"
+)
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --cmd_out=. --cmd_opt=lang=go,config=control.yaml,paths=source_relative control.proto
+
+// CmdIds from MinControlCmdId to MaxControlCmdId are reserved for the control
+// messages of protocmd, such as Ping, Pong and Disconnect, and for BatchCmdId.
+// Sessions handle control messages without passing them to the handler.
+const (
+	MinControlCmdId uint16 = 0xff00
+	MaxControlCmdId uint16 = 0xffff
+)
+
+// IsControlCmdId reports whether cmdId is reserved for control messages.
+func IsControlCmdId(cmdId uint16) bool {
+	return cmdId >= MinControlCmdId
+}
+
+// Codes of Disconnect defined by protocmd.
+const (
+	DisconnectNormal      uint32 = 0
+	DisconnectIdleTimeout uint32 = 1
+	DisconnectShutdown    uint32 = 2
+
+	// DisconnectBackendUnavailable is sent by a Gateway that can't forward
+	// messages to a backend.
+	DisconnectBackendUnavailable uint32 = 3
+)
+
+var ErrIdleTimeout = errors.New("protocmd: idle timeout")
+
+// DisconnectError is the error of a session closed after the peer sent a Disconnect.
+type DisconnectError struct {
+	Code   uint32
+	Reason string
+}
+
+func (e *DisconnectError) Error() string {
+	return fmt.Sprintf("protocmd: disconnected by peer: %s (code %d)", e.Reason, e.Code)
+}
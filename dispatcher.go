@@ -0,0 +1,73 @@
+package protocmd
+
+import "sync"
+
+// Handler handles messages received by a Session.
+type Handler interface {
+	ServeCmd(s *Session, msg CmdMessage)
+}
+
+// HandlerFunc adapts a function to a Handler.
+type HandlerFunc func(s *Session, msg CmdMessage)
+
+func (f HandlerFunc) ServeCmd(s *Session, msg CmdMessage) {
+	f(s, msg)
+}
+
+// Dispatcher is a Handler routing messages to other handlers by cmdId.
+// It's safe to register handlers while sessions are running.
+type Dispatcher struct {
+	mu       sync.RWMutex
+	handlers map[uint16]Handler
+
+	// NotFound handles messages without registered handlers.
+	// If it's nil, these messages are dropped. A LazyMessage is passed
+	// to it without being decoded.
+	NotFound Handler
+
+	// ReleaseMessages makes the dispatcher pass every message to Release
+	// after its handler returns, so handlers must not keep the messages.
+	// That includes passing them to Session.Send, which writes them later:
+	// send a clone made by proto.Clone instead.
+	ReleaseMessages bool
+}
+
+func NewDispatcher() *Dispatcher {
+	return &Dispatcher{handlers: make(map[uint16]Handler)}
+}
+
+func (d *Dispatcher) Handle(cmdId uint16, h Handler) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.handlers[cmdId] = h
+}
+
+func (d *Dispatcher) HandleFunc(cmdId uint16, f func(s *Session, msg CmdMessage)) {
+	d.Handle(cmdId, HandlerFunc(f))
+}
+
+// ServeCmd routes msg to its handler. A LazyMessage is decoded before it's
+// passed to a registered handler; if it can't be decoded, the session is
+// closed with the error.
+func (d *Dispatcher) ServeCmd(s *Session, msg CmdMessage) {
+	d.mu.RLock()
+	h, ok := d.handlers[msg.CmdId()]
+	d.mu.RUnlock()
+
+	if ok {
+		decoded, err := Resolve(msg)
+		if err != nil {
+			if s != nil {
+				s.close(err)
+			}
+			return
+		}
+		h.ServeCmd(s, decoded)
+	} else if d.NotFound != nil {
+		d.NotFound.ServeCmd(s, msg)
+	}
+
+	if d.ReleaseMessages {
+		Release(msg)
+	}
+}
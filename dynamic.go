@@ -0,0 +1,48 @@
+package protocmd
+
+import (
+	"fmt"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/dynamicpb"
+	"strings"
+)
+
+// dynamicCmdMessage is a CmdMessage whose type is only known at runtime.
+type dynamicCmdMessage struct {
+	*dynamicpb.Message
+	cmdId   uint16
+	cmdName string
+}
+
+func (m *dynamicCmdMessage) CmdId() uint16   { return m.cmdId }
+func (m *dynamicCmdMessage) CmdName() string { return m.cmdName }
+
+// RegisterDescriptors registers the messages in files which have cmdIds in
+// cmdIdMap (message full name -> cmdId), so that they can be created without
+// generated code. Messages created this way are backed by dynamicpb. The cmdName
+// of a message is the same as the one generated for Go, e.g. "TestRsp_TransformInfo".
+func RegisterDescriptors(files *protoregistry.Files, cmdIdMap map[string]uint16) error {
+	for fullName, cmdId := range cmdIdMap {
+		desc, err := files.FindDescriptorByName(protoreflect.FullName(fullName))
+		if err != nil {
+			return fmt.Errorf("failed to find message '%s': %w", fullName, err)
+		}
+
+		msgDesc, ok := desc.(protoreflect.MessageDescriptor)
+		if !ok {
+			return fmt.Errorf("'%s' is not a message", fullName)
+		}
+
+		cmdId := cmdId
+		cmdName := strings.ReplaceAll(strings.TrimPrefix(fullName, string(msgDesc.ParentFile().Package())+"."), ".", "_")
+		Register(func() CmdMessage {
+			return &dynamicCmdMessage{
+				Message: dynamicpb.NewMessage(msgDesc),
+				cmdId:   cmdId,
+				cmdName: cmdName,
+			}
+		})
+	}
+	return nil
+}
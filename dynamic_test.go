@@ -0,0 +1,128 @@
+package protocmd_test
+
+import (
+	"github.com/stalomeow/protocmd"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"testing"
+)
+
+// dynamicFiles describes the messages of a package without generated code:
+//
+//	message Item {
+//	  message Inner { int32 v = 1; }
+//	  enum Kind { KIND_NONE = 0; }
+//	  string name = 1;
+//	  Inner inner = 2;
+//	}
+func dynamicFiles(t *testing.T) *protoregistry.Files {
+	t.Helper()
+
+	field := func(name string, number int32, typ descriptorpb.FieldDescriptorProto_Type, typeName string) *descriptorpb.FieldDescriptorProto {
+		f := &descriptorpb.FieldDescriptorProto{
+			Name:     proto.String(name),
+			JsonName: proto.String(name),
+			Number:   proto.Int32(number),
+			Label:    descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum(),
+			Type:     typ.Enum(),
+		}
+		if typeName != "" {
+			f.TypeName = proto.String(typeName)
+		}
+		return f
+	}
+
+	files, err := protodesc.NewFiles(&descriptorpb.FileDescriptorSet{File: []*descriptorpb.FileDescriptorProto{{
+		Name:    proto.String("dynamic_test.proto"),
+		Package: proto.String("protocmd.dynamictest"),
+		Syntax:  proto.String("proto3"),
+		MessageType: []*descriptorpb.DescriptorProto{{
+			Name: proto.String("Item"),
+			Field: []*descriptorpb.FieldDescriptorProto{
+				field("name", 1, descriptorpb.FieldDescriptorProto_TYPE_STRING, ""),
+				field("inner", 2, descriptorpb.FieldDescriptorProto_TYPE_MESSAGE, ".protocmd.dynamictest.Item.Inner"),
+			},
+			NestedType: []*descriptorpb.DescriptorProto{{
+				Name:  proto.String("Inner"),
+				Field: []*descriptorpb.FieldDescriptorProto{field("v", 1, descriptorpb.FieldDescriptorProto_TYPE_INT32, "")},
+			}},
+			EnumType: []*descriptorpb.EnumDescriptorProto{{
+				Name:  proto.String("Kind"),
+				Value: []*descriptorpb.EnumValueDescriptorProto{{Name: proto.String("KIND_NONE"), Number: proto.Int32(0)}},
+			}},
+		}},
+	}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return files
+}
+
+func TestRegisterDescriptorsErrors(t *testing.T) {
+	files := dynamicFiles(t)
+
+	tests := []struct {
+		name     string
+		cmdIdMap map[string]uint16
+	}{
+		{"unknown message", map[string]uint16{"protocmd.dynamictest.Missing": 3190}},
+		{"enum", map[string]uint16{"protocmd.dynamictest.Item.Kind": 3191}},
+		{"field", map[string]uint16{"protocmd.dynamictest.Item.name": 3192}},
+	}
+	for _, tt := range tests {
+		if err := protocmd.RegisterDescriptors(files, tt.cmdIdMap); err == nil {
+			t.Errorf("%s: RegisterDescriptors succeeded", tt.name)
+		}
+	}
+}
+
+func TestRegisterDescriptors(t *testing.T) {
+	err := protocmd.RegisterDescriptors(dynamicFiles(t), map[string]uint16{
+		"protocmd.dynamictest.Item":       3100,
+		"protocmd.dynamictest.Item.Inner": 3101,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		cmdId   uint16
+		cmdName string
+		json    string
+	}{
+		{3100, "Item", `{"cmd":3100,"name":"Item","body":{"name":"x","inner":{"v":7}}}`},
+		{3100, "Item", `{"cmd":3100,"name":"Item","body":{}}`},
+		{3101, "Item_Inner", `{"cmd":3101,"name":"Item_Inner","body":{"v":-1}}`},
+	}
+	for _, tt := range tests {
+		msg, err := protocmd.UnmarshalCmdJSON([]byte(tt.json))
+		if err != nil {
+			t.Fatalf("%s: %v", tt.json, err)
+		}
+		if msg.CmdId() != tt.cmdId || msg.CmdName() != tt.cmdName {
+			t.Errorf("%s: got %v %v, want %v %v", tt.json, msg.CmdId(), msg.CmdName(), tt.cmdId, tt.cmdName)
+		}
+		if id, ok := protocmd.CmdId(tt.cmdName); !ok || id != tt.cmdId {
+			t.Errorf("%s: CmdId returned %v, %v", tt.cmdName, id, ok)
+		}
+
+		// Dynamic messages go through frames like generated ones.
+		f, err := protocmd.DefaultFrameCodec.Encode(msg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		decoded, err := protocmd.DefaultFrameCodec.Decode(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		js, err := protocmd.MarshalCmdJSON(decoded)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(js) != tt.json {
+			t.Errorf("got %s, want %s", js, tt.json)
+		}
+	}
+}
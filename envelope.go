@@ -0,0 +1,126 @@
+package protocmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/encoding/prototext"
+	"strings"
+)
+
+// cmdEnvelope is the JSON form of a CmdMessage:
+//
+//	{"cmd":1010,"name":"TestReq","body":{"uid":"123321"}}
+type cmdEnvelope struct {
+	Cmd  *uint16         `json:"cmd,omitempty"`
+	Name string          `json:"name,omitempty"`
+	Body json.RawMessage `json:"body"`
+}
+
+// MarshalCmdJSON renders msg as a JSON envelope annotated with its cmdId and name.
+func MarshalCmdJSON(msg CmdMessage) ([]byte, error) {
+	body, err := protojson.Marshal(msg)
+	if err != nil {
+		return nil, err
+	}
+
+	// protojson randomly adds spaces to its output, which makes logs hard to diff.
+	var compactBody bytes.Buffer
+	if err := json.Compact(&compactBody, body); err != nil {
+		return nil, err
+	}
+
+	cmdId := msg.CmdId()
+	return json.Marshal(&cmdEnvelope{
+		Cmd:  &cmdId,
+		Name: msg.CmdName(),
+		Body: compactBody.Bytes(),
+	})
+}
+
+// MarshalCmdJSONIndent is like MarshalCmdJSON but applies json.Indent to format the output.
+func MarshalCmdJSONIndent(msg CmdMessage, prefix, indent string) ([]byte, error) {
+	buf, err := MarshalCmdJSON(msg)
+	if err != nil {
+		return nil, err
+	}
+
+	var result bytes.Buffer
+	if err := json.Indent(&result, buf, prefix, indent); err != nil {
+		return nil, err
+	}
+	return result.Bytes(), nil
+}
+
+// UnmarshalCmdJSON parses a JSON envelope produced by MarshalCmdJSON and returns
+// a new message of the registered type. The message is looked up by "cmd", or by
+// "name" if "cmd" is absent.
+func UnmarshalCmdJSON(b []byte) (CmdMessage, error) {
+	var envelope cmdEnvelope
+	if err := json.Unmarshal(b, &envelope); err != nil {
+		return nil, err
+	}
+
+	var cmdId uint16
+	switch {
+	case envelope.Cmd != nil:
+		cmdId = *envelope.Cmd
+		if envelope.Name != "" {
+			if name, ok := CmdName(cmdId); ok && name != envelope.Name {
+				return nil, fmt.Errorf("cmdId '%v' is registered as '%s', not '%s'", cmdId, name, envelope.Name)
+			}
+		}
+	case envelope.Name != "":
+		var ok bool
+		if cmdId, ok = CmdId(envelope.Name); !ok {
+			return nil, fmt.Errorf("cmdName '%s' was not registered", envelope.Name)
+		}
+	default:
+		return nil, errors.New("neither cmd nor name is specified")
+	}
+
+	msg, err := NewMessageByCmdId(cmdId)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(envelope.Body) > 0 && string(envelope.Body) != "null" {
+		if err := protojson.Unmarshal(envelope.Body, msg); err != nil {
+			return nil, err
+		}
+	}
+	return msg, nil
+}
+
+// CmdString renders msg as a single line of text annotated with its cmdId and name,
+// which is suitable for logging:
+//
+//	TestReq(1010) {uid:"123321"}
+func CmdString(msg CmdMessage) string {
+	body := compactText(prototext.MarshalOptions{}.Format(msg))
+	return fmt.Sprintf("%s(%v) {%s}", msg.CmdName(), msg.CmdId(), body)
+}
+
+// compactText removes the spaces prototext randomly adds between fields,
+// like MarshalCmdJSON does for protojson. Spaces in strings are kept.
+func compactText(s string) string {
+	var b strings.Builder
+	inString := false
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case inString && c == '\\' && i+1 < len(s):
+			b.WriteByte(c)
+			i++
+			c = s[i]
+		case c == '"':
+			inString = !inString
+		case !inString && c == ' ' && i > 0 && s[i-1] == ' ':
+			continue
+		}
+		b.WriteByte(c)
+	}
+	return b.String()
+}
@@ -0,0 +1,119 @@
+package protocmd_test
+
+import (
+	"github.com/stalomeow/protocmd"
+	"github.com/stalomeow/protocmd/examples/go/protos"
+	"google.golang.org/protobuf/proto"
+	"strings"
+	"testing"
+)
+
+func TestUnmarshalCmdJSON(t *testing.T) {
+	tests := []struct {
+		name string
+		json string
+		want protocmd.CmdMessage
+	}{
+		{"by cmd", `{"cmd":1010,"body":{"uid":"1"}}`, &protos.TestReq{Uid: "1"}},
+		{"by name", `{"name":"TestReq","body":{"uid":"1"}}`, &protos.TestReq{Uid: "1"}},
+		{"by cmd and name", `{"cmd":1010,"name":"TestReq","body":{"uid":"1"}}`, &protos.TestReq{Uid: "1"}},
+		{"nested name", `{"name":"TestRsp_TransformInfo","body":{"position":{"x":1}}}`, &protos.TestRsp_TransformInfo{Position: &protos.Vector3{X: 1}}},
+		{"null body", `{"cmd":1011,"body":null}`, &protos.TestRsp{}},
+		{"missing body", `{"cmd":1011}`, &protos.TestRsp{}},
+		{"empty body", `{"name":"TestRsp","body":{}}`, &protos.TestRsp{}},
+	}
+	for _, tt := range tests {
+		msg, err := protocmd.UnmarshalCmdJSON([]byte(tt.json))
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if !proto.Equal(msg, tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.name, protocmd.CmdString(msg), protocmd.CmdString(tt.want))
+		}
+	}
+}
+
+func TestUnmarshalCmdJSONErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		json string
+		want string // part of the error
+	}{
+		{"name mismatch", `{"cmd":1010,"name":"TestRsp","body":{}}`, "registered as 'TestReq', not 'TestRsp'"},
+		{"unknown cmd", `{"cmd":4321,"body":{}}`, "'4321' which was not registered"},
+		{"unknown name", `{"name":"Missing","body":{}}`, "'Missing' was not registered"},
+		{"neither cmd nor name", `{"body":{}}`, "neither cmd nor name"},
+		{"invalid body", `{"cmd":1010,"body":{"uid":1}}`, "invalid value"},
+		{"unknown field", `{"cmd":1010,"body":{"id":"1"}}`, "unknown field"},
+		{"cmd out of range", `{"cmd":65536}`, "cmd"},
+		{"not an object", `[1010]`, "cannot unmarshal"},
+	}
+	for _, tt := range tests {
+		_, err := protocmd.UnmarshalCmdJSON([]byte(tt.json))
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: got %v, want an error with %q", tt.name, err, tt.want)
+		}
+	}
+}
+
+func TestMarshalCmdJSON(t *testing.T) {
+	tests := []struct {
+		msg  protocmd.CmdMessage
+		want string
+	}{
+		{&protos.TestReq{Uid: "1"}, `{"cmd":1010,"name":"TestReq","body":{"uid":"1"}}`},
+		{&protos.TestRsp{}, `{"cmd":1011,"name":"TestRsp","body":{}}`},
+		{
+			&protos.TestRsp{RetCode: 2, Transforms: []*protos.TestRsp_TransformInfo{{Position: &protos.Vector3{X: 1, Y: 2}}}},
+			`{"cmd":1011,"name":"TestRsp","body":{"retCode":2,"transforms":[{"position":{"x":1,"y":2}}]}}`,
+		},
+	}
+	for _, tt := range tests {
+		js, err := protocmd.MarshalCmdJSON(tt.msg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(js) != tt.want {
+			t.Errorf("got %s, want %s", js, tt.want)
+		}
+
+		// The output parses back into the same message.
+		msg, err := protocmd.UnmarshalCmdJSON(js)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !proto.Equal(msg, tt.msg) {
+			t.Errorf("got %v back, want %v", protocmd.CmdString(msg), protocmd.CmdString(tt.msg))
+		}
+	}
+
+	js, err := protocmd.MarshalCmdJSONIndent(&protos.TestReq{Uid: "1"}, "", "  ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "{\n  \"cmd\": 1010,\n  \"name\": \"TestReq\",\n  \"body\": {\n    \"uid\": \"1\"\n  }\n}"
+	if string(js) != want {
+		t.Errorf("got %s, want %s", js, want)
+	}
+}
+
+func TestCmdString(t *testing.T) {
+	tests := []struct {
+		msg  protocmd.CmdMessage
+		want string
+	}{
+		{&protos.TestReq{Uid: "123321"}, `TestReq(1010) {uid:"123321"}`},
+		{&protos.TestReq{Uid: `a  "b\"  c`}, `TestReq(1010) {uid:"a  \"b\\\"  c"}`},
+		{&protos.TestRsp{}, `TestRsp(1011) {}`},
+		{
+			&protos.TestRsp{RetCode: 2, Transforms: []*protos.TestRsp_TransformInfo{{Position: &protos.Vector3{X: 1, Y: 2}}, {}}},
+			`TestRsp(1011) {ret_code:2 transforms:{position:{x:1 y:2}} transforms:{}}`,
+		},
+	}
+	for _, tt := range tests {
+		if got := protocmd.CmdString(tt.msg); got != tt.want {
+			t.Errorf("got %s, want %s", got, tt.want)
+		}
+	}
+}
@@ -37,4 +37,19 @@ func main() {
 
 	fmt.Printf("Cmd (id: %v, name: %s)\n", msg.CmdId(), msg.CmdName())
 	fmt.Println(msg.(*protos.TestReq))
+
+	// Dump message as JSON annotated with its cmdId and name, and parse it back
+	js, err := protocmd.MarshalCmdJSON(msg)
+	if err != nil {
+		fmt.Printf("Error %v", err)
+		return
+	}
+	fmt.Println(string(js))
+
+	msg, err = protocmd.UnmarshalCmdJSON(js)
+	if err != nil {
+		fmt.Printf("Error %v", err)
+		return
+	}
+	fmt.Println(protocmd.CmdString(msg))
 }
@@ -0,0 +1,429 @@
+package protocmd
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// A frame is a CmdMessage on the wire. All integers are big-endian.
+//
+//	+------------+-----------+-----------+---------+
+//	| length (4) | cmdId (2) | flags (1) | payload |
+//	+------------+-----------+-----------+---------+
+//
+// length is the number of bytes after the header, and payload is the
+// serialized message, encoded as described by flags. If FlagSequence is set,
+// a 4-byte sequence number comes before the payload, and if FlagPayloadCodec
+// is set, the 1-byte id of the PayloadCodec comes after it. If FlagCRC32 or
+// FlagXXH32 is set, a 4-byte checksum of all the bytes before it comes
+// after the payload.
+const FrameHeaderSize = 7
+
+// DefaultMaxFrameSize is the default limit of the length field of a frame.
+const DefaultMaxFrameSize = 1 << 20
+
+// FrameFlags describes how the payload of a frame is encoded.
+type FrameFlags uint8
+
+const (
+	// FlagCompressed marks a payload starting with the id of a Compressor,
+	// followed by the compressed message.
+	FlagCompressed FrameFlags = 1 << 0
+
+	// FlagEncrypted marks a payload sealed by the Cipher of a session.
+	// It's applied after compression. See SealFrame.
+	FlagEncrypted FrameFlags = 1 << 1
+
+	// FlagSequence, FlagCRC32 and FlagXXH32 describe the fields around the
+	// payload. They are added by AppendFrame and removed by ParseFrame, so
+	// they're never set in Frame.Flags.
+	FlagSequence FrameFlags = 1 << 2
+	FlagCRC32    FrameFlags = 1 << 3
+	FlagXXH32    FrameFlags = 1 << 4
+
+	// FlagPayloadCodec is set by AppendFrame and removed by ParseFrame too.
+	FlagPayloadCodec FrameFlags = 1 << 5
+
+	knownFlags  = FlagCompressed | FlagEncrypted
+	headerFlags = FlagSequence | FlagCRC32 | FlagXXH32 | FlagPayloadCodec
+)
+
+var (
+	ErrFrameTooLarge = errors.New("protocmd: frame too large")
+	ErrShortFrame    = errors.New("protocmd: short frame")
+	ErrUnknownFlags  = errors.New("protocmd: unknown frame flags")
+)
+
+type Frame struct {
+	CmdId uint16
+	Flags FrameFlags
+
+	// Seq is the sequence number set by a Sequencer, or zero if the frame
+	// has none.
+	Seq uint32
+
+	// Codec is the id of the PayloadCodec of the payload.
+	Codec uint8
+
+	Payload []byte
+}
+
+// FrameCodec converts between messages and frames. A nil *FrameCodec
+// is valid and behaves like DefaultFrameCodec.
+type FrameCodec struct {
+	// MaxFrameSize limits the length field of a frame. If it's zero,
+	// DefaultMaxFrameSize is used. It also limits decompressed payloads.
+	MaxFrameSize int
+
+	// Compressor compresses the payloads of encoded frames. If it's nil,
+	// payloads aren't compressed. Frames are decompressed by any registered
+	// compressor regardless of it.
+	Compressor Compressor
+
+	// CompressThreshold is the size below which payloads aren't compressed.
+	// If it's zero, DefaultCompressThreshold is used.
+	CompressThreshold int
+
+	// CmdCompressors overrides Compressor for some cmdIds. A nil value
+	// disables compression for its cmdId.
+	CmdCompressors map[uint16]Compressor
+
+	// Checksum is appended to encoded frames. If it's not ChecksumNone,
+	// parsed frames must carry a checksum as well. Checksums are always
+	// verified when present.
+	Checksum Checksum
+
+	// Sequence makes FrameReader and FrameWriter number frames with a
+	// Sequencer, so that lost or replayed frames are detected.
+	Sequence bool
+
+	// Limits is checked before a payload is unmarshaled. If it's nil,
+	// only MaxFrameSize applies.
+	Limits *DecodeLimits
+
+	// CmdLimits overrides Limits for some cmdIds. A nil value removes
+	// the limits of its cmdId.
+	CmdLimits map[uint16]*DecodeLimits
+
+	// PayloadCodec serializes the messages of encoded frames. If it's nil,
+	// ProtoPayloadCodec is used. Decoded frames must be in it as well,
+	// unless their codecs are listed in AcceptPayloadCodecs.
+	PayloadCodec PayloadCodec
+
+	// AcceptPayloadCodecs lists the ids of other registered payload codecs
+	// accepted by Decode. Limits can only be checked on ProtoPayloadCodec,
+	// so frames in other codecs are rejected for cmdIds with limits.
+	AcceptPayloadCodecs []uint8
+}
+
+var DefaultFrameCodec = &FrameCodec{}
+
+func (c *FrameCodec) maxFrameSize() int {
+	if c == nil || c.MaxFrameSize <= 0 {
+		return DefaultMaxFrameSize
+	}
+	return c.MaxFrameSize
+}
+
+func (c *FrameCodec) checksum() Checksum {
+	if c == nil {
+		return ChecksumNone
+	}
+	return c.Checksum
+}
+
+func (c *FrameCodec) sequence() bool {
+	return c != nil && c.Sequence
+}
+
+func (c *FrameCodec) limits(cmdId uint16) *DecodeLimits {
+	if c == nil {
+		return nil
+	}
+	if limits, ok := c.CmdLimits[cmdId]; ok {
+		return limits
+	}
+	return c.Limits
+}
+
+// Encode serializes msg into a frame.
+func (c *FrameCodec) Encode(msg CmdMessage) (*Frame, error) {
+	payload, codec, err := c.appendMarshal(nil, msg)
+	if err != nil {
+		return nil, err
+	}
+
+	payload, flags, err := c.compress(msg.CmdId(), payload)
+	if err != nil {
+		return nil, err
+	}
+	return &Frame{CmdId: msg.CmdId(), Flags: flags, Codec: codec, Payload: payload}, nil
+}
+
+// Decode acquires a message of the registered type by the cmdId of f
+// and deserializes the payload into it.
+func (c *FrameCodec) Decode(f *Frame) (CmdMessage, error) {
+	pc, err := c.payloadCodecOf(f)
+	if err != nil {
+		return nil, err
+	}
+	limits := c.limits(f.CmdId)
+	if limits != nil && f.Codec != ProtoPayloadCodecId {
+		return nil, fmt.Errorf("%w: payload codec %v of cmdId '%v' can't be checked", ErrLimitExceeded, f.Codec, f.CmdId)
+	}
+	payload, err := c.decodePayload(f)
+	if err != nil {
+		return nil, err
+	}
+
+	msg, err := Acquire(f.CmdId)
+	if err != nil {
+		return nil, err
+	}
+	if limits != nil {
+		if err := limits.Check(f.CmdId, msg.ProtoReflect().Descriptor(), payload); err != nil {
+			Release(msg)
+			return nil, err
+		}
+	}
+	if err := pc.Unmarshal(payload, msg); err != nil {
+		Release(msg)
+		return nil, fmt.Errorf("failed to unmarshal cmdId '%v': %w", f.CmdId, err)
+	}
+	return msg, nil
+}
+
+// decodePayload undoes the encoding of the payload described by the flags.
+func (c *FrameCodec) decodePayload(f *Frame) ([]byte, error) {
+	if f.Flags&^knownFlags != 0 {
+		return nil, ErrUnknownFlags
+	}
+	if f.Flags&FlagEncrypted != 0 {
+		return nil, ErrFrameEncrypted
+	}
+	if f.Flags&FlagCompressed != 0 {
+		return c.decompress(f)
+	}
+	return f.Payload, nil
+}
+
+// EncodedSize returns the number of bytes AppendFrame appends for f.
+func (c *FrameCodec) EncodedSize(f *Frame) int {
+	length, _ := c.frameLength(f)
+	return FrameHeaderSize + length
+}
+
+// frameLength returns the length field and the flags of the wire form of f.
+func (c *FrameCodec) frameLength(f *Frame) (int, FrameFlags) {
+	checksum := c.checksum()
+	flags := f.Flags&^headerFlags | checksum.flag()
+	length := len(f.Payload)
+	if f.Seq != 0 {
+		flags |= FlagSequence
+		length += 4
+	}
+	if f.Codec != ProtoPayloadCodecId {
+		flags |= FlagPayloadCodec
+		length++
+	}
+	if checksum != ChecksumNone {
+		length += 4
+	}
+	return length, flags
+}
+
+// AppendFrame appends the wire form of f to dst, with the sequence number
+// if f.Seq isn't zero, the payload codec if f.Codec isn't zero, and with
+// the checksum selected by c.
+func (c *FrameCodec) AppendFrame(dst []byte, f *Frame) ([]byte, error) {
+	checksum := c.checksum()
+	length, flags := c.frameLength(f)
+	if length > c.maxFrameSize() {
+		return dst, ErrFrameTooLarge
+	}
+
+	start := len(dst)
+	dst = binary.BigEndian.AppendUint32(dst, uint32(length))
+	dst = binary.BigEndian.AppendUint16(dst, f.CmdId)
+	dst = append(dst, byte(flags))
+	if f.Seq != 0 {
+		dst = binary.BigEndian.AppendUint32(dst, f.Seq)
+	}
+	if f.Codec != ProtoPayloadCodecId {
+		dst = append(dst, f.Codec)
+	}
+	dst = append(dst, f.Payload...)
+	if checksum != ChecksumNone {
+		dst = binary.BigEndian.AppendUint32(dst, checksum.sum(dst[start:]))
+	}
+	return dst, nil
+}
+
+// ParseFrame parses the first frame in b and returns it with the number of
+// bytes consumed. It returns ErrShortFrame if b doesn't hold a whole frame.
+// The payload of the returned frame aliases b.
+func (c *FrameCodec) ParseFrame(b []byte) (*Frame, int, error) {
+	if len(b) < FrameHeaderSize {
+		return nil, 0, ErrShortFrame
+	}
+
+	length := binary.BigEndian.Uint32(b)
+	if uint64(length) > uint64(c.maxFrameSize()) {
+		return nil, 0, ErrFrameTooLarge
+	}
+
+	n := FrameHeaderSize + int(length)
+	if len(b) < n {
+		return nil, 0, ErrShortFrame
+	}
+
+	f, err := c.parseFrame(b[:n:n])
+	if err != nil {
+		return nil, 0, err
+	}
+	return f, n, nil
+}
+
+// parseFrame parses b holding exactly one frame. It verifies the checksum
+// and strips the fields around the payload.
+func (c *FrameCodec) parseFrame(b []byte) (*Frame, error) {
+	f := &Frame{
+		CmdId: binary.BigEndian.Uint16(b[4:]),
+		Flags: FrameFlags(b[6]),
+	}
+	body := b[FrameHeaderSize:]
+
+	checksum, err := checksumOfFlags(f.Flags)
+	if err != nil {
+		return nil, err
+	}
+	if checksum != ChecksumNone {
+		if len(body) < 4 {
+			return nil, fmt.Errorf("%w: no room for the checksum", ErrFrameCorrupted)
+		}
+		split := len(b) - 4
+		expected := binary.BigEndian.Uint32(b[split:])
+		if actual := checksum.sum(b[:split]); actual != expected {
+			return nil, &ChecksumError{CmdId: f.CmdId, Checksum: checksum, Expected: expected, Actual: actual}
+		}
+		body = body[:len(body)-4]
+	} else if c.checksum() != ChecksumNone {
+		return nil, ErrMissingChecksum
+	}
+
+	if f.Flags&FlagSequence != 0 {
+		if len(body) < 4 {
+			return nil, fmt.Errorf("%w: no room for the sequence number", ErrFrameCorrupted)
+		}
+		f.Seq = binary.BigEndian.Uint32(body)
+		body = body[4:]
+	}
+	if f.Flags&FlagPayloadCodec != 0 {
+		if len(body) < 1 {
+			return nil, fmt.Errorf("%w: no room for the payload codec", ErrFrameCorrupted)
+		}
+		f.Codec = body[0]
+		body = body[1:]
+	}
+
+	f.Flags &^= headerFlags
+	f.Payload = body
+	return f, nil
+}
+
+// FrameReader reads frames from a byte stream.
+type FrameReader struct {
+	r     *bufio.Reader
+	codec *FrameCodec
+	seq   Sequencer
+}
+
+func NewFrameReader(r io.Reader, codec *FrameCodec) *FrameReader {
+	return &FrameReader{r: bufio.NewReader(r), codec: codec}
+}
+
+// ReadFrame reads the next frame. It returns io.EOF only if the stream ends
+// at a frame boundary, and io.ErrUnexpectedEOF if it ends in the middle of a frame.
+func (r *FrameReader) ReadFrame() (*Frame, error) {
+	var header [FrameHeaderSize]byte
+	if _, err := io.ReadFull(r.r, header[:]); err != nil {
+		return nil, err
+	}
+
+	length := binary.BigEndian.Uint32(header[:])
+	if uint64(length) > uint64(r.codec.maxFrameSize()) {
+		return nil, ErrFrameTooLarge
+	}
+
+	b := make([]byte, FrameHeaderSize+int(length))
+	copy(b, header[:])
+	if _, err := io.ReadFull(r.r, b[FrameHeaderSize:]); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+
+	f, err := r.codec.parseFrame(b)
+	if err != nil {
+		return nil, err
+	}
+	if r.codec.sequence() {
+		if err := r.seq.Check(f); err != nil {
+			return nil, err
+		}
+	}
+	return f, nil
+}
+
+// ReadMessage reads the next frame and decodes it.
+func (r *FrameReader) ReadMessage() (CmdMessage, error) {
+	f, err := r.ReadFrame()
+	if err != nil {
+		return nil, err
+	}
+	return r.codec.Decode(f)
+}
+
+// FrameWriter writes frames to a byte stream. Each frame is written
+// by exactly one call to the Write method of the underlying writer.
+type FrameWriter struct {
+	w     io.Writer
+	codec *FrameCodec
+	seq   Sequencer
+	buf   []byte
+}
+
+func NewFrameWriter(w io.Writer, codec *FrameCodec) *FrameWriter {
+	return &FrameWriter{w: w, codec: codec}
+}
+
+// WriteFrame writes f. If the codec enables Sequence, f is written with
+// the next sequence number instead of f.Seq.
+func (w *FrameWriter) WriteFrame(f *Frame) error {
+	if w.codec.sequence() {
+		stamped := *f
+		w.seq.Stamp(&stamped)
+		f = &stamped
+	}
+
+	buf, err := w.codec.AppendFrame(w.buf[:0], f)
+	if err != nil {
+		return err
+	}
+	w.buf = buf
+	_, err = w.w.Write(buf)
+	return err
+}
+
+// WriteMessage encodes msg and writes it as a frame.
+func (w *FrameWriter) WriteMessage(msg CmdMessage) error {
+	f, err := w.codec.Encode(msg)
+	if err != nil {
+		return err
+	}
+	return w.WriteFrame(f)
+}
@@ -0,0 +1,250 @@
+package protocmd
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+)
+
+// CmdIdRange is an inclusive range of cmdIds.
+type CmdIdRange struct {
+	Min uint16
+	Max uint16
+}
+
+// Contains reports whether cmdId is in the range.
+func (r CmdIdRange) Contains(cmdId uint16) bool {
+	return cmdId >= r.Min && cmdId <= r.Max
+}
+
+// GatewayRoute sends the messages of a cmdId range to a backend.
+type GatewayRoute struct {
+	CmdIdRange
+
+	// Addr is the TCP address of the backend.
+	Addr string
+
+	// DialTransport connects to the backend over a transport other than TCP.
+	// If it's not nil, Addr is ignored.
+	DialTransport func(ctx context.Context) (Transport, error)
+}
+
+// Gateway is a Handler forwarding the messages of client sessions to
+// backends chosen by cmdId, and the messages of the backends back to the
+// clients. Each client session gets its own session to each backend it
+// uses, dialed when the first message is routed there, so backends are
+// plain servers which see one session per client.
+//
+// Give the client sessions a SessionConfig with LazyDecode, and messages are
+// forwarded without being unmarshaled. If a backend can't be reached or
+// ends its session, even gracefully, the client is disconnected with
+// DisconnectBackendUnavailable.
+type Gateway struct {
+	// Routes are searched in order for the cmdId of a message.
+	Routes []GatewayRoute
+
+	// Handler handles the messages matching no route. If it's nil,
+	// these messages are dropped.
+	Handler Handler
+
+	// BackendConfig configures the sessions to backends. LazyDecode is
+	// always enabled, and its OnClose is still called.
+	BackendConfig *SessionConfig
+
+	// DialTimeout limits each attempt to connect to a backend. If it's zero,
+	// DefaultDialTimeout is used.
+	DialTimeout time.Duration
+
+	// DialContext connects to backends. If it's nil, net.Dialer is used.
+	DialContext func(ctx context.Context, network, addr string) (net.Conn, error)
+
+	mu    sync.Mutex
+	links map[*Session]*gatewayLink
+}
+
+// gatewayLink holds the backend sessions of a client session.
+type gatewayLink struct {
+	mu       sync.Mutex
+	backends map[int]*gatewayBackend // route index -> backend
+	closed   bool
+}
+
+// gatewayBackend is the connection of a client session to a backend. The
+// first message routed there dials it, and the others wait for ready.
+type gatewayBackend struct {
+	ready  chan struct{}
+	client *Client // set with err under the link lock before ready is closed
+	err    error
+}
+
+func (g *Gateway) route(cmdId uint16) int {
+	for i, r := range g.Routes {
+		if r.Contains(cmdId) {
+			return i
+		}
+	}
+	return -1
+}
+
+func (g *Gateway) ServeCmd(s *Session, msg CmdMessage) {
+	i := g.route(msg.CmdId())
+	if i < 0 {
+		if g.Handler != nil {
+			g.Handler.ServeCmd(s, msg)
+		}
+		return
+	}
+
+	backend, err := g.backend(s, i)
+	if err != nil {
+		s.CloseWithReason(DisconnectBackendUnavailable, err.Error())
+		return
+	}
+
+	// Waiting for room in the queue slows the client down to the backend.
+	if err := backend.SendContext(context.Background(), msg); err != nil {
+		s.CloseWithReason(DisconnectBackendUnavailable, "backend session closed")
+	}
+}
+
+// backend returns the session from client to the backend of route i,
+// connecting to it if needed.
+func (g *Gateway) backend(client *Session, i int) (*Session, error) {
+	link := g.link(client)
+
+	link.mu.Lock()
+	if link.closed {
+		link.mu.Unlock()
+		return nil, ErrSessionClosed
+	}
+	b, ok := link.backends[i]
+	if !ok {
+		b = &gatewayBackend{ready: make(chan struct{})}
+		link.backends[i] = b
+	}
+	link.mu.Unlock()
+
+	if ok {
+		<-b.ready
+	} else {
+		g.dial(client, link, b, i)
+	}
+
+	if b.err != nil {
+		return nil, b.err
+	}
+	if s := b.client.Session(); s != nil {
+		return s, nil
+	}
+	return nil, fmt.Errorf("backend of cmdIds %v-%v closed the session", g.Routes[i].Min, g.Routes[i].Max)
+}
+
+// dial connects b to the backend of route i without holding the link lock,
+// so that other routes and the closing of the link aren't blocked.
+func (g *Gateway) dial(client *Session, link *gatewayLink, b *gatewayBackend, i int) {
+	defer close(b.ready)
+
+	c := g.newBackendClient(client, i)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case <-client.Done():
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	err := c.Connect(ctx)
+	if err != nil {
+		err = fmt.Errorf("failed to connect to backend of cmdIds %v-%v: %w", g.Routes[i].Min, g.Routes[i].Max, err)
+	}
+
+	link.mu.Lock()
+	closed := link.closed
+	if err == nil && closed {
+		err = ErrSessionClosed
+	}
+	b.err = err
+	if err == nil {
+		b.client = c
+	}
+	link.mu.Unlock()
+
+	// The link was closed while dialing, so nobody else closes c.
+	if closed {
+		c.Close()
+	}
+}
+
+// link returns the link of client, creating it if needed. The link is
+// closed with its backend sessions after client finishes.
+func (g *Gateway) link(client *Session) *gatewayLink {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if link, ok := g.links[client]; ok {
+		return link
+	}
+	if g.links == nil {
+		g.links = make(map[*Session]*gatewayLink)
+	}
+	link := &gatewayLink{backends: make(map[int]*gatewayBackend)}
+	g.links[client] = link
+
+	go func() {
+		<-client.Done()
+
+		g.mu.Lock()
+		delete(g.links, client)
+		g.mu.Unlock()
+
+		// Backends still dialing see closed and close themselves.
+		link.mu.Lock()
+		link.closed = true
+		var clients []*Client
+		for _, b := range link.backends {
+			if b.client != nil {
+				clients = append(clients, b.client)
+			}
+		}
+		link.mu.Unlock()
+		for _, c := range clients {
+			c.Close()
+		}
+	}()
+	return link
+}
+
+func (g *Gateway) newBackendClient(client *Session, i int) *Client {
+	config := &SessionConfig{}
+	if g.BackendConfig != nil {
+		*config = *g.BackendConfig
+	}
+	config.LazyDecode = true
+
+	onClose := config.OnClose
+	// The client is disconnected even if the backend ends its session
+	// gracefully, since it would otherwise lose the state kept there.
+	config.OnClose = func(s *Session, err error) {
+		client.CloseWithReason(DisconnectBackendUnavailable, "backend session closed")
+		if onClose != nil {
+			onClose(s, err)
+		}
+	}
+
+	route := &g.Routes[i]
+	return &Client{
+		Addr: route.Addr,
+		Handler: HandlerFunc(func(s *Session, msg CmdMessage) {
+			if err := client.SendContext(context.Background(), msg); err != nil {
+				s.Close()
+			}
+		}),
+		SessionConfig: config,
+		DialTimeout:   g.DialTimeout,
+		DialContext:   g.DialContext,
+		DialTransport: route.DialTransport,
+	}
+}
@@ -0,0 +1,211 @@
+package protocmd_test
+
+import (
+	"context"
+	"errors"
+	"github.com/stalomeow/protocmd"
+	"github.com/stalomeow/protocmd/cmdyaml"
+	"github.com/stalomeow/protocmd/examples/go/protos"
+	"net"
+	"strconv"
+	"testing"
+)
+
+// uidHandler answers every TestReq with a TestRsp carrying its uid.
+var uidHandler = protocmd.HandlerFunc(func(s *protocmd.Session, msg protocmd.CmdMessage) {
+	uid, _ := strconv.Atoi(msg.(*protos.TestReq).Uid)
+	s.Send(&protos.TestRsp{RetCode: int32(uid)})
+})
+
+func TestGatewayRoutes(t *testing.T) {
+	config, err := cmdyaml.Load("examples/cmd.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	routes, err := cmdyaml.GatewayRoutes(config, map[string]string{
+		"First Group":  "first:1",
+		"Second Group": "second:2",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(routes) != 2 {
+		t.Fatalf("got %v routes, want 2", len(routes))
+	}
+	if r := routes[0]; r.Min != protos.TestReq_CmdId || r.Max != protos.TestRsp_CmdId || r.Addr != "first:1" {
+		t.Errorf("got route %+v of the first group", r)
+	}
+	if r := routes[1]; r.Min != protos.TestRsp_TransformInfo_CmdId || r.Max != protos.TestRsp_TransformInfo_CmdId || r.Addr != "second:2" {
+		t.Errorf("got route %+v of the second group", r)
+	}
+
+	if _, err := cmdyaml.GatewayRoutes(config, map[string]string{"Third Group": "third:3"}); err == nil {
+		t.Error("GatewayRoutes accepted an unknown group")
+	}
+}
+
+func TestGateway(t *testing.T) {
+	first := startServer(t, &protocmd.Server{Handler: uidHandler})
+	second := startServer(t, &protocmd.Server{Handler: echoHandler})
+
+	local := make(chan protocmd.CmdMessage, 1)
+	gw := &protocmd.Gateway{
+		Routes: []protocmd.GatewayRoute{
+			{CmdIdRange: protocmd.CmdIdRange{Min: 1000, Max: 1010}, Addr: first},
+			{CmdIdRange: protocmd.CmdIdRange{Min: 2000, Max: 2999}, Addr: second},
+		},
+		Handler: protocmd.HandlerFunc(func(s *protocmd.Session, msg protocmd.CmdMessage) { local <- msg }),
+	}
+	addr := startServer(t, &protocmd.Server{
+		Handler:       gw,
+		SessionConfig: &protocmd.SessionConfig{LazyDecode: true},
+	})
+
+	// Responses go back to the client which sent the request.
+	var chans []<-chan protocmd.CmdMessage
+	var clients []*protocmd.Client
+	for i := 0; i < 3; i++ {
+		h, ch := collect()
+		c := &protocmd.Client{Addr: addr, Handler: h}
+		if err := c.Connect(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		defer c.Close()
+		chans = append(chans, ch)
+		clients = append(clients, c)
+	}
+	for round := 0; round < 3; round++ {
+		for i, c := range clients {
+			if err := c.Send(&protos.TestReq{Uid: strconv.Itoa(i*10 + round)}); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	for round := 0; round < 3; round++ {
+		for i, ch := range chans {
+			rsp, ok := receive(t, ch).(*protos.TestRsp)
+			if want := int32(i*10 + round); !ok || rsp.RetCode != want {
+				t.Fatalf("client %v got %v, want TestRsp %v", i, rsp, want)
+			}
+		}
+	}
+
+	// Other ranges go to other backends.
+	info := &protos.TestRsp_TransformInfo{Position: &protos.Vector3{X: 1}}
+	clients[0].Send(info)
+	if got, ok := receive(t, chans[0]).(*protos.TestRsp_TransformInfo); !ok || got.Position.GetX() != 1 {
+		t.Fatalf("got %v, want the echoed TransformInfo", got)
+	}
+
+	// Messages out of the routes are handled by the gateway.
+	clients[1].Send(&protos.TestRsp{RetCode: 7})
+	if rsp, err := protocmd.Resolve(receive(t, local)); err != nil || rsp.(*protos.TestRsp).RetCode != 7 {
+		t.Fatalf("the gateway handled %v, %v", rsp, err)
+	}
+}
+
+func TestGatewayBackendUnavailable(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	backend := l.Addr().String()
+	l.Close()
+
+	gw := &protocmd.Gateway{
+		Routes: []protocmd.GatewayRoute{
+			{CmdIdRange: protocmd.CmdIdRange{Min: 1000, Max: 1999}, Addr: backend},
+		},
+	}
+	addr := startServer(t, &protocmd.Server{Handler: gw})
+
+	c := &protocmd.Client{Addr: addr}
+	if err := c.Connect(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	s := c.Session()
+	s.Send(&protos.TestReq{Uid: "1"})
+	waitDone(t, s)
+
+	var de *protocmd.DisconnectError
+	if !errors.As(s.Err(), &de) || de.Code != protocmd.DisconnectBackendUnavailable {
+		t.Fatalf("the session ended with %v", s.Err())
+	}
+}
+
+func TestGatewayDialDoesNotBlock(t *testing.T) {
+	slow := startServer(t, &protocmd.Server{Handler: uidHandler})
+	fast := startServer(t, &protocmd.Server{Handler: echoHandler})
+
+	dialing, release := make(chan struct{}), make(chan struct{})
+	var dialer net.Dialer
+	gw := &protocmd.Gateway{
+		Routes: []protocmd.GatewayRoute{
+			{CmdIdRange: protocmd.CmdIdRange{Min: 1000, Max: 1999}, Addr: slow},
+			{CmdIdRange: protocmd.CmdIdRange{Min: 2000, Max: 2999}, Addr: fast},
+		},
+		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			if addr == slow {
+				close(dialing)
+				select {
+				case <-release:
+				case <-ctx.Done():
+					return nil, ctx.Err()
+				}
+			}
+			return dialer.DialContext(ctx, network, addr)
+		},
+	}
+
+	h, ch := collect()
+	s, _ := pipeSessions(t, nil, h, nil, nil)
+
+	// Messages to other backends get through while a backend is dialed.
+	done := make(chan struct{})
+	go func() {
+		gw.ServeCmd(s, &protos.TestReq{Uid: "3"})
+		close(done)
+	}()
+	<-dialing
+	gw.ServeCmd(s, &protos.TestRsp_TransformInfo{Position: &protos.Vector3{X: 2}})
+	if got, ok := receive(t, ch).(*protos.TestRsp_TransformInfo); !ok || got.Position.GetX() != 2 {
+		t.Fatalf("got %v, want the echoed TransformInfo", got)
+	}
+
+	close(release)
+	<-done
+	if rsp, ok := receive(t, ch).(*protos.TestRsp); !ok || rsp.RetCode != 3 {
+		t.Fatalf("got %v, want TestRsp 3", rsp)
+	}
+}
+
+func TestGatewayBackendClosed(t *testing.T) {
+	// The backend ends its session gracefully after the first message.
+	backend := startServer(t, &protocmd.Server{Handler: protocmd.HandlerFunc(func(s *protocmd.Session, msg protocmd.CmdMessage) {
+		s.Close()
+	})})
+	gw := &protocmd.Gateway{
+		Routes: []protocmd.GatewayRoute{
+			{CmdIdRange: protocmd.CmdIdRange{Min: 1000, Max: 1999}, Addr: backend},
+		},
+	}
+	addr := startServer(t, &protocmd.Server{Handler: gw})
+
+	c := &protocmd.Client{Addr: addr}
+	if err := c.Connect(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	s := c.Session()
+	s.Send(&protos.TestReq{Uid: "1"})
+	waitDone(t, s)
+
+	var de *protocmd.DisconnectError
+	if !errors.As(s.Err(), &de) || de.Code != protocmd.DisconnectBackendUnavailable {
+		t.Fatalf("the session ended with %v", s.Err())
+	}
+}
@@ -0,0 +1,113 @@
+package protocmd
+
+import (
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/known/emptypb"
+	"sync"
+	"sync/atomic"
+)
+
+// LazyMessage is a message kept as its raw payload until it's accessed.
+// Forwarding it with Frame never unmarshals the payload, and the cmdId
+// doesn't even need to be registered.
+//
+// LazyMessage implements CmdMessage by reflecting the decoded message, so
+// it can be passed to handlers. A Dispatcher decodes it before calling the
+// handler registered for its cmdId, but passes it to NotFound as it is.
+// Encoding a LazyMessage reuses its payload unless it has been decoded,
+// so changes made by handlers are kept, or it's in another PayloadCodec.
+type LazyMessage struct {
+	cmdId        uint16
+	payload      []byte
+	payloadCodec uint8
+	codec        *FrameCodec
+
+	once    sync.Once
+	decoded atomic.Bool
+	msg     CmdMessage
+	err     error
+}
+
+// NewLazyMessage creates a LazyMessage of a message serialized by
+// ProtoPayloadCodec. codec is used to decode it; if it's nil,
+// DefaultFrameCodec is used.
+func NewLazyMessage(cmdId uint16, payload []byte, codec *FrameCodec) *LazyMessage {
+	return &LazyMessage{cmdId: cmdId, payload: payload, codec: codec}
+}
+
+// DecodeLazy decompresses the payload of f without unmarshaling it.
+// The payload codec of f must be accepted by c, but needn't be registered.
+func (c *FrameCodec) DecodeLazy(f *Frame) (*LazyMessage, error) {
+	if err := c.acceptPayloadCodec(f); err != nil {
+		return nil, err
+	}
+	payload, err := c.decodePayload(f)
+	if err != nil {
+		return nil, err
+	}
+	return &LazyMessage{cmdId: f.CmdId, payload: payload, payloadCodec: f.Codec, codec: c}, nil
+}
+
+func (m *LazyMessage) CmdId() uint16 {
+	return m.cmdId
+}
+
+// CmdName returns the registered name of the cmdId, or an empty string
+// if it's not registered.
+func (m *LazyMessage) CmdName() string {
+	name, _ := CmdName(m.cmdId)
+	return name
+}
+
+// Payload returns the serialized message. It must not be modified.
+func (m *LazyMessage) Payload() []byte {
+	return m.payload
+}
+
+// PayloadCodec returns the id of the PayloadCodec of the payload.
+func (m *LazyMessage) PayloadCodec() uint8 {
+	return m.payloadCodec
+}
+
+// Frame returns an uncompressed frame carrying the message.
+func (m *LazyMessage) Frame() *Frame {
+	return &Frame{CmdId: m.cmdId, Codec: m.payloadCodec, Payload: m.payload}
+}
+
+// Decoded reports whether the message has been decoded.
+func (m *LazyMessage) Decoded() bool {
+	return m.decoded.Load()
+}
+
+// Message decodes the message on the first call, and returns the same
+// result afterwards.
+func (m *LazyMessage) Message() (CmdMessage, error) {
+	m.once.Do(func() {
+		m.msg, m.err = m.codec.Decode(m.Frame())
+		m.decoded.Store(true)
+	})
+	return m.msg, m.err
+}
+
+// ProtoReflect reflects the decoded message. If the message can't be
+// decoded, it reflects an empty message instead; call Message to get
+// the error.
+func (m *LazyMessage) ProtoReflect() protoreflect.Message {
+	msg, err := m.Message()
+	if err == nil {
+		return msg.ProtoReflect()
+	}
+	if empty, err := NewMessageByCmdId(m.cmdId); err == nil {
+		return empty.ProtoReflect()
+	}
+	return (&emptypb.Empty{}).ProtoReflect()
+}
+
+// Resolve returns the decoded message of msg if it's a *LazyMessage,
+// and msg itself otherwise.
+func Resolve(msg CmdMessage) (CmdMessage, error) {
+	if lazy, ok := msg.(*LazyMessage); ok {
+		return lazy.Message()
+	}
+	return msg, nil
+}
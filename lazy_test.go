@@ -0,0 +1,131 @@
+package protocmd_test
+
+import (
+	"bytes"
+	"github.com/stalomeow/protocmd"
+	"github.com/stalomeow/protocmd/examples/go/protos"
+	"google.golang.org/protobuf/proto"
+	"testing"
+	"time"
+)
+
+func TestLazyMessage(t *testing.T) {
+	f, err := protocmd.DefaultFrameCodec.Encode(&protos.TestReq{Uid: "42"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lazy, err := protocmd.DefaultFrameCodec.DecodeLazy(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lazy.CmdId() != protos.TestReq_CmdId || lazy.CmdName() != "TestReq" {
+		t.Fatalf("got cmd %v %q", lazy.CmdId(), lazy.CmdName())
+	}
+
+	// Encoding copies the payload without decoding it.
+	encoded, err := protocmd.DefaultFrameCodec.Encode(lazy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(encoded.Payload, f.Payload) {
+		t.Fatalf("got payload %x, want %x", encoded.Payload, f.Payload)
+	}
+	if lazy.Decoded() {
+		t.Fatal("encoding decoded the message")
+	}
+
+	msg, err := lazy.Message()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !lazy.Decoded() {
+		t.Fatal("Message didn't decode the message")
+	}
+	if !proto.Equal(msg, &protos.TestReq{Uid: "42"}) {
+		t.Fatalf("got %v", protocmd.CmdString(msg))
+	}
+	if again, _ := lazy.Message(); again != msg {
+		t.Fatal("the message was decoded twice")
+	}
+
+	// Changes to the decoded message are encoded.
+	msg.(*protos.TestReq).Uid = "43"
+	encoded, err = protocmd.DefaultFrameCodec.Encode(lazy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := protocmd.DefaultFrameCodec.Decode(encoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if uid := decoded.(*protos.TestReq).Uid; uid != "43" {
+		t.Fatalf("got uid %v, want 43", uid)
+	}
+}
+
+func TestLazyMessageUnregistered(t *testing.T) {
+	lazy := protocmd.NewLazyMessage(12345, []byte{0x0a, 0x01, 0x78}, nil)
+	if _, err := lazy.Message(); err == nil {
+		t.Fatal("an unregistered cmdId was decoded")
+	}
+	if lazy.ProtoReflect() == nil {
+		t.Fatal("ProtoReflect returned nil")
+	}
+
+	f, err := protocmd.DefaultFrameCodec.Encode(lazy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.CmdId != 12345 || !bytes.Equal(f.Payload, lazy.Payload()) {
+		t.Fatalf("got frame %v %x", f.CmdId, f.Payload)
+	}
+}
+
+func TestDispatcherLazyMessage(t *testing.T) {
+	d := protocmd.NewDispatcher()
+
+	var handled protocmd.CmdMessage
+	d.HandleFunc(protos.TestReq_CmdId, func(s *protocmd.Session, msg protocmd.CmdMessage) { handled = msg })
+
+	var notFound protocmd.CmdMessage
+	d.NotFound = protocmd.HandlerFunc(func(s *protocmd.Session, msg protocmd.CmdMessage) { notFound = msg })
+
+	payload, _ := proto.Marshal(&protos.TestReq{Uid: "1"})
+	d.ServeCmd(nil, protocmd.NewLazyMessage(protos.TestReq_CmdId, payload, nil))
+	if msg, ok := handled.(*protos.TestReq); !ok || msg.Uid != "1" {
+		t.Fatalf("the handler got %T", handled)
+	}
+
+	lazy := protocmd.NewLazyMessage(protos.TestRsp_CmdId, nil, nil)
+	d.ServeCmd(nil, lazy)
+	if notFound != lazy || lazy.Decoded() {
+		t.Fatal("NotFound didn't get the undecoded message")
+	}
+}
+
+func TestSessionLazyDecode(t *testing.T) {
+	received := make(chan protocmd.CmdMessage, 1)
+	h := protocmd.HandlerFunc(func(s *protocmd.Session, msg protocmd.CmdMessage) { received <- msg })
+	s1, _ := pipeSessions(t, nil, h, nil, &protocmd.SessionConfig{LazyDecode: true})
+
+	if err := s1.Send(&protos.TestReq{Uid: "7"}); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case msg := <-received:
+		lazy, ok := msg.(*protocmd.LazyMessage)
+		if !ok {
+			t.Fatalf("got %T, want *protocmd.LazyMessage", msg)
+		}
+		if lazy.Decoded() {
+			t.Fatal("the message was decoded on receipt")
+		}
+		if uid := lazy.ProtoReflect().Interface().(*protos.TestReq).Uid; uid != "7" {
+			t.Fatalf("got uid %v, want 7", uid)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the message")
+	}
+}
@@ -0,0 +1,207 @@
+package protocmd
+
+import (
+	"errors"
+	"fmt"
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+var ErrLimitExceeded = errors.New("protocmd: decode limit exceeded")
+
+// DecodeLimits limits the resources spent on decoding a message, so that
+// hostile payloads are rejected before they are unmarshaled. Zero fields
+// mean no limit.
+type DecodeLimits struct {
+	// MaxDepth limits the nesting depth of messages. The top-level
+	// message is at depth 1.
+	MaxDepth int
+
+	// MaxRepeated limits the number of elements of each repeated or
+	// map field.
+	MaxRepeated int
+
+	// MaxAlloc limits the estimated number of bytes allocated for the
+	// message, including its nested messages, strings and lists.
+	MaxAlloc int
+}
+
+// LimitError is returned when a payload exceeds its DecodeLimits.
+// It wraps ErrLimitExceeded.
+type LimitError struct {
+	CmdId uint16
+	Limit string // "depth", "repeated" or "alloc"
+	Field protoreflect.FullName
+	Max   int
+}
+
+func (e *LimitError) Error() string {
+	if e.Field == "" {
+		return fmt.Sprintf("protocmd: cmdId '%v' exceeds the %s limit %v", e.CmdId, e.Limit, e.Max)
+	}
+	return fmt.Sprintf("protocmd: cmdId '%v' exceeds the %s limit %v at %s", e.CmdId, e.Limit, e.Max, e.Field)
+}
+
+func (e *LimitError) Unwrap() error {
+	return ErrLimitExceeded
+}
+
+// Estimated sizes of decoded values, in bytes.
+const (
+	messageAllocBase  = 48 // the internal state of a generated message
+	fieldAllocSize    = 8  // a field of a generated message
+	elementAllocSize  = 8  // an element of a repeated scalar field
+	stringAllocHeader = 16
+)
+
+// Check reports whether the payload of a message described by desc is
+// within the limits. It scans the wire format without unmarshaling it.
+func (l *DecodeLimits) Check(cmdId uint16, desc protoreflect.MessageDescriptor, payload []byte) error {
+	s := &limitScanner{limits: l, cmdId: cmdId}
+	if err := s.scanMessage(desc, payload, 1); err != nil {
+		if _, ok := err.(*LimitError); ok {
+			return err
+		}
+		return fmt.Errorf("failed to unmarshal cmdId '%v': %w", cmdId, err)
+	}
+	return nil
+}
+
+type limitScanner struct {
+	limits *DecodeLimits
+	cmdId  uint16
+	alloc  int
+}
+
+func (s *limitScanner) exceeded(limit string, field protoreflect.FullName, max int) error {
+	return &LimitError{CmdId: s.cmdId, Limit: limit, Field: field, Max: max}
+}
+
+func (s *limitScanner) allocate(n int, field protoreflect.FullName) error {
+	s.alloc += n
+	if s.limits.MaxAlloc > 0 && s.alloc > s.limits.MaxAlloc {
+		return s.exceeded("alloc", field, s.limits.MaxAlloc)
+	}
+	return nil
+}
+
+func (s *limitScanner) scanMessage(desc protoreflect.MessageDescriptor, b []byte, depth int) error {
+	if s.limits.MaxDepth > 0 && depth > s.limits.MaxDepth {
+		return s.exceeded("depth", desc.FullName(), s.limits.MaxDepth)
+	}
+	if err := s.allocate(messageAllocBase+fieldAllocSize*desc.Fields().Len(), desc.FullName()); err != nil {
+		return err
+	}
+
+	var counts map[protowire.Number]int
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+
+		n = protowire.ConsumeFieldValue(num, typ, b)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		value := b[:n]
+		b = b[n:]
+
+		fd := desc.Fields().ByNumber(num)
+		if fd == nil {
+			// Unknown fields are kept as raw bytes.
+			if err := s.allocate(n, desc.FullName()); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if fd.IsList() || fd.IsMap() {
+			count := 1
+			if typ == protowire.BytesType && isPackable(fd.Kind()) {
+				count = countPacked(fd.Kind(), value)
+			}
+			if counts == nil {
+				counts = make(map[protowire.Number]int)
+			}
+			counts[num] += count
+			if s.limits.MaxRepeated > 0 && counts[num] > s.limits.MaxRepeated {
+				return s.exceeded("repeated", fd.FullName(), s.limits.MaxRepeated)
+			}
+			if err := s.allocate(elementAllocSize*count, fd.FullName()); err != nil {
+				return err
+			}
+		}
+
+		if err := s.scanValue(fd, typ, value, depth); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *limitScanner) scanValue(fd protoreflect.FieldDescriptor, typ protowire.Type, value []byte, depth int) error {
+	switch fd.Kind() {
+	case protoreflect.MessageKind:
+		if typ != protowire.BytesType {
+			// Unmarshal keeps a value of the wrong type as an unknown field.
+			return s.allocate(len(value), fd.FullName())
+		}
+		content, n := protowire.ConsumeBytes(value)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		if fd.IsMap() {
+			// A map entry isn't a level of nesting.
+			return s.scanMessage(fd.Message(), content, depth)
+		}
+		return s.scanMessage(fd.Message(), content, depth+1)
+	case protoreflect.GroupKind:
+		if typ != protowire.StartGroupType {
+			return s.allocate(len(value), fd.FullName())
+		}
+		content, n := protowire.ConsumeGroup(fd.Number(), value)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		return s.scanMessage(fd.Message(), content, depth+1)
+	case protoreflect.StringKind, protoreflect.BytesKind:
+		if typ == protowire.BytesType {
+			return s.allocate(stringAllocHeader+len(value), fd.FullName())
+		}
+	}
+	return nil
+}
+
+func isPackable(kind protoreflect.Kind) bool {
+	switch kind {
+	case protoreflect.StringKind, protoreflect.BytesKind, protoreflect.MessageKind, protoreflect.GroupKind:
+		return false
+	default:
+		return true
+	}
+}
+
+// countPacked counts the elements of a packed repeated field.
+func countPacked(kind protoreflect.Kind, value []byte) int {
+	content, n := protowire.ConsumeBytes(value)
+	if n < 0 {
+		return 0
+	}
+
+	switch kind {
+	case protoreflect.Fixed32Kind, protoreflect.Sfixed32Kind, protoreflect.FloatKind:
+		return len(content) / 4
+	case protoreflect.Fixed64Kind, protoreflect.Sfixed64Kind, protoreflect.DoubleKind:
+		return len(content) / 8
+	default:
+		count := 0
+		for _, c := range content {
+			if c < 0x80 {
+				count++
+			}
+		}
+		return count
+	}
+}
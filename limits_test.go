@@ -0,0 +1,75 @@
+package protocmd_test
+
+import (
+	"errors"
+	"github.com/stalomeow/protocmd"
+	"github.com/stalomeow/protocmd/examples/go/protos"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"testing"
+)
+
+func newTestRsp(transforms int) *protos.TestRsp {
+	rsp := &protos.TestRsp{}
+	for i := 0; i < transforms; i++ {
+		rsp.Transforms = append(rsp.Transforms, &protos.TestRsp_TransformInfo{Position: &protos.Vector3{X: 1}})
+	}
+	return rsp
+}
+
+func TestDecodeLimits(t *testing.T) {
+	f, err := protocmd.DefaultFrameCodec.Encode(newTestRsp(11))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		limits *protocmd.DecodeLimits
+		limit  string
+	}{
+		{&protocmd.DecodeLimits{MaxDepth: 3, MaxRepeated: 11, MaxAlloc: 1 << 20}, ""},
+		{&protocmd.DecodeLimits{MaxDepth: 2}, "depth"},
+		{&protocmd.DecodeLimits{MaxRepeated: 10}, "repeated"},
+		{&protocmd.DecodeLimits{MaxAlloc: 1000}, "alloc"},
+	}
+
+	for _, tt := range tests {
+		_, err := (&protocmd.FrameCodec{Limits: tt.limits}).Decode(f)
+		if tt.limit == "" {
+			if err != nil {
+				t.Errorf("%+v: %v", *tt.limits, err)
+			}
+			continue
+		}
+
+		var le *protocmd.LimitError
+		if !errors.Is(err, protocmd.ErrLimitExceeded) || !errors.As(err, &le) || le.Limit != tt.limit {
+			t.Errorf("%+v: Decode returned %v, want a LimitError of %s", *tt.limits, err, tt.limit)
+		}
+	}
+
+	// CmdLimits overrides Limits.
+	codec := &protocmd.FrameCodec{
+		Limits:    &protocmd.DecodeLimits{MaxRepeated: 1},
+		CmdLimits: map[uint16]*protocmd.DecodeLimits{protos.TestRsp_CmdId: nil},
+	}
+	if _, err := codec.Decode(f); err != nil {
+		t.Errorf("Decode with CmdLimits returned %v", err)
+	}
+}
+
+func TestDecodeLimitsPacked(t *testing.T) {
+	loc := &descriptorpb.SourceCodeInfo_Location{Path: make([]int32, 100), Span: []int32{1, 2, 3}}
+	b, err := proto.Marshal(loc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	desc := loc.ProtoReflect().Descriptor()
+
+	if err := (&protocmd.DecodeLimits{MaxRepeated: 100}).Check(0, desc, b); err != nil {
+		t.Fatal(err)
+	}
+	if err := (&protocmd.DecodeLimits{MaxRepeated: 99}).Check(0, desc, b); !errors.Is(err, protocmd.ErrLimitExceeded) {
+		t.Fatalf("Check returned %v, want %v", err, protocmd.ErrLimitExceeded)
+	}
+}
@@ -0,0 +1,152 @@
+package protocmd
+
+import (
+	"errors"
+	"fmt"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"slices"
+	"sync"
+)
+
+// Ids of the built-in payload codecs. Ids from 128 to 255 are free for
+// user-defined payload codecs.
+const (
+	ProtoPayloadCodecId uint8 = 0
+	JSONPayloadCodecId  uint8 = 1
+)
+
+var (
+	ErrUnknownPayloadCodec  = errors.New("protocmd: unknown payload codec")
+	ErrPayloadCodecRejected = errors.New("protocmd: payload codec not accepted")
+)
+
+// PayloadCodec serializes messages into the payloads of frames. A frame
+// encoded by a codec other than ProtoPayloadCodec carries the id of its
+// codec in the header. The receiver decodes it if the codec is registered
+// and accepted by its FrameCodec.
+type PayloadCodec interface {
+	Id() uint8
+
+	// Marshal appends the serialized msg to dst.
+	Marshal(dst []byte, msg CmdMessage) ([]byte, error)
+
+	// Unmarshal deserializes b into msg, which is empty.
+	Unmarshal(b []byte, msg CmdMessage) error
+}
+
+var (
+	ProtoPayloadCodec PayloadCodec = protoPayloadCodec{}
+	JSONPayloadCodec  PayloadCodec = jsonPayloadCodec{}
+)
+
+var (
+	payloadCodecsMu sync.RWMutex
+	payloadCodecs   = map[uint8]PayloadCodec{
+		ProtoPayloadCodecId: ProtoPayloadCodec,
+		JSONPayloadCodecId:  JSONPayloadCodec,
+	}
+)
+
+// RegisterPayloadCodec makes c available for decoding frames.
+func RegisterPayloadCodec(c PayloadCodec) {
+	payloadCodecsMu.Lock()
+	defer payloadCodecsMu.Unlock()
+
+	if _, ok := payloadCodecs[c.Id()]; ok {
+		panic(fmt.Sprintf("protocmd: payload codec %v is already registered", c.Id()))
+	}
+	payloadCodecs[c.Id()] = c
+}
+
+func payloadCodecById(id uint8) (PayloadCodec, bool) {
+	payloadCodecsMu.RLock()
+	defer payloadCodecsMu.RUnlock()
+	c, ok := payloadCodecs[id]
+	return c, ok
+}
+
+func (c *FrameCodec) payloadCodec() PayloadCodec {
+	if c == nil || c.PayloadCodec == nil {
+		return ProtoPayloadCodec
+	}
+	return c.PayloadCodec
+}
+
+// payloadCodecOf returns the payload codec of f if c accepts it.
+func (c *FrameCodec) payloadCodecOf(f *Frame) (PayloadCodec, error) {
+	if err := c.acceptPayloadCodec(f); err != nil {
+		return nil, err
+	}
+	pc, ok := payloadCodecById(f.Codec)
+	if !ok {
+		return nil, ErrUnknownPayloadCodec
+	}
+	return pc, nil
+}
+
+// acceptPayloadCodec returns ErrPayloadCodecRejected unless the payload
+// codec of f is the one of c or listed in AcceptPayloadCodecs.
+func (c *FrameCodec) acceptPayloadCodec(f *Frame) error {
+	if f.Codec == c.payloadCodec().Id() {
+		return nil
+	}
+	if c != nil && slices.Contains(c.AcceptPayloadCodecs, f.Codec) {
+		return nil
+	}
+	return fmt.Errorf("%w: %v of cmdId '%v'", ErrPayloadCodecRejected, f.Codec, f.CmdId)
+}
+
+// appendMarshal appends msg serialized by the payload codec to b. The
+// payload of a LazyMessage is copied as it is if it's in the same codec,
+// unless it has been decoded successfully.
+func (c *FrameCodec) appendMarshal(b []byte, msg CmdMessage) ([]byte, uint8, error) {
+	pc := c.payloadCodec()
+	if lazy, ok := msg.(*LazyMessage); ok {
+		if !lazy.Decoded() && lazy.payloadCodec == pc.Id() {
+			return append(b, lazy.payload...), pc.Id(), nil
+		}
+		decoded, err := lazy.Message()
+		if err != nil {
+			if lazy.payloadCodec == pc.Id() {
+				return append(b, lazy.payload...), pc.Id(), nil
+			}
+			return nil, 0, err
+		}
+		msg = decoded
+	}
+
+	b, err := pc.Marshal(b, msg)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to marshal cmdId '%v': %w", msg.CmdId(), err)
+	}
+	return b, pc.Id(), nil
+}
+
+type protoPayloadCodec struct{}
+
+func (protoPayloadCodec) Id() uint8 {
+	return ProtoPayloadCodecId
+}
+
+func (protoPayloadCodec) Marshal(dst []byte, msg CmdMessage) ([]byte, error) {
+	return proto.MarshalOptions{}.MarshalAppend(dst, msg)
+}
+
+func (protoPayloadCodec) Unmarshal(b []byte, msg CmdMessage) error {
+	return proto.Unmarshal(b, msg)
+}
+
+type jsonPayloadCodec struct{}
+
+func (jsonPayloadCodec) Id() uint8 {
+	return JSONPayloadCodecId
+}
+
+func (jsonPayloadCodec) Marshal(dst []byte, msg CmdMessage) ([]byte, error) {
+	return protojson.MarshalOptions{}.MarshalAppend(dst, msg)
+}
+
+func (jsonPayloadCodec) Unmarshal(b []byte, msg CmdMessage) error {
+	return protojson.Unmarshal(b, msg)
+}
@@ -0,0 +1,205 @@
+package protocmd_test
+
+import (
+	"encoding/hex"
+	"errors"
+	"github.com/stalomeow/protocmd"
+	"github.com/stalomeow/protocmd/examples/go/protos"
+	"google.golang.org/protobuf/proto"
+	"testing"
+)
+
+// reversedCodec is a user payload codec storing proto binary backwards.
+type reversedCodec struct{}
+
+func (reversedCodec) Id() uint8 { return 200 }
+
+func (reversedCodec) Marshal(dst []byte, msg protocmd.CmdMessage) ([]byte, error) {
+	b, err := proto.Marshal(msg)
+	for i := len(b) - 1; i >= 0; i-- {
+		dst = append(dst, b[i])
+	}
+	return dst, err
+}
+
+func (reversedCodec) Unmarshal(b []byte, msg protocmd.CmdMessage) error {
+	r := make([]byte, len(b))
+	for i := range b {
+		r[len(b)-1-i] = b[i]
+	}
+	return proto.Unmarshal(r, msg)
+}
+
+func init() {
+	protocmd.RegisterPayloadCodec(reversedCodec{})
+}
+
+func TestPayloadCodecs(t *testing.T) {
+	msg := &protos.TestReq{Uid: "1"}
+
+	for _, pc := range []protocmd.PayloadCodec{protocmd.ProtoPayloadCodec, protocmd.JSONPayloadCodec, reversedCodec{}} {
+		codec := &protocmd.FrameCodec{PayloadCodec: pc, Checksum: protocmd.ChecksumCRC32}
+
+		f, err := codec.Encode(msg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if f.Codec != pc.Id() {
+			t.Fatalf("codec %v: got frame of codec %v", pc.Id(), f.Codec)
+		}
+		f.Seq = 1
+
+		b, err := codec.AppendFrame(nil, f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		parsed, _, err := codec.ParseFrame(b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if parsed.Codec != pc.Id() || parsed.Seq != 1 || parsed.Flags != 0 {
+			t.Fatalf("codec %v: parsed %+v", pc.Id(), parsed)
+		}
+
+		decoded, err := codec.Decode(parsed)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !proto.Equal(decoded, msg) {
+			t.Fatalf("codec %v: got %v", pc.Id(), protocmd.CmdString(decoded))
+		}
+	}
+}
+
+func TestPayloadCodecWire(t *testing.T) {
+	codec := &protocmd.FrameCodec{PayloadCodec: protocmd.JSONPayloadCodec}
+	f, err := codec.Encode(&protos.TestReq{Uid: "1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The id of the codec follows the header.
+	b, _ := codec.AppendFrame(nil, f)
+	if got, want := hex.EncodeToString(b), "0000000c03f22001"+hex.EncodeToString(f.Payload); got != want {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	if string(f.Payload) != `{"uid":"1"}` {
+		t.Fatalf("got payload %s", f.Payload)
+	}
+
+	// Other codecs must be accepted explicitly.
+	if _, err := protocmd.DefaultFrameCodec.Decode(f); !errors.Is(err, protocmd.ErrPayloadCodecRejected) {
+		t.Fatalf("got %v, want ErrPayloadCodecRejected", err)
+	}
+	if _, err := protocmd.DefaultFrameCodec.DecodeLazy(f); !errors.Is(err, protocmd.ErrPayloadCodecRejected) {
+		t.Fatalf("got %v, want ErrPayloadCodecRejected", err)
+	}
+	accepting := &protocmd.FrameCodec{AcceptPayloadCodecs: []uint8{protocmd.JSONPayloadCodecId, 123}}
+	if _, err := accepting.Decode(f); err != nil {
+		t.Fatal(err)
+	}
+
+	f.Codec = 123
+	if _, err := accepting.Decode(f); !errors.Is(err, protocmd.ErrUnknownPayloadCodec) {
+		t.Fatalf("got %v, want ErrUnknownPayloadCodec", err)
+	}
+}
+
+func TestPayloadCodecLimits(t *testing.T) {
+	large := &protos.TestRsp{Transforms: make([]*protos.TestRsp_TransformInfo, 5000)}
+	for i := range large.Transforms {
+		large.Transforms[i] = &protos.TestRsp_TransformInfo{}
+	}
+	f, err := (&protocmd.FrameCodec{PayloadCodec: protocmd.JSONPayloadCodec}).Encode(large)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// JSON can't be checked, so it doesn't get past the limits even if accepted.
+	for _, codec := range []*protocmd.FrameCodec{
+		{Limits: &protocmd.DecodeLimits{MaxAlloc: 1000, MaxRepeated: 10}, AcceptPayloadCodecs: []uint8{protocmd.JSONPayloadCodecId}},
+		{Limits: &protocmd.DecodeLimits{MaxAlloc: 1000, MaxRepeated: 10}, PayloadCodec: protocmd.JSONPayloadCodec},
+	} {
+		if _, err := codec.Decode(f); !errors.Is(err, protocmd.ErrLimitExceeded) {
+			t.Fatalf("got %v, want ErrLimitExceeded", err)
+		}
+	}
+}
+
+func TestPayloadCodecBatch(t *testing.T) {
+	codec := &protocmd.FrameCodec{PayloadCodec: protocmd.JSONPayloadCodec}
+	msgs := []protocmd.CmdMessage{&protos.TestReq{Uid: "1"}, &protos.TestRsp{RetCode: 2}}
+
+	f, err := codec.EncodeBatch(msgs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := codec.DecodeBatch(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(decoded) != len(msgs) {
+		t.Fatalf("got %v messages, want %v", len(decoded), len(msgs))
+	}
+	for i := range msgs {
+		if !proto.Equal(decoded[i], msgs[i]) {
+			t.Fatalf("got %v, want %v", protocmd.CmdString(decoded[i]), protocmd.CmdString(msgs[i]))
+		}
+	}
+}
+
+func TestPayloadCodecLazyMessage(t *testing.T) {
+	jsonCodec := &protocmd.FrameCodec{PayloadCodec: protocmd.JSONPayloadCodec}
+	f, err := jsonCodec.Encode(&protos.TestReq{Uid: "1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	lazy, err := jsonCodec.DecodeLazy(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The same codec copies the payload, and others convert it.
+	same, err := jsonCodec.Encode(lazy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lazy.Decoded() || string(same.Payload) != string(f.Payload) {
+		t.Fatal("the payload wasn't copied")
+	}
+
+	converted, err := protocmd.DefaultFrameCodec.Encode(lazy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, _ := proto.Marshal(&protos.TestReq{Uid: "1"})
+	if converted.Codec != protocmd.ProtoPayloadCodecId || string(converted.Payload) != string(want) {
+		t.Fatalf("got frame of codec %v with payload %x", converted.Codec, converted.Payload)
+	}
+
+	if _, err := lazy.PeekField("uid"); err == nil {
+		t.Fatal("PeekField succeeded on a JSON payload")
+	}
+}
+
+func TestSessionPayloadCodecs(t *testing.T) {
+	h, ch := collect()
+	config := &protocmd.SessionConfig{Codec: &protocmd.FrameCodec{PayloadCodec: protocmd.JSONPayloadCodec}}
+	s1, _ := pipeSessions(t, nil, h, config, config)
+
+	if err := s1.Send(&protos.TestReq{Uid: "json"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s1.StartEncryption(); err != nil {
+		t.Fatal(err)
+	}
+	if err := s1.Send(&protos.TestReq{Uid: "sealed"}); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, uid := range []string{"json", "sealed"} {
+		if got := receive(t, ch).(*protos.TestReq).Uid; got != uid {
+			t.Fatalf("got uid %v, want %v", got, uid)
+		}
+	}
+}
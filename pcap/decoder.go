@@ -0,0 +1,225 @@
+// Package pcap decodes cmd traffic from pcap and pcapng capture files offline.
+//
+// TCP streams to or from the server ports are reassembled and split into
+// frames, which are reported in the order they are completed:
+//
+//	d := &pcap.Decoder{ServerPorts: []uint16{7777}}
+//	err := d.Decode(file, func(r *pcap.Record) error {
+//		msg, err := protocmd.DefaultFrameCodec.Decode(r.Frame)
+//		...
+//	})
+package pcap
+
+import (
+	"errors"
+	"fmt"
+	"github.com/stalomeow/protocmd"
+	"io"
+	"net/netip"
+	"slices"
+	"time"
+)
+
+// maxPendingSize limits the out-of-order bytes buffered for a stream.
+const maxPendingSize = 4 << 20
+
+type Direction uint8
+
+const (
+	ClientToServer Direction = iota
+	ServerToClient
+)
+
+func (d Direction) String() string {
+	if d == ClientToServer {
+		return "c2s"
+	}
+	return "s2c"
+}
+
+// Record is a frame found in a capture file.
+type Record struct {
+	Time      time.Time // time of the packet completing the frame
+	Direction Direction
+	Src, Dst  netip.AddrPort
+	Frame     *protocmd.Frame
+
+	// Err is set if the stream can't be split into frames any more, e.g. because
+	// the capture started in the middle of a frame. Frame is nil in this case, and
+	// the rest of the stream is skipped.
+	Err error
+}
+
+type Decoder struct {
+	// ServerPorts are the TCP ports of servers. Packets from or to other ports are ignored.
+	ServerPorts []uint16
+
+	// Codec parses frames. If it's nil, protocmd.DefaultFrameCodec is used.
+	Codec *protocmd.FrameCodec
+}
+
+type streamKey struct {
+	src, dst netip.AddrPort
+}
+
+type stream struct {
+	started bool
+	broken  bool
+	nextSeq uint32
+	pending map[uint32][]byte // out-of-order segments by sequence number
+	pendLen int
+	buf     []byte // bytes not yet split into frames
+}
+
+// Decode reads a pcap or pcapng file from r and calls fn for every frame.
+// Decoding stops at the first error returned by fn.
+func (d *Decoder) Decode(r io.Reader, fn func(*Record) error) error {
+	pr, err := newPacketReader(r)
+	if err != nil {
+		return err
+	}
+
+	streams := make(map[streamKey]*stream)
+	for {
+		p, err := pr.readPacket()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		seg, ok := parseTCP(p)
+		if !ok {
+			continue
+		}
+
+		var dir Direction
+		switch {
+		case slices.Contains(d.ServerPorts, seg.dst.Port()):
+			dir = ClientToServer
+		case slices.Contains(d.ServerPorts, seg.src.Port()):
+			dir = ServerToClient
+		default:
+			continue
+		}
+
+		key := streamKey{seg.src, seg.dst}
+		s, ok := streams[key]
+		if !ok || seg.flags&tcpFlagSYN != 0 {
+			s = &stream{pending: make(map[uint32][]byte)}
+			streams[key] = s
+		}
+
+		if err := d.process(s, seg, func(f *protocmd.Frame, err error) error {
+			return fn(&Record{
+				Time:      p.time,
+				Direction: dir,
+				Src:       seg.src,
+				Dst:       seg.dst,
+				Frame:     f,
+				Err:       err,
+			})
+		}); err != nil {
+			return err
+		}
+
+		if seg.flags&(tcpFlagFIN|tcpFlagRST) != 0 {
+			delete(streams, key)
+		}
+	}
+}
+
+func (d *Decoder) process(s *stream, seg *tcpSegment, emit func(*protocmd.Frame, error) error) error {
+	if s.broken {
+		return nil
+	}
+
+	seq, payload := seg.seq, seg.payload
+	if !s.started {
+		switch {
+		case seg.flags&tcpFlagSYN != 0:
+			s.nextSeq = seq + 1
+			s.started = true
+		case len(payload) > 0:
+			// The capture started in the middle of the stream.
+			s.nextSeq = seq
+			s.started = true
+		default:
+			return nil
+		}
+	}
+	if seg.flags&tcpFlagSYN != 0 {
+		seq++ // SYN occupies one sequence number
+	}
+	if len(payload) <= 0 {
+		return nil
+	}
+
+	if int32(seq-s.nextSeq) > 0 {
+		if s.pendLen+len(payload) > maxPendingSize {
+			s.broken = true
+			return emit(nil, fmt.Errorf("missing %d bytes in TCP stream", seq-s.nextSeq))
+		}
+		if _, ok := s.pending[seq]; !ok {
+			s.pending[seq] = slices.Clone(payload)
+			s.pendLen += len(payload)
+		}
+		return nil
+	}
+
+	s.append(seq, payload)
+	for progress := true; progress; {
+		progress = false
+		for pendSeq, data := range s.pending {
+			if int32(pendSeq-s.nextSeq) > 0 {
+				continue
+			}
+			delete(s.pending, pendSeq)
+			s.pendLen -= len(data)
+			s.append(pendSeq, data)
+			progress = true
+		}
+	}
+
+	codec := d.Codec
+	if codec == nil {
+		codec = protocmd.DefaultFrameCodec
+	}
+
+	for {
+		f, n, err := codec.ParseFrame(s.buf)
+		if errors.Is(err, protocmd.ErrShortFrame) {
+			break
+		}
+		if err != nil {
+			s.broken = true
+			s.buf = nil
+			return emit(nil, err)
+		}
+
+		f.Payload = slices.Clone(f.Payload)
+		s.buf = s.buf[n:]
+		if err := emit(f, nil); err != nil {
+			return err
+		}
+	}
+
+	// Release the consumed bytes.
+	if len(s.buf) == 0 {
+		s.buf = nil
+	}
+	return nil
+}
+
+// append appends the part of payload after nextSeq to the stream. Bytes before
+// nextSeq are retransmissions and dropped.
+func (s *stream) append(seq uint32, payload []byte) {
+	overlap := int(s.nextSeq - seq)
+	if overlap >= len(payload) {
+		return
+	}
+	payload = payload[overlap:]
+	s.buf = append(s.buf, payload...)
+	s.nextSeq += uint32(len(payload))
+}
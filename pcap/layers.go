@@ -0,0 +1,158 @@
+package pcap
+
+import (
+	"encoding/binary"
+	"net/netip"
+)
+
+const (
+	etherTypeIPv4 = 0x0800
+	etherTypeIPv6 = 0x86dd
+	etherTypeVLAN = 0x8100
+	etherTypeQinQ = 0x88a8
+
+	ipProtoTCP = 6
+
+	tcpFlagFIN = 0x01
+	tcpFlagSYN = 0x02
+	tcpFlagRST = 0x04
+)
+
+// tcpSegment is the part of a TCP packet needed for reassembly.
+type tcpSegment struct {
+	src, dst netip.AddrPort
+	seq      uint32
+	flags    uint8
+	payload  []byte
+}
+
+// parseTCP extracts the TCP segment from a packet. ok is false if the packet
+// isn't a TCP packet over IP, or is malformed, or is an IP fragment.
+func parseTCP(p *packet) (seg *tcpSegment, ok bool) {
+	data := p.data
+	var etherType uint16
+
+	switch p.linkType {
+	case linkTypeEthernet:
+		if len(data) < 14 {
+			return nil, false
+		}
+		etherType = binary.BigEndian.Uint16(data[12:])
+		data = data[14:]
+		for (etherType == etherTypeVLAN || etherType == etherTypeQinQ) && len(data) >= 4 {
+			etherType = binary.BigEndian.Uint16(data[2:])
+			data = data[4:]
+		}
+	case linkTypeNull:
+		// The address family is in the byte order of the capturing host.
+		if len(data) < 4 {
+			return nil, false
+		}
+		family := binary.LittleEndian.Uint32(data)
+		if family > 0xffff {
+			family = binary.BigEndian.Uint32(data)
+		}
+		switch family {
+		case 2:
+			etherType = etherTypeIPv4
+		case 10, 24, 28, 30: // AF_INET6 differs between platforms
+			etherType = etherTypeIPv6
+		}
+		data = data[4:]
+	case linkTypeLinuxSLL:
+		if len(data) < 16 {
+			return nil, false
+		}
+		etherType = binary.BigEndian.Uint16(data[14:])
+		data = data[16:]
+	case linkTypeLinuxSLL2:
+		if len(data) < 20 {
+			return nil, false
+		}
+		etherType = binary.BigEndian.Uint16(data[0:])
+		data = data[20:]
+	case linkTypeRaw, linkTypeIPv4, linkTypeIPv6:
+		if len(data) < 1 {
+			return nil, false
+		}
+		switch data[0] >> 4 {
+		case 4:
+			etherType = etherTypeIPv4
+		case 6:
+			etherType = etherTypeIPv6
+		}
+	default:
+		return nil, false
+	}
+
+	var srcAddr, dstAddr netip.Addr
+	switch etherType {
+	case etherTypeIPv4:
+		if len(data) < 20 || data[0]>>4 != 4 {
+			return nil, false
+		}
+		headerLen := int(data[0]&0x0f) * 4
+		totalLen := int(binary.BigEndian.Uint16(data[2:]))
+		fragment := binary.BigEndian.Uint16(data[6:])
+		if headerLen < 20 || totalLen < headerLen || len(data) < headerLen || data[9] != ipProtoTCP {
+			return nil, false
+		}
+		if fragment&0x3fff != 0 { // more fragments, or fragment offset
+			return nil, false
+		}
+		srcAddr = netip.AddrFrom4([4]byte(data[12:16]))
+		dstAddr = netip.AddrFrom4([4]byte(data[16:20]))
+		if totalLen < len(data) {
+			data = data[:totalLen] // strip Ethernet padding
+		}
+		data = data[headerLen:]
+	case etherTypeIPv6:
+		if len(data) < 40 || data[0]>>4 != 6 {
+			return nil, false
+		}
+		payloadLen := int(binary.BigEndian.Uint16(data[4:]))
+		nextHeader := data[6]
+		srcAddr = netip.AddrFrom16([16]byte(data[8:24]))
+		dstAddr = netip.AddrFrom16([16]byte(data[24:40]))
+		data = data[40:]
+		if payloadLen < len(data) {
+			data = data[:payloadLen]
+		}
+
+		// Skip extension headers.
+		for nextHeader != ipProtoTCP {
+			switch nextHeader {
+			case 0, 43, 60: // hop-by-hop, routing, destination options
+				if len(data) < 8 {
+					return nil, false
+				}
+				extLen := (int(data[1]) + 1) * 8
+				if len(data) < extLen {
+					return nil, false
+				}
+				nextHeader = data[0]
+				data = data[extLen:]
+			default: // fragments and other protocols
+				return nil, false
+			}
+		}
+	default:
+		return nil, false
+	}
+
+	if len(data) < 20 {
+		return nil, false
+	}
+	dataOffset := int(data[12]>>4) * 4
+	if dataOffset < 20 || len(data) < dataOffset {
+		return nil, false
+	}
+
+	return &tcpSegment{
+		src:     netip.AddrPortFrom(srcAddr, binary.BigEndian.Uint16(data[0:])),
+		dst:     netip.AddrPortFrom(dstAddr, binary.BigEndian.Uint16(data[2:])),
+		seq:     binary.BigEndian.Uint32(data[4:]),
+		flags:   data[13],
+		payload: data[dataOffset:],
+	}, true
+}
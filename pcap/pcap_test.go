@@ -0,0 +1,392 @@
+package pcap_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"github.com/stalomeow/protocmd"
+	"github.com/stalomeow/protocmd/examples/go/protos"
+	"github.com/stalomeow/protocmd/pcap"
+	"io"
+	"net/netip"
+	"strings"
+	"testing"
+	"time"
+)
+
+const (
+	synFlag = 0x02
+	ackFlag = 0x10
+	finFlag = 0x01
+)
+
+var (
+	client4 = netip.MustParseAddrPort("10.0.0.1:50000")
+	server4 = netip.MustParseAddrPort("10.0.0.2:7777")
+	client6 = netip.MustParseAddrPort("[fd00::1]:50000")
+	server6 = netip.MustParseAddrPort("[fd00::2]:7777")
+)
+
+// segment is a TCP segment to put into a capture.
+type segment struct {
+	src, dst netip.AddrPort
+	seq      uint32
+	flags    byte
+	payload  []byte
+}
+
+// ipPacket builds an IPv4 or IPv6 packet carrying seg.
+func ipPacket(seg segment) []byte {
+	tcp := make([]byte, 20)
+	binary.BigEndian.PutUint16(tcp[0:], seg.src.Port())
+	binary.BigEndian.PutUint16(tcp[2:], seg.dst.Port())
+	binary.BigEndian.PutUint32(tcp[4:], seg.seq)
+	tcp[12] = 5 << 4
+	tcp[13] = seg.flags | ackFlag
+	tcp = append(tcp, seg.payload...)
+
+	if seg.src.Addr().Is4() {
+		ip := make([]byte, 20)
+		ip[0] = 0x45
+		binary.BigEndian.PutUint16(ip[2:], uint16(20+len(tcp)))
+		ip[8] = 64
+		ip[9] = 6
+		src, dst := seg.src.Addr().As4(), seg.dst.Addr().As4()
+		copy(ip[12:], src[:])
+		copy(ip[16:], dst[:])
+		return append(ip, tcp...)
+	}
+
+	ip := make([]byte, 40)
+	ip[0] = 0x60
+	binary.BigEndian.PutUint16(ip[4:], uint16(len(tcp)))
+	ip[6] = 6
+	ip[7] = 64
+	src, dst := seg.src.Addr().As16(), seg.dst.Addr().As16()
+	copy(ip[8:], src[:])
+	copy(ip[24:], dst[:])
+	return append(ip, tcp...)
+}
+
+func etherType(ip []byte) uint16 {
+	if ip[0]>>4 == 6 {
+		return 0x86dd
+	}
+	return 0x0800
+}
+
+// A link wraps IP packets into the frames of a link type.
+type link struct {
+	name     string
+	linkType uint32
+	wrap     func(ip []byte) []byte
+}
+
+var links = []link{
+	{"Ethernet", 1, func(ip []byte) []byte {
+		eth := make([]byte, 14)
+		binary.BigEndian.PutUint16(eth[12:], etherType(ip))
+		return append(eth, ip...)
+	}},
+	{"VLAN", 1, func(ip []byte) []byte {
+		eth := make([]byte, 18)
+		binary.BigEndian.PutUint16(eth[12:], 0x8100)
+		binary.BigEndian.PutUint16(eth[16:], etherType(ip))
+		return append(eth, ip...)
+	}},
+	{"Null", 0, func(ip []byte) []byte {
+		family := uint32(2)
+		if ip[0]>>4 == 6 {
+			family = 30
+		}
+		return append(binary.LittleEndian.AppendUint32(nil, family), ip...)
+	}},
+	{"LinuxSLL", 113, func(ip []byte) []byte {
+		sll := make([]byte, 16)
+		binary.BigEndian.PutUint16(sll[14:], etherType(ip))
+		return append(sll, ip...)
+	}},
+	{"LinuxSLL2", 276, func(ip []byte) []byte {
+		sll := make([]byte, 20)
+		binary.BigEndian.PutUint16(sll[0:], etherType(ip))
+		return append(sll, ip...)
+	}},
+	{"Raw", 101, func(ip []byte) []byte { return ip }},
+}
+
+// A format writes packets into a capture file.
+type format struct {
+	name  string
+	ng    bool
+	order binary.AppendByteOrder
+	nanos bool
+}
+
+var formats = []format{
+	{"pcap/le/micros", false, binary.LittleEndian, false},
+	{"pcap/be/nanos", false, binary.BigEndian, true},
+	{"pcapng/le", true, binary.LittleEndian, true},
+	{"pcapng/be", true, binary.BigEndian, true},
+}
+
+func (f format) write(linkType uint32, times []time.Time, packets [][]byte) []byte {
+	if f.ng {
+		return pcapngFile(f.order, linkType, times, packets)
+	}
+	return pcapFile(f.order, f.nanos, linkType, times, packets)
+}
+
+func pcapFile(order binary.AppendByteOrder, nanos bool, linkType uint32, times []time.Time, packets [][]byte) []byte {
+	magic := uint32(0xa1b2c3d4)
+	if nanos {
+		magic = 0xa1b23c4d
+	}
+	b := order.AppendUint32(nil, magic)
+	b = order.AppendUint16(b, 2)
+	b = order.AppendUint16(b, 4)
+	b = order.AppendUint32(b, 0)
+	b = order.AppendUint32(b, 0)
+	b = order.AppendUint32(b, 65535)
+	b = order.AppendUint32(b, linkType)
+
+	for i, p := range packets {
+		frac := times[i].Nanosecond() / 1000
+		if nanos {
+			frac = times[i].Nanosecond()
+		}
+		b = order.AppendUint32(b, uint32(times[i].Unix()))
+		b = order.AppendUint32(b, uint32(frac))
+		b = order.AppendUint32(b, uint32(len(p)))
+		b = order.AppendUint32(b, uint32(len(p)))
+		b = append(b, p...)
+	}
+	return b
+}
+
+// pcapngFile writes an interface with nanosecond timestamps, and puts the
+// packets into enhanced packet blocks.
+func pcapngFile(order binary.AppendByteOrder, linkType uint32, times []time.Time, packets [][]byte) []byte {
+	block := func(b []byte, blockType uint32, body []byte) []byte {
+		for len(body)%4 != 0 {
+			body = append(body, 0)
+		}
+		b = order.AppendUint32(b, blockType)
+		b = order.AppendUint32(b, uint32(12+len(body)))
+		b = append(b, body...)
+		return order.AppendUint32(b, uint32(12+len(body)))
+	}
+
+	shb := order.AppendUint32(nil, 0x1a2b3c4d)
+	shb = order.AppendUint16(shb, 1)
+	shb = order.AppendUint16(shb, 0)
+	shb = order.AppendUint64(shb, ^uint64(0))
+	b := block(nil, 0x0a0d0d0a, shb)
+
+	idb := order.AppendUint16(nil, uint16(linkType))
+	idb = order.AppendUint16(idb, 0)
+	idb = order.AppendUint32(idb, 0)
+	idb = order.AppendUint16(idb, 9) // if_tsresol
+	idb = order.AppendUint16(idb, 1)
+	idb = append(idb, 9, 0, 0, 0)
+	idb = order.AppendUint32(idb, 0) // opt_endofopt
+	b = block(b, 1, idb)
+
+	for i, p := range packets {
+		ts := uint64(times[i].UnixNano())
+		epb := order.AppendUint32(nil, 0)
+		epb = order.AppendUint32(epb, uint32(ts>>32))
+		epb = order.AppendUint32(epb, uint32(ts))
+		epb = order.AppendUint32(epb, uint32(len(p)))
+		epb = order.AppendUint32(epb, uint32(len(p)))
+		b = block(b, 6, append(epb, p...))
+	}
+	return b
+}
+
+func frameBytes(t *testing.T, msgs ...protocmd.CmdMessage) []byte {
+	t.Helper()
+
+	var b []byte
+	for _, msg := range msgs {
+		f, err := protocmd.DefaultFrameCodec.Encode(msg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if b, err = protocmd.DefaultFrameCodec.AppendFrame(b, f); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return b
+}
+
+func decode(t *testing.T, file []byte) []*pcap.Record {
+	t.Helper()
+
+	var records []*pcap.Record
+	d := &pcap.Decoder{ServerPorts: []uint16{7777}}
+	err := d.Decode(bytes.NewReader(file), func(r *pcap.Record) error {
+		records = append(records, r)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return records
+}
+
+func uids(t *testing.T, records []*pcap.Record) []string {
+	t.Helper()
+
+	var uids []string
+	for _, r := range records {
+		if r.Err != nil {
+			t.Fatalf("got record error %v", r.Err)
+		}
+		msg, err := protocmd.DefaultFrameCodec.Decode(r.Frame)
+		if err != nil {
+			t.Fatal(err)
+		}
+		uids = append(uids, msg.(*protos.TestReq).Uid)
+	}
+	return uids
+}
+
+func TestDecodeFormats(t *testing.T) {
+	req := frameBytes(t, &protos.TestReq{Uid: "request"})
+	rsp := frameBytes(t, &protos.TestRsp{RetCode: 7})
+	start := time.Unix(1700000000, 123456000)
+
+	for _, l := range links {
+		for _, server := range []netip.AddrPort{server4, server6} {
+			client := client4
+			if server.Addr().Is6() {
+				client = client6
+			}
+
+			// The request is split across two segments.
+			segs := []segment{
+				{client, server, 100, synFlag, nil},
+				{server, client, 500, synFlag, nil},
+				{client, server, 101, 0, req[:5]},
+				{client, server, 106, 0, req[5:]},
+				{server, client, 501, 0, rsp},
+				{client, server, 101 + uint32(len(req)), finFlag, nil},
+			}
+			var times []time.Time
+			var packets [][]byte
+			for i, seg := range segs {
+				times = append(times, start.Add(time.Duration(i)*time.Millisecond))
+				packets = append(packets, l.wrap(ipPacket(seg)))
+			}
+
+			for _, f := range formats {
+				name := f.name + "/" + l.name + "/" + server.Addr().String()
+				records := decode(t, f.write(l.linkType, times, packets))
+				if len(records) != 2 {
+					t.Fatalf("%s: got %d records, want 2", name, len(records))
+				}
+
+				r := records[0]
+				if r.Direction != pcap.ClientToServer || r.Src != client || r.Dst != server || !r.Time.Equal(times[3]) {
+					t.Errorf("%s: got request record %v %v->%v at %v", name, r.Direction, r.Src, r.Dst, r.Time)
+				}
+				if r.Frame.CmdId != protos.TestReq_CmdId {
+					t.Errorf("%s: got cmdId %v, want TestReq", name, r.Frame.CmdId)
+				}
+				r = records[1]
+				if r.Direction != pcap.ServerToClient || r.Src != server || r.Dst != client || r.Frame.CmdId != protos.TestRsp_CmdId {
+					t.Errorf("%s: got response record %v %v->%v of cmdId %v", name, r.Direction, r.Src, r.Dst, r.Frame.CmdId)
+				}
+			}
+		}
+	}
+}
+
+func TestDecodeReassembly(t *testing.T) {
+	a := frameBytes(t, &protos.TestReq{Uid: "a"})
+	b := frameBytes(t, &protos.TestReq{Uid: "b"})
+	c := frameBytes(t, &protos.TestReq{Uid: "c"})
+	stream := append(append(append([]byte(nil), a...), b...), c...)
+	at := func(from, to int) segment {
+		return segment{client4, server4, 1001 + uint32(from), 0, stream[from:to]}
+	}
+	syn := segment{client4, server4, 1000, synFlag, nil}
+	la, lab := len(a), len(a)+len(b)
+
+	tests := []struct {
+		name string
+		segs []segment
+	}{
+		{"in order", []segment{syn, at(0, la), at(la, lab), at(lab, len(stream))}},
+		{"out of order", []segment{syn, at(lab, len(stream)), at(la, lab), at(0, la)}},
+		{"retransmitted", []segment{syn, at(0, la), at(0, la), at(la, lab), at(0, lab), at(lab, len(stream)), at(lab, len(stream))}},
+		{"overlapping", []segment{syn, at(0, la+2), at(la, lab+3), at(lab-1, len(stream))}},
+		{"overlapping out of order", []segment{syn, at(lab-1, len(stream)), at(la-3, lab), at(0, la)}},
+		{"split frames", []segment{syn, at(0, 3), at(3, la+1), at(la+1, len(stream)-1), at(len(stream)-1, len(stream))}},
+	}
+	for _, tt := range tests {
+		var times []time.Time
+		var packets [][]byte
+		for _, seg := range tt.segs {
+			times = append(times, time.Unix(1700000000, 0))
+			packets = append(packets, links[0].wrap(ipPacket(seg)))
+		}
+
+		got := uids(t, decode(t, pcapFile(binary.LittleEndian, false, 1, times, packets)))
+		if len(got) != 3 || got[0] != "a" || got[1] != "b" || got[2] != "c" {
+			t.Errorf("%s: got frames %v, want [a b c]", tt.name, got)
+		}
+	}
+}
+
+func TestDecodeMidStream(t *testing.T) {
+	// The capture starts in the middle of a frame, so the stream can't be split.
+	req := frameBytes(t, &protos.TestReq{Uid: "late"})
+	garbage := append([]byte{0xff, 0xff, 0xff, 0xff, 0, 0, 0}, req...)
+	packets := [][]byte{links[0].wrap(ipPacket(segment{client4, server4, 5000, 0, garbage}))}
+
+	records := decode(t, pcapFile(binary.LittleEndian, false, 1, []time.Time{time.Unix(0, 0)}, packets))
+	if len(records) != 1 || records[0].Err == nil || records[0].Frame != nil {
+		t.Fatalf("got %d records, want a single error", len(records))
+	}
+}
+
+func TestDecodeInvalidFiles(t *testing.T) {
+	req := frameBytes(t, &protos.TestReq{Uid: "x"})
+	packets := [][]byte{links[0].wrap(ipPacket(segment{client4, server4, 1, 0, req}))}
+	times := []time.Time{time.Unix(0, 0)}
+
+	d := &pcap.Decoder{ServerPorts: []uint16{7777}}
+	nop := func(*pcap.Record) error { return nil }
+
+	if err := d.Decode(bytes.NewReader([]byte("this is not a capture file at all")), nop); err != pcap.ErrUnknownFormat {
+		t.Errorf("got %v, want ErrUnknownFormat", err)
+	}
+	if err := d.Decode(bytes.NewReader(nil), nop); err != pcap.ErrUnknownFormat {
+		t.Errorf("got %v for an empty file, want ErrUnknownFormat", err)
+	}
+
+	// Section header blocks too short for their own fields.
+	for _, length := range []uint32{12, 24} {
+		shb := binary.LittleEndian.AppendUint32(nil, 0x0a0d0d0a)
+		shb = binary.LittleEndian.AppendUint32(shb, length)
+		shb = binary.LittleEndian.AppendUint32(shb, 0x1a2b3c4d)
+		shb = append(shb, make([]byte, 16)...)
+		if err := d.Decode(bytes.NewReader(shb), nop); err == nil || !strings.Contains(err.Error(), "invalid block length") {
+			t.Errorf("got %v for a section header block of length %d, want an invalid block length", err, length)
+		}
+	}
+
+	for _, f := range formats {
+		file := f.write(1, times, packets)
+		if err := d.Decode(bytes.NewReader(file[:len(file)-3]), nop); !errors.Is(err, io.ErrUnexpectedEOF) {
+			t.Errorf("%s: got %v for a truncated file, want io.ErrUnexpectedEOF", f.name, err)
+		}
+	}
+
+	// Errors of fn stop decoding.
+	stop := errors.New("stop")
+	if err := d.Decode(bytes.NewReader(formats[0].write(1, times, packets)), func(*pcap.Record) error { return stop }); err != stop {
+		t.Errorf("got %v, want the error of fn", err)
+	}
+}
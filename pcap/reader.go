@@ -0,0 +1,319 @@
+package pcap
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+// Link types, see https://www.tcpdump.org/linktypes.html.
+const (
+	linkTypeNull      = 0
+	linkTypeEthernet  = 1
+	linkTypeRaw       = 101
+	linkTypeLinuxSLL  = 113
+	linkTypeIPv4      = 228
+	linkTypeIPv6      = 229
+	linkTypeLinuxSLL2 = 276
+)
+
+var ErrUnknownFormat = errors.New("pcap: unknown file format")
+
+// packet is a packet captured on a link.
+type packet struct {
+	time     time.Time
+	linkType uint32
+	data     []byte
+}
+
+type packetReader interface {
+	readPacket() (*packet, error)
+}
+
+// newPacketReader detects the format of r, which is either pcap or pcapng.
+func newPacketReader(r io.Reader) (packetReader, error) {
+	br := bufio.NewReader(r)
+	magic, err := br.Peek(4)
+	if err != nil {
+		if err == io.EOF {
+			err = ErrUnknownFormat
+		}
+		return nil, err
+	}
+
+	if binary.BigEndian.Uint32(magic) == pcapngBlockSHB {
+		return &pcapngReader{r: br}, nil
+	}
+	return newPcapReader(br)
+}
+
+const (
+	pcapMagicMicros = 0xa1b2c3d4
+	pcapMagicNanos  = 0xa1b23c4d
+)
+
+// pcapReader reads the classic pcap format.
+type pcapReader struct {
+	r        io.Reader
+	order    binary.ByteOrder
+	nanos    bool
+	linkType uint32
+	header   [16]byte
+}
+
+func newPcapReader(r io.Reader) (*pcapReader, error) {
+	var header [24]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return nil, err
+	}
+
+	pr := &pcapReader{r: r}
+	switch {
+	case binary.LittleEndian.Uint32(header[:]) == pcapMagicMicros:
+		pr.order = binary.LittleEndian
+	case binary.LittleEndian.Uint32(header[:]) == pcapMagicNanos:
+		pr.order, pr.nanos = binary.LittleEndian, true
+	case binary.BigEndian.Uint32(header[:]) == pcapMagicMicros:
+		pr.order = binary.BigEndian
+	case binary.BigEndian.Uint32(header[:]) == pcapMagicNanos:
+		pr.order, pr.nanos = binary.BigEndian, true
+	default:
+		return nil, ErrUnknownFormat
+	}
+
+	// The upper bits of the link type field hold the FCS length.
+	pr.linkType = pr.order.Uint32(header[20:]) & 0x0fffffff
+	return pr, nil
+}
+
+func (pr *pcapReader) readPacket() (*packet, error) {
+	if _, err := io.ReadFull(pr.r, pr.header[:]); err != nil {
+		return nil, err
+	}
+
+	sec := int64(pr.order.Uint32(pr.header[0:]))
+	frac := int64(pr.order.Uint32(pr.header[4:]))
+	capLen := pr.order.Uint32(pr.header[8:])
+	if capLen > maxPacketSize {
+		return nil, fmt.Errorf("pcap: packet too large (%d bytes)", capLen)
+	}
+
+	p := &packet{linkType: pr.linkType, data: make([]byte, capLen)}
+	if pr.nanos {
+		p.time = time.Unix(sec, frac)
+	} else {
+		p.time = time.Unix(sec, frac*1000)
+	}
+
+	if _, err := io.ReadFull(pr.r, p.data); err != nil {
+		return nil, truncated(err)
+	}
+	return p, nil
+}
+
+const maxPacketSize = 1 << 24
+
+// pcapng block types, see https://www.ietf.org/archive/id/draft-ietf-opsawg-pcapng-02.html.
+const (
+	pcapngBlockSHB = 0x0a0d0d0a // Section Header Block
+	pcapngBlockIDB = 0x00000001 // Interface Description Block
+	pcapngBlockPB  = 0x00000002 // Packet Block (obsolete)
+	pcapngBlockSPB = 0x00000003 // Simple Packet Block
+	pcapngBlockEPB = 0x00000006 // Enhanced Packet Block
+
+	pcapngByteOrderMagic = 0x1a2b3c4d
+	pcapngOptEnd         = 0
+	pcapngOptTsResol     = 9
+)
+
+type pcapngInterface struct {
+	linkType uint32
+	tsUnit   time.Duration // duration of one timestamp unit
+	tsPerSec uint64        // timestamp units per second, used if tsUnit is less than a nanosecond
+}
+
+// pcapngReader reads the pcapng format.
+type pcapngReader struct {
+	r          io.Reader
+	order      binary.ByteOrder
+	interfaces []*pcapngInterface
+}
+
+func (pr *pcapngReader) readPacket() (*packet, error) {
+	for {
+		blockType, body, err := pr.readBlock()
+		if err != nil {
+			return nil, err
+		}
+
+		switch blockType {
+		case pcapngBlockIDB:
+			if err := pr.readInterface(body); err != nil {
+				return nil, err
+			}
+		case pcapngBlockEPB, pcapngBlockPB:
+			return pr.readEnhancedPacket(blockType, body)
+		case pcapngBlockSPB:
+			return pr.readSimplePacket(body)
+		}
+	}
+}
+
+func (pr *pcapngReader) readBlock() (uint32, []byte, error) {
+	var header [8]byte
+	if _, err := io.ReadFull(pr.r, header[:]); err != nil {
+		return 0, nil, err
+	}
+
+	blockType := binary.BigEndian.Uint32(header[:])
+	if blockType == pcapngBlockSHB {
+		// A new section may change the byte order, which is detected by the magic
+		// right after the block length.
+		var magic [4]byte
+		if _, err := io.ReadFull(pr.r, magic[:]); err != nil {
+			return 0, nil, truncated(err)
+		}
+		switch {
+		case binary.BigEndian.Uint32(magic[:]) == pcapngByteOrderMagic:
+			pr.order = binary.BigEndian
+		case binary.LittleEndian.Uint32(magic[:]) == pcapngByteOrderMagic:
+			pr.order = binary.LittleEndian
+		default:
+			return 0, nil, ErrUnknownFormat
+		}
+		pr.interfaces = pr.interfaces[:0]
+	} else if pr.order == nil {
+		return 0, nil, ErrUnknownFormat
+	} else {
+		blockType = pr.order.Uint32(header[:])
+	}
+
+	// The body excludes the block type and the two block length fields. A
+	// section header block has at least the magic, the version and the
+	// section length.
+	read, minLength := uint32(8), uint32(12)
+	if blockType == pcapngBlockSHB {
+		read, minLength = 12, 28
+	}
+	length := pr.order.Uint32(header[4:])
+	if length < minLength || length%4 != 0 || length > maxPacketSize {
+		return 0, nil, fmt.Errorf("pcap: invalid block length %d", length)
+	}
+	rest := make([]byte, length-read)
+	if _, err := io.ReadFull(pr.r, rest); err != nil {
+		return 0, nil, truncated(err)
+	}
+	return blockType, rest[:len(rest)-4], nil
+}
+
+func (pr *pcapngReader) readInterface(body []byte) error {
+	if len(body) < 8 {
+		return fmt.Errorf("pcap: invalid interface description block")
+	}
+
+	iface := &pcapngInterface{
+		linkType: uint32(pr.order.Uint16(body[0:])),
+		tsUnit:   time.Microsecond,
+	}
+
+	// Look for if_tsresol in the options.
+	opts := body[8:]
+	for len(opts) >= 4 {
+		code := pr.order.Uint16(opts[0:])
+		length := int(pr.order.Uint16(opts[2:]))
+		if code == pcapngOptEnd || len(opts) < 4+length {
+			break
+		}
+
+		if code == pcapngOptTsResol && length >= 1 {
+			iface.setTsResol(opts[4])
+		}
+		opts = opts[4+(length+3)/4*4:]
+	}
+
+	pr.interfaces = append(pr.interfaces, iface)
+	return nil
+}
+
+func (iface *pcapngInterface) setTsResol(v byte) {
+	var perSec uint64 = 1
+	for i := byte(0); i < v&0x7f; i++ {
+		if v&0x80 != 0 {
+			perSec *= 2
+		} else {
+			perSec *= 10
+		}
+		if perSec > 1e18 {
+			break
+		}
+	}
+
+	if perSec <= uint64(time.Second) && uint64(time.Second)%perSec == 0 {
+		iface.tsUnit = time.Second / time.Duration(perSec)
+	} else {
+		iface.tsUnit = 0
+		iface.tsPerSec = perSec
+	}
+}
+
+func (iface *pcapngInterface) time(ts uint64) time.Time {
+	if iface.tsUnit > 0 {
+		return time.Unix(0, 0).Add(time.Duration(ts) * iface.tsUnit)
+	}
+	sec := ts / iface.tsPerSec
+	nsec := (ts % iface.tsPerSec) * uint64(time.Second) / iface.tsPerSec
+	return time.Unix(int64(sec), int64(nsec))
+}
+
+func (pr *pcapngReader) readEnhancedPacket(blockType uint32, body []byte) (*packet, error) {
+	if len(body) < 20 {
+		return nil, fmt.Errorf("pcap: invalid packet block")
+	}
+
+	var ifaceId uint32
+	if blockType == pcapngBlockPB {
+		ifaceId = uint32(pr.order.Uint16(body[0:]))
+	} else {
+		ifaceId = pr.order.Uint32(body[0:])
+	}
+	if int(ifaceId) >= len(pr.interfaces) {
+		return nil, fmt.Errorf("pcap: unknown interface %d", ifaceId)
+	}
+	iface := pr.interfaces[ifaceId]
+
+	ts := uint64(pr.order.Uint32(body[4:]))<<32 | uint64(pr.order.Uint32(body[8:]))
+	capLen := pr.order.Uint32(body[12:])
+	if uint64(capLen) > uint64(len(body)-20) {
+		return nil, fmt.Errorf("pcap: invalid captured length %d", capLen)
+	}
+
+	return &packet{
+		time:     iface.time(ts),
+		linkType: iface.linkType,
+		data:     body[20 : 20+capLen],
+	}, nil
+}
+
+func (pr *pcapngReader) readSimplePacket(body []byte) (*packet, error) {
+	if len(pr.interfaces) <= 0 || len(body) < 4 {
+		return nil, fmt.Errorf("pcap: invalid simple packet block")
+	}
+
+	// Simple packet blocks have no timestamp and always belong to the first interface.
+	origLen := pr.order.Uint32(body[0:])
+	data := body[4:]
+	if uint64(origLen) < uint64(len(data)) {
+		data = data[:origLen]
+	}
+	return &packet{linkType: pr.interfaces[0].linkType, data: data}, nil
+}
+
+func truncated(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
@@ -0,0 +1,214 @@
+package protocmd
+
+import (
+	"fmt"
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"math"
+	"strings"
+	"sync"
+)
+
+// PeekField returns the value of a field in the payload of a message
+// without unmarshaling the rest of it. path is a dot-separated list of
+// field names, like "position.x", where all fields but the last must be
+// singular messages, and the last must be a singular scalar, string,
+// bytes or enum. The default value is returned if the field isn't set.
+func PeekField(cmdId uint16, payload []byte, path string) (protoreflect.Value, error) {
+	fields, err := fieldPath(cmdId, path)
+	if err != nil {
+		return protoreflect.Value{}, err
+	}
+
+	v, state, err := peekField(payload, fields)
+	if err != nil {
+		return protoreflect.Value{}, fmt.Errorf("failed to peek cmdId '%v': %w", cmdId, err)
+	}
+	if state != peekFound {
+		return fields[len(fields)-1].Default(), nil
+	}
+	return v, nil
+}
+
+// PeekField returns the value of a field in the payload. See PeekField.
+// It fails unless the payload is in ProtoPayloadCodec.
+func (m *LazyMessage) PeekField(path string) (protoreflect.Value, error) {
+	if m.payloadCodec != ProtoPayloadCodecId {
+		return protoreflect.Value{}, fmt.Errorf("protocmd: can't peek a payload of codec %v", m.payloadCodec)
+	}
+	return PeekField(m.cmdId, m.payload, path)
+}
+
+type fieldPathKey struct {
+	cmdId uint16
+	path  string
+}
+
+// fieldPaths caches the resolved field paths of PeekField.
+var fieldPaths sync.Map // fieldPathKey -> []protoreflect.FieldDescriptor
+
+func fieldPath(cmdId uint16, path string) ([]protoreflect.FieldDescriptor, error) {
+	key := fieldPathKey{cmdId, path}
+	if fields, ok := fieldPaths.Load(key); ok {
+		return fields.([]protoreflect.FieldDescriptor), nil
+	}
+
+	desc, err := MessageDescriptorByCmdId(cmdId)
+	if err != nil {
+		return nil, err
+	}
+	fields, err := resolveFieldPath(desc, path)
+	if err != nil {
+		return nil, err
+	}
+	fieldPaths.Store(key, fields)
+	return fields, nil
+}
+
+func resolveFieldPath(desc protoreflect.MessageDescriptor, path string) ([]protoreflect.FieldDescriptor, error) {
+	names := strings.Split(path, ".")
+	fields := make([]protoreflect.FieldDescriptor, len(names))
+	for i, name := range names {
+		fd := desc.Fields().ByName(protoreflect.Name(name))
+		if fd == nil {
+			return nil, fmt.Errorf("protocmd: message '%s' has no field '%s'", desc.FullName(), name)
+		}
+		if fd.IsList() || fd.IsMap() {
+			return nil, fmt.Errorf("protocmd: field '%s' of path '%s' isn't singular", fd.FullName(), path)
+		}
+
+		isMessage := fd.Kind() == protoreflect.MessageKind || fd.Kind() == protoreflect.GroupKind
+		if last := i == len(names)-1; last && isMessage {
+			return nil, fmt.Errorf("protocmd: field '%s' of path '%s' is a message", fd.FullName(), path)
+		} else if !last && !isMessage {
+			return nil, fmt.Errorf("protocmd: field '%s' of path '%s' isn't a message", fd.FullName(), path)
+		}
+		fields[i] = fd
+		desc = fd.Message()
+	}
+	return fields, nil
+}
+
+type peekState int
+
+const (
+	peekAbsent  peekState = iota
+	peekFound             // the field is set
+	peekCleared           // another field of its oneof is set after it
+)
+
+// peekField scans b for the value of fields[0], descending into the rest.
+// Like Unmarshal, the last value wins, nested messages are merged, and
+// a value of the wrong wire type is an unknown field.
+func peekField(b []byte, fields []protoreflect.FieldDescriptor) (protoreflect.Value, peekState, error) {
+	fd := fields[0]
+	oneof := fd.ContainingOneof()
+
+	var v protoreflect.Value
+	state := peekAbsent
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return v, state, protowire.ParseError(n)
+		}
+		b = b[n:]
+
+		n = protowire.ConsumeFieldValue(num, typ, b)
+		if n < 0 {
+			return v, state, protowire.ParseError(n)
+		}
+		value := b[:n]
+		b = b[n:]
+
+		if num != fd.Number() {
+			if oneof != nil && oneof.Fields().ByNumber(num) != nil {
+				state = peekCleared
+			}
+			continue
+		}
+
+		if len(fields) > 1 {
+			var content []byte
+			switch {
+			case fd.Kind() == protoreflect.MessageKind && typ == protowire.BytesType:
+				content, _ = protowire.ConsumeBytes(value)
+			case fd.Kind() == protoreflect.GroupKind && typ == protowire.StartGroupType:
+				content, _ = protowire.ConsumeGroup(num, value)
+			default:
+				continue
+			}
+			if state == peekCleared {
+				state = peekAbsent // set again, and empty until its fields are found
+			}
+			nested, nestedState, err := peekField(content, fields[1:])
+			if err != nil {
+				return v, state, err
+			}
+			if nestedState != peekAbsent {
+				v, state = nested, nestedState
+			}
+			continue
+		}
+
+		if scalar, ok := peekScalar(fd.Kind(), typ, value); ok {
+			v, state = scalar, peekFound
+		}
+	}
+	return v, state, nil
+}
+
+// peekScalar decodes a value of kind. It returns false if typ isn't the
+// wire type of kind.
+func peekScalar(kind protoreflect.Kind, typ protowire.Type, b []byte) (protoreflect.Value, bool) {
+	switch typ {
+	case protowire.VarintType:
+		x, _ := protowire.ConsumeVarint(b)
+		switch kind {
+		case protoreflect.BoolKind:
+			return protoreflect.ValueOfBool(protowire.DecodeBool(x)), true
+		case protoreflect.EnumKind:
+			return protoreflect.ValueOfEnum(protoreflect.EnumNumber(int32(x))), true
+		case protoreflect.Int32Kind:
+			return protoreflect.ValueOfInt32(int32(x)), true
+		case protoreflect.Sint32Kind:
+			return protoreflect.ValueOfInt32(int32(protowire.DecodeZigZag(x & math.MaxUint32))), true
+		case protoreflect.Uint32Kind:
+			return protoreflect.ValueOfUint32(uint32(x)), true
+		case protoreflect.Int64Kind:
+			return protoreflect.ValueOfInt64(int64(x)), true
+		case protoreflect.Sint64Kind:
+			return protoreflect.ValueOfInt64(protowire.DecodeZigZag(x)), true
+		case protoreflect.Uint64Kind:
+			return protoreflect.ValueOfUint64(x), true
+		}
+	case protowire.Fixed32Type:
+		x, _ := protowire.ConsumeFixed32(b)
+		switch kind {
+		case protoreflect.Sfixed32Kind:
+			return protoreflect.ValueOfInt32(int32(x)), true
+		case protoreflect.Fixed32Kind:
+			return protoreflect.ValueOfUint32(x), true
+		case protoreflect.FloatKind:
+			return protoreflect.ValueOfFloat32(math.Float32frombits(x)), true
+		}
+	case protowire.Fixed64Type:
+		x, _ := protowire.ConsumeFixed64(b)
+		switch kind {
+		case protoreflect.Sfixed64Kind:
+			return protoreflect.ValueOfInt64(int64(x)), true
+		case protoreflect.Fixed64Kind:
+			return protoreflect.ValueOfUint64(x), true
+		case protoreflect.DoubleKind:
+			return protoreflect.ValueOfFloat64(math.Float64frombits(x)), true
+		}
+	case protowire.BytesType:
+		x, _ := protowire.ConsumeBytes(b)
+		switch kind {
+		case protoreflect.StringKind:
+			return protoreflect.ValueOfString(string(x)), true
+		case protoreflect.BytesKind:
+			return protoreflect.ValueOfBytes(append([]byte(nil), x...)), true
+		}
+	}
+	return protoreflect.Value{}, false
+}
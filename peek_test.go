@@ -0,0 +1,122 @@
+package protocmd_test
+
+import (
+	"github.com/stalomeow/protocmd"
+	"github.com/stalomeow/protocmd/examples/go/protos"
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+	"testing"
+)
+
+func TestPeekField(t *testing.T) {
+	payload, _ := proto.Marshal(&protos.TestReq{Uid: "10086"})
+	v, err := protocmd.PeekField(protos.TestReq_CmdId, payload, "uid")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v.String() != "10086" {
+		t.Fatalf("got uid %v, want 10086", v)
+	}
+
+	v, err = protocmd.PeekField(protos.TestReq_CmdId, nil, "uid")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v.String() != "" {
+		t.Fatalf("got uid %v of an empty payload", v)
+	}
+
+	// The last value wins, and nested messages are merged.
+	var b []byte
+	b = appendVector(b, 1, 1, 0)
+	b = appendVector(b, 1, 0, 2)
+	b = appendVector(b, 3, 5, 0)
+	b = protowire.AppendTag(b, 1, protowire.Fixed32Type) // wrong wire type
+	b = protowire.AppendFixed32(b, 0)
+
+	const cmdId = protos.TestRsp_TransformInfo_CmdId
+	want := &protos.TestRsp_TransformInfo{}
+	if err := proto.Unmarshal(b, want); err != nil {
+		t.Fatal(err)
+	}
+	for path, wantValue := range map[string]float32{
+		"position.x":    want.Position.X,
+		"position.y":    want.Position.Y,
+		"position.z":    want.Position.Z,
+		"scale.x":       want.Scale.X,
+		"scale.y":       want.Scale.Y,
+		"eulerAngles.x": 0,
+	} {
+		v, err := protocmd.PeekField(cmdId, b, path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if float32(v.Float()) != wantValue {
+			t.Errorf("got %v of %v, want %v", v, path, wantValue)
+		}
+	}
+
+	lazy := protocmd.NewLazyMessage(cmdId, b, nil)
+	if v, err := lazy.PeekField("position.y"); err != nil || v.Float() != 2 {
+		t.Fatalf("got %v, %v", v, err)
+	}
+	if lazy.Decoded() {
+		t.Fatal("PeekField decoded the message")
+	}
+}
+
+func appendVector(b []byte, num protowire.Number, x, y float32) []byte {
+	v, _ := proto.Marshal(&protos.Vector3{X: x, Y: y})
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendBytes(b, v)
+}
+
+func TestPeekFieldErrors(t *testing.T) {
+	for _, test := range []struct {
+		cmdId uint16
+		path  string
+	}{
+		{12345, "uid"},
+		{protos.TestReq_CmdId, "name"},
+		{protos.TestReq_CmdId, "uid.x"},
+		{protos.TestRsp_CmdId, "transforms"},
+		{protos.TestRsp_CmdId, "transforms.position.x"},
+		{protos.TestRsp_TransformInfo_CmdId, "position"},
+		{protos.TestRsp_TransformInfo_CmdId, "position.w"},
+	} {
+		if _, err := protocmd.PeekField(test.cmdId, nil, test.path); err == nil {
+			t.Errorf("PeekField(%v, %q) succeeded", test.cmdId, test.path)
+		}
+	}
+
+	if _, err := protocmd.PeekField(protos.TestReq_CmdId, []byte{0x0a, 0x05}, "uid"); err == nil {
+		t.Error("PeekField succeeded on a truncated payload")
+	}
+}
+
+func BenchmarkPeekField(b *testing.B) {
+	payload, _ := proto.Marshal(&protos.TestRsp_TransformInfo{
+		Position:    &protos.Vector3{X: 1, Y: 2, Z: 3},
+		EulerAngles: &protos.Vector3{X: 4, Y: 5, Z: 6},
+		Scale:       &protos.Vector3{X: 7, Y: 8, Z: 9},
+	})
+
+	b.Run("Unmarshal", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			var msg protos.TestRsp_TransformInfo
+			if err := proto.Unmarshal(payload, &msg); err != nil {
+				b.Fatal(err)
+			}
+			_ = msg.Scale.GetZ()
+		}
+	})
+	b.Run("Peek", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if _, err := protocmd.PeekField(protos.TestRsp_TransformInfo_CmdId, payload, "scale.z"); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
@@ -0,0 +1,65 @@
+package protocmd
+
+import (
+	"fmt"
+	"google.golang.org/protobuf/proto"
+	"sync"
+)
+
+// EnablePool makes Acquire reuse the messages of cmdIds passed to Release,
+// instead of creating new ones. It's usually called during initialization
+// for the cmdIds received at high rates.
+func EnablePool(cmdIds ...uint16) error {
+	for _, cmdId := range cmdIds {
+		info, ok := cmdInfoMap[cmdId]
+		if !ok {
+			return fmt.Errorf("failed to enable the pool of cmdId '%v' which was not registered", cmdId)
+		}
+		info.pool.CompareAndSwap(nil, &sync.Pool{New: func() any { return info.factory() }})
+	}
+	return nil
+}
+
+// DisablePool drops the pools of cmdIds.
+func DisablePool(cmdIds ...uint16) {
+	for _, cmdId := range cmdIds {
+		if info, ok := cmdInfoMap[cmdId]; ok {
+			info.pool.Store(nil)
+		}
+	}
+}
+
+// Acquire returns an empty message of cmdId. It's taken from the pool of
+// cmdId if EnablePool was called, and created by NewMessageByCmdId otherwise.
+// FrameCodec creates messages with Acquire.
+func Acquire(cmdId uint16) (CmdMessage, error) {
+	info, ok := cmdInfoMap[cmdId]
+	if !ok {
+		return nil, fmt.Errorf("failed to acquire Message with cmdId '%v' which was not registered", cmdId)
+	}
+	if pool := info.pool.Load(); pool != nil {
+		return pool.Get().(CmdMessage), nil
+	}
+	return info.factory(), nil
+}
+
+// Release resets msg with proto.Reset and puts it back to the pool of its
+// cmdId. It does nothing if the pool isn't enabled. msg must not be used
+// after it's released. Releasing a LazyMessage releases its decoded message.
+func Release(msg CmdMessage) {
+	if lazy, ok := msg.(*LazyMessage); ok {
+		if !lazy.Decoded() || lazy.msg == nil {
+			return
+		}
+		msg = lazy.msg
+	}
+
+	info, ok := cmdInfoMap[msg.CmdId()]
+	if !ok {
+		return
+	}
+	if pool := info.pool.Load(); pool != nil {
+		proto.Reset(msg)
+		pool.Put(msg)
+	}
+}
@@ -0,0 +1,127 @@
+package protocmd_test
+
+import (
+	"github.com/stalomeow/protocmd"
+	"github.com/stalomeow/protocmd/examples/go/protos"
+	"google.golang.org/protobuf/proto"
+	"testing"
+)
+
+func TestPool(t *testing.T) {
+	const cmdId = protos.TestRsp_TransformInfo_CmdId
+	if err := protocmd.EnablePool(cmdId); err != nil {
+		t.Fatal(err)
+	}
+	defer protocmd.DisablePool(cmdId)
+
+	if err := protocmd.EnablePool(12345); err == nil {
+		t.Fatal("EnablePool accepted an unregistered cmdId")
+	}
+
+	for i := 0; i < 10; i++ {
+		msg, err := protocmd.Acquire(cmdId)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !proto.Equal(msg, &protos.TestRsp_TransformInfo{}) {
+			t.Fatalf("Acquire returned a used message %v", protocmd.CmdString(msg))
+		}
+		msg.(*protos.TestRsp_TransformInfo).Position = &protos.Vector3{X: 1}
+		protocmd.Release(msg)
+	}
+}
+
+func TestDispatcherReleaseMessages(t *testing.T) {
+	const cmdId = protos.TestRsp_TransformInfo_CmdId
+	protocmd.EnablePool(cmdId)
+	defer protocmd.DisablePool(cmdId)
+
+	d := protocmd.NewDispatcher()
+	d.ReleaseMessages = true
+
+	var handled bool
+	d.HandleFunc(cmdId, func(s *protocmd.Session, msg protocmd.CmdMessage) {
+		handled = msg.(*protos.TestRsp_TransformInfo).Position.GetX() == 1
+	})
+
+	msg := &protos.TestRsp_TransformInfo{Position: &protos.Vector3{X: 1}}
+	d.ServeCmd(nil, msg)
+	if !handled {
+		t.Fatal("the handler didn't see the message")
+	}
+	if msg.Position != nil {
+		t.Fatal("the message wasn't released")
+	}
+}
+
+func TestDecodeReleasesOnError(t *testing.T) {
+	const cmdId = protos.TestRsp_TransformInfo_CmdId
+	protocmd.EnablePool(cmdId)
+	defer protocmd.DisablePool(cmdId)
+
+	large, err := protocmd.DefaultFrameCodec.Encode(&protos.TestRsp_TransformInfo{Position: &protos.Vector3{X: 1}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	codecs := []struct {
+		name  string
+		codec *protocmd.FrameCodec
+		f     *protocmd.Frame
+	}{
+		{"limits", &protocmd.FrameCodec{Limits: &protocmd.DecodeLimits{MaxAlloc: 1}}, large},
+		{"unmarshal", protocmd.DefaultFrameCodec, &protocmd.Frame{CmdId: cmdId, Payload: []byte{0xff}}},
+	}
+
+	// A released message is usually taken again by the next Acquire. The pool
+	// may drop it anyway, so it only has to happen once.
+	for _, c := range codecs {
+		reused := false
+		for i := 0; i < 20 && !reused; i++ {
+			msg, _ := protocmd.Acquire(cmdId)
+			protocmd.Release(msg)
+			if _, err := c.codec.Decode(c.f); err == nil {
+				t.Fatalf("%s: Decode succeeded", c.name)
+			}
+			again, _ := protocmd.Acquire(cmdId)
+			reused = again == msg
+		}
+		if !reused {
+			t.Errorf("%s: the message wasn't released after the error", c.name)
+		}
+	}
+}
+
+func BenchmarkDecode(b *testing.B) {
+	const cmdId = protos.TestRsp_TransformInfo_CmdId
+	f, err := protocmd.DefaultFrameCodec.Encode(&protos.TestRsp_TransformInfo{
+		Position:    &protos.Vector3{X: 1, Y: 2, Z: 3},
+		EulerAngles: &protos.Vector3{X: 4, Y: 5, Z: 6},
+		Scale:       &protos.Vector3{X: 1, Y: 1, Z: 1},
+	})
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	b.Run("New", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if _, err := protocmd.DefaultFrameCodec.Decode(f); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+
+	b.Run("Pool", func(b *testing.B) {
+		protocmd.EnablePool(cmdId)
+		defer protocmd.DisablePool(cmdId)
+
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			msg, err := protocmd.DefaultFrameCodec.Decode(f)
+			if err != nil {
+				b.Fatal(err)
+			}
+			protocmd.Release(msg)
+		}
+	})
+}
@@ -4,35 +4,29 @@ import (
 	"bytes"
 	"flag"
 	"fmt"
+	"github.com/stalomeow/protocmd/cmdyaml"
 	"google.golang.org/protobuf/proto"
 	"google.golang.org/protobuf/reflect/protodesc"
 	"google.golang.org/protobuf/reflect/protoreflect"
 	"google.golang.org/protobuf/reflect/protoregistry"
 	"google.golang.org/protobuf/types/pluginpb"
-	"gopkg.in/yaml.v3"
-	"os"
 	"reflect"
 	"strings"
 )
 
 const genVersion = "1.0.0"
 
-type cmdYamlConfig struct {
-	CmdIdMap map[string]uint16
-}
-
 type generateContext struct {
 	req     *pluginpb.CodeGeneratorRequest
 	rsp     *pluginpb.CodeGeneratorResponse
 	rawArgs map[string]string
-	config  *cmdYamlConfig
+	config  *cmdyaml.Config
 }
 
 func (context *generateContext) init(req *pluginpb.CodeGeneratorRequest) error {
 	context.req = req
 	context.rsp = new(pluginpb.CodeGeneratorResponse)
 	context.rawArgs = parseArgs(req.GetParameter())
-	context.config = new(cmdYamlConfig)
 	return loadYamlConfig(context)
 }
 
@@ -58,25 +52,22 @@ func loadYamlConfig(context *generateContext) error {
 		yamlName = v
 	}
 
-	buf, err := os.ReadFile(yamlName)
-	if err != nil {
-		return err
-	}
-	context.config.CmdIdMap = make(map[string]uint16)
-	err = yaml.Unmarshal(buf, &context.config.CmdIdMap)
+	config, err := cmdyaml.Load(yamlName)
 	if err != nil {
 		return err
 	}
+	context.config = config
+	return nil
+}
 
-	// Find duplicated cmdId
-	ids := make(map[uint16]interface{})
-	for _, v := range context.config.CmdIdMap {
-		if _, ok := ids[v]; ok {
-			return fmt.Errorf("duplicated cmdId %v", v)
-		}
-		ids[v] = nil
+// compilerVersion returns the version of protoc, or an empty string if it is unknown,
+// e.g. in standalone mode.
+func (context *generateContext) compilerVersion() string {
+	v := context.req.GetCompilerVersion()
+	if v == nil {
+		return ""
 	}
-	return nil
+	return fmt.Sprintf("%v.%v.%v", v.GetMajor(), v.GetMinor(), v.GetPatch())
 }
 
 func (context *generateContext) popArg(key string) (string, bool) {
@@ -104,10 +95,7 @@ func (context *generateContext) filterFilesToGenerate() ([]protoreflect.FileDesc
 	fileReg := new(protoregistry.Files)
 	results := make([]protoreflect.FileDescriptor, 0)
 	for _, f := range context.req.ProtoFile {
-		if _, ok := genFileMap[f.GetName()]; !ok {
-			continue
-		}
-
+		// Dependencies must be registered too, even if they are not going to be generated.
 		desc, err := protodesc.NewFile(f, fileReg)
 		if err != nil {
 			return nil, fmt.Errorf("invalid FileDescriptorProto %q: %v", f.GetName(), err)
@@ -116,7 +104,9 @@ func (context *generateContext) filterFilesToGenerate() ([]protoreflect.FileDesc
 			return nil, fmt.Errorf("cannot register descriptor %q: %v", f.GetName(), err)
 		}
 
-		results = append(results, desc)
+		if _, ok := genFileMap[f.GetName()]; ok {
+			results = append(results, desc)
+		}
 	}
 	return results, nil
 }
@@ -175,6 +165,13 @@ func (g *genFile) indent(count int) {
 	}
 }
 
+// cmdName returns the name which CmdName of the message returns, e.g.
+// "TestRsp_TransformInfo" for a nested message.
+func cmdName(msg protoreflect.MessageDescriptor) string {
+	name := strings.TrimPrefix(string(msg.FullName()), string(msg.ParentFile().Package())+".")
+	return strings.ReplaceAll(name, ".", "_")
+}
+
 func underscoresToCamelCase(input string, capNextLetter, preservePeriod bool) string {
 	var result strings.Builder
 
@@ -0,0 +1,109 @@
+package main
+
+import (
+	_ "embed"
+	"fmt"
+	htmltemplate "html/template"
+	"io"
+	"strings"
+	"text/template"
+)
+
+//go:embed templates/doc.md.tmpl
+var docMarkdownTemplate string
+
+//go:embed templates/doc.html.tmpl
+var docHtmlTemplate string
+
+type docData struct {
+	*templateData
+	Title string
+}
+
+var docFuncs = map[string]interface{}{
+	"anchor": func(fullName string) string {
+		return strings.ToLower(strings.ReplaceAll(fullName, ".", "-"))
+	},
+	"relativeName": func(msg *templateMessage) string {
+		return strings.TrimPrefix(msg.FullName, msg.File.Package+".")
+	},
+	"summary": func(comments string) string {
+		line, _, _ := strings.Cut(comments, "\n")
+		return line
+	},
+	"cell": func(s string) string {
+		s = strings.ReplaceAll(s, "|", "\\|")
+		return strings.ReplaceAll(s, "\n", "<br>")
+	},
+}
+
+type docGenerator struct {
+	format     string
+	outputName string
+	title      string
+}
+
+func init() {
+	registerLangGenerator(&docGenerator{})
+}
+
+func (*docGenerator) langName() string {
+	return "doc"
+}
+
+func (gen *docGenerator) initGenerator(context *generateContext) error {
+	gen.format = "md"
+	if v, ok := context.popArg("format"); ok {
+		gen.format = v
+	}
+	if gen.format != "md" && gen.format != "html" {
+		return fmt.Errorf("unknown doc format '%s'", gen.format)
+	}
+
+	gen.outputName = "protocol." + gen.format
+	if v, ok := context.popArg("out"); ok && v != "" {
+		gen.outputName = v
+	}
+
+	gen.title = "Protocol Reference"
+	if v, ok := context.popArg("title"); ok && v != "" {
+		gen.title = v
+	}
+	return nil
+}
+
+func (gen *docGenerator) generate(context *generateContext) error {
+	err := gen.initGenerator(context)
+	if err != nil {
+		return err
+	}
+
+	files, err := context.filterFilesToGenerate()
+	if err != nil {
+		return err
+	}
+
+	gf := genFile{}
+	data := &docData{templateData: buildTemplateData(context, files), Title: gen.title}
+	if err := gen.execute(&gf.buf, data); err != nil {
+		return err
+	}
+	context.addGenFile(gen.outputName, &gf)
+	return nil
+}
+
+func (gen *docGenerator) execute(w io.Writer, data *docData) error {
+	if gen.format == "html" {
+		tmpl, err := htmltemplate.New("doc").Funcs(htmltemplate.FuncMap(templateFuncs)).Funcs(docFuncs).Parse(docHtmlTemplate)
+		if err != nil {
+			return err
+		}
+		return tmpl.Execute(w, data)
+	}
+
+	tmpl, err := template.New("doc").Funcs(templateFuncs).Funcs(docFuncs).Parse(docMarkdownTemplate)
+	if err != nil {
+		return err
+	}
+	return tmpl.Execute(w, data)
+}
@@ -1,7 +1,6 @@
 package main
 
 import (
-	"fmt"
 	"google.golang.org/protobuf/compiler/protogen"
 )
 
@@ -25,9 +24,7 @@ func (gen *goGenerator) initGenerator(context *generateContext) {
 	gen.protocmdPkg = "github.com/stalomeow/protocmd"
 	gen.registerIdent = gen.protocmdPkg.Ident("Register")
 	gen.cmdMessageIdent = gen.protocmdPkg.Ident("CmdMessage")
-
-	comVer := context.req.GetCompilerVersion()
-	gen.compilerVer = fmt.Sprintf("%v.%v.%v", comVer.GetMajor(), comVer.GetMinor(), comVer.GetPatch())
+	gen.compilerVer = context.compilerVersion()
 }
 
 func (gen *goGenerator) generate(context *generateContext) error {
@@ -66,7 +63,11 @@ func (gen *goGenerator) writeFileHeader(f *protogen.File, gf *protogen.Generated
 	gf.P("// Code generated by protoc-gen-cmdid. DO NOT EDIT.")
 	gf.P("// versions:")
 	gf.P("// \tprotoc-gen-cmdid v", genVersion)
-	gf.P("// \tprotoc           v", gen.compilerVer)
+	if gen.compilerVer != "" {
+		gf.P("// \tprotoc           v", gen.compilerVer)
+	} else {
+		gf.P("// \tprotoc           (unknown)")
+	}
 	gf.P("// source: ", f.Desc.Path())
 	gf.P()
 	gf.P("package ", f.GoPackageName)
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"sort"
+	"strings"
+)
+
+type cmdManifest struct {
+	Version string              `json:"version"`
+	Cmds    []*cmdManifestEntry `json:"cmds"`
+}
+
+type cmdManifestEntry struct {
+	CmdId          uint16 `json:"cmdId"`
+	Name           string `json:"name"`
+	FullName       string `json:"fullName"`
+	File           string `json:"file"`
+	Group          string `json:"group,omitempty"`
+	DescriptorHash string `json:"descriptorHash"`
+}
+
+type manifestGenerator struct {
+	outputName string
+}
+
+func init() {
+	registerLangGenerator(&manifestGenerator{})
+}
+
+func (*manifestGenerator) langName() string {
+	return "manifest"
+}
+
+func (gen *manifestGenerator) initGenerator(context *generateContext) {
+	gen.outputName = "cmd_manifest.json"
+	if v, ok := context.popArg("out"); ok && v != "" {
+		gen.outputName = v
+	}
+}
+
+func (gen *manifestGenerator) generate(context *generateContext) error {
+	gen.initGenerator(context)
+
+	files, err := context.filterFilesToGenerate()
+	if err != nil {
+		return err
+	}
+
+	manifest := &cmdManifest{
+		Version: genVersion,
+		Cmds:    make([]*cmdManifestEntry, 0),
+	}
+	for _, f := range files {
+		if err := gen.collectMsg(context, f.Messages(), manifest); err != nil {
+			return err
+		}
+	}
+	sort.Slice(manifest.Cmds, func(i, j int) bool {
+		return manifest.Cmds[i].CmdId < manifest.Cmds[j].CmdId
+	})
+
+	buf, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	gf := genFile{}
+	gf.buf.Write(buf)
+	gf.println()
+	context.addGenFile(gen.outputName, &gf)
+	return nil
+}
+
+func (gen *manifestGenerator) collectMsg(context *generateContext, messages protoreflect.MessageDescriptors, manifest *cmdManifest) error {
+	for i := 0; i < messages.Len(); i++ {
+		msg := messages.Get(i)
+
+		if cmdId, ok := context.config.CmdIdMap[string(msg.FullName())]; ok {
+			hash, err := hashMessageDescriptor(msg)
+			if err != nil {
+				return err
+			}
+
+			manifest.Cmds = append(manifest.Cmds, &cmdManifestEntry{
+				CmdId:          cmdId,
+				Name:           cmdName(msg),
+				FullName:       string(msg.FullName()),
+				File:           msg.ParentFile().Path(),
+				Group:          context.config.CmdGroupMap[string(msg.FullName())],
+				DescriptorHash: hash,
+			})
+		}
+
+		if err := gen.collectMsg(context, msg.Messages(), manifest); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// hashMessageDescriptor returns the SHA-256 of the deterministically
+// serialized DescriptorProto, followed by those of the message and enum
+// types it depends on in the order of their full names, so that any change
+// in the message definition (including its nested and field types) changes
+// the hash.
+func hashMessageDescriptor(msg protoreflect.MessageDescriptor) (string, error) {
+	deps := make(map[protoreflect.FullName]protoreflect.Descriptor)
+	collectDependencies(msg, msg.FullName(), deps)
+
+	names := make([]string, 0, len(deps))
+	for name := range deps {
+		names = append(names, string(name))
+	}
+	sort.Strings(names)
+
+	h := sha256.New()
+	write := func(m proto.Message) error {
+		buf, err := proto.MarshalOptions{Deterministic: true}.Marshal(m)
+		if err != nil {
+			return err
+		}
+		// The length keeps the boundaries between the descriptors.
+		h.Write(binary.AppendUvarint(nil, uint64(len(buf))))
+		h.Write(buf)
+		return nil
+	}
+
+	if err := write(protodesc.ToDescriptorProto(msg)); err != nil {
+		return "", err
+	}
+	for _, name := range names {
+		var m proto.Message
+		switch d := deps[protoreflect.FullName(name)].(type) {
+		case protoreflect.MessageDescriptor:
+			m = protodesc.ToDescriptorProto(d)
+		case protoreflect.EnumDescriptor:
+			m = protodesc.ToEnumDescriptorProto(d)
+		}
+		h.Write([]byte(name))
+		if err := write(m); err != nil {
+			return "", err
+		}
+	}
+	return "sha256:" + hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// collectDependencies adds the message and enum types used by the fields of
+// msg and its nested messages to deps, recursively. Types nested in root are
+// skipped because they are already in its DescriptorProto.
+func collectDependencies(msg protoreflect.MessageDescriptor, root protoreflect.FullName, deps map[protoreflect.FullName]protoreflect.Descriptor) {
+	fields := msg.Fields()
+	for i := 0; i < fields.Len(); i++ {
+		var d protoreflect.Descriptor
+		if m := fields.Get(i).Message(); m != nil {
+			d = m
+		} else if e := fields.Get(i).Enum(); e != nil {
+			d = e
+		} else {
+			continue
+		}
+
+		name := d.FullName()
+		if _, ok := deps[name]; ok || isNestedIn(name, root) {
+			continue
+		}
+		deps[name] = d
+		if m, ok := d.(protoreflect.MessageDescriptor); ok {
+			collectDependencies(m, root, deps)
+		}
+	}
+
+	messages := msg.Messages()
+	for i := 0; i < messages.Len(); i++ {
+		collectDependencies(messages.Get(i), root, deps)
+	}
+}
+
+// isNestedIn reports whether name is root or a type nested in it.
+func isNestedIn(name, root protoreflect.FullName) bool {
+	return name == root || strings.HasPrefix(string(name), string(root)+".")
+}
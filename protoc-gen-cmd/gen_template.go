@@ -0,0 +1,280 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+)
+
+// templateData is the root object passed to a template.
+type templateData struct {
+	Version         string             // version of protoc-gen-cmd
+	CompilerVersion string             // version of protoc, empty if it is unknown
+	Params          map[string]string  // options which are not consumed by the generator
+	Files           []*templateFile    // files to generate
+	Messages        []*templateMessage // messages having cmdIds, in the order of the files
+	Groups          []*templateGroup   // groups in cmd.yaml, in the order they appear
+}
+
+type templateFile struct {
+	Name     string             // path of the proto file, e.g. "protos/test.proto"
+	Package  string             // proto package
+	Options  map[string]string  // explicitly set file options, e.g. "go_package"
+	Messages []*templateMessage // top-level messages, including those without cmdIds
+}
+
+type templateMessage struct {
+	Name     string // e.g. "TransformInfo"
+	FullName string // e.g. "protocmd.examples.TestRsp.TransformInfo"
+	CmdName  string // e.g. "TestRsp_TransformInfo", the name returned by CmdName
+	CmdId    uint16
+	HasCmdId bool
+	Group    string // group in cmd.yaml, empty if the message is not in any group
+	Comments string // leading comments in the proto source
+	Depth    int    // 0 for top-level messages
+	Options  map[string]string
+	File     *templateFile
+	Parent   *templateMessage // nil for top-level messages
+	Nested   []*templateMessage
+	Fields   []*templateField
+}
+
+type templateField struct {
+	Name      string
+	JSONName  string
+	Number    int32
+	Label     string // "optional", "required" or "repeated", empty for map fields
+	Type      string // scalar kind, full name of the message/enum type, or "map<K, V>"
+	IsMap     bool
+	KeyType   string // type of the map keys, empty if the field is not a map
+	ValueType string // type of the map values, empty if the field is not a map
+	Comments  string
+	Message   *templateMessage // message type of the field if it's in the files to generate
+}
+
+type templateGroup struct {
+	Name     string
+	Messages []*templateMessage
+}
+
+// buildTemplateData converts descriptors into the data model of templates.
+func buildTemplateData(context *generateContext, files []protoreflect.FileDescriptor) *templateData {
+	data := &templateData{
+		Version:         genVersion,
+		CompilerVersion: context.compilerVersion(),
+		Params:          context.rawArgs,
+		Files:           make([]*templateFile, 0, len(files)),
+		Messages:        make([]*templateMessage, 0),
+		Groups:          make([]*templateGroup, 0, len(context.config.Groups)),
+	}
+
+	groupMap := make(map[string]*templateGroup)
+	for _, name := range context.config.Groups {
+		g := &templateGroup{Name: name, Messages: make([]*templateMessage, 0)}
+		groupMap[name] = g
+		data.Groups = append(data.Groups, g)
+	}
+
+	msgMap := make(map[protoreflect.FullName]*templateMessage)
+	for _, f := range files {
+		tf := &templateFile{
+			Name:    f.Path(),
+			Package: string(f.Package()),
+			Options: optionsToMap(f.Options()),
+		}
+		tf.Messages = buildTemplateMessages(context, f.Messages(), tf, nil, msgMap, data, groupMap)
+		data.Files = append(data.Files, tf)
+	}
+
+	// Link fields to their message types after all the messages are known.
+	for _, f := range files {
+		linkTemplateFields(f.Messages(), msgMap)
+	}
+	return data
+}
+
+func buildTemplateMessages(context *generateContext, messages protoreflect.MessageDescriptors, file *templateFile,
+	parent *templateMessage, msgMap map[protoreflect.FullName]*templateMessage, data *templateData,
+	groupMap map[string]*templateGroup) []*templateMessage {
+	results := make([]*templateMessage, 0, messages.Len())
+
+	for i := 0; i < messages.Len(); i++ {
+		msg := messages.Get(i)
+		if msg.IsMapEntry() {
+			continue
+		}
+
+		fullName := string(msg.FullName())
+		tm := &templateMessage{
+			Name:     string(msg.Name()),
+			FullName: fullName,
+			CmdName:  cmdName(msg),
+			Group:    context.config.CmdGroupMap[fullName],
+			Comments: leadingComments(msg),
+			Options:  optionsToMap(msg.Options()),
+			File:     file,
+			Parent:   parent,
+		}
+		if parent != nil {
+			tm.Depth = parent.Depth + 1
+		}
+		tm.CmdId, tm.HasCmdId = context.config.CmdIdMap[fullName]
+		msgMap[msg.FullName()] = tm
+
+		if tm.HasCmdId {
+			data.Messages = append(data.Messages, tm)
+			if g, ok := groupMap[tm.Group]; ok {
+				g.Messages = append(g.Messages, tm)
+			}
+		}
+
+		tm.Nested = buildTemplateMessages(context, msg.Messages(), file, tm, msgMap, data, groupMap)
+		results = append(results, tm)
+	}
+	return results
+}
+
+func linkTemplateFields(messages protoreflect.MessageDescriptors, msgMap map[protoreflect.FullName]*templateMessage) {
+	for i := 0; i < messages.Len(); i++ {
+		msg := messages.Get(i)
+		tm, ok := msgMap[msg.FullName()]
+		if !ok {
+			continue
+		}
+
+		fields := msg.Fields()
+		tm.Fields = make([]*templateField, 0, fields.Len())
+		for j := 0; j < fields.Len(); j++ {
+			fd := fields.Get(j)
+			tf := &templateField{
+				Name:     string(fd.Name()),
+				JSONName: fd.JSONName(),
+				Number:   int32(fd.Number()),
+				Label:    fd.Cardinality().String(),
+				IsMap:    fd.IsMap(),
+				Comments: leadingComments(fd),
+			}
+
+			if fd.IsMap() {
+				// Map fields are repeated entries on the wire, but have no label in proto.
+				tf.Label = ""
+				tf.KeyType = fieldTypeName(fd.MapKey())
+				tf.ValueType = fieldTypeName(fd.MapValue())
+				tf.Type = fmt.Sprintf("map<%s, %s>", tf.KeyType, tf.ValueType)
+				tf.Message = msgMap[fieldTypeFullName(fd.MapValue())]
+			} else {
+				tf.Type = fieldTypeName(fd)
+				tf.Message = msgMap[fieldTypeFullName(fd)]
+			}
+			tm.Fields = append(tm.Fields, tf)
+		}
+
+		linkTemplateFields(msg.Messages(), msgMap)
+	}
+}
+
+func fieldTypeName(fd protoreflect.FieldDescriptor) string {
+	if name := fieldTypeFullName(fd); name != "" {
+		return string(name)
+	}
+	return fd.Kind().String()
+}
+
+func fieldTypeFullName(fd protoreflect.FieldDescriptor) protoreflect.FullName {
+	switch fd.Kind() {
+	case protoreflect.MessageKind, protoreflect.GroupKind:
+		return fd.Message().FullName()
+	case protoreflect.EnumKind:
+		return fd.Enum().FullName()
+	default:
+		return ""
+	}
+}
+
+func leadingComments(desc protoreflect.Descriptor) string {
+	loc := desc.ParentFile().SourceLocations().ByDescriptor(desc)
+	return strings.TrimSpace(loc.LeadingComments)
+}
+
+func optionsToMap(options protoreflect.ProtoMessage) map[string]string {
+	results := make(map[string]string)
+	options.ProtoReflect().Range(func(fd protoreflect.FieldDescriptor, v protoreflect.Value) bool {
+		results[string(fd.Name())] = v.String()
+		return true
+	})
+	return results
+}
+
+var templateFuncs = template.FuncMap{
+	"camelCase":      func(s string) string { return underscoresToCamelCase(s, true, false) },
+	"lowerCamelCase": func(s string) string { return underscoresToCamelCase(s, false, false) },
+	"upper":          strings.ToUpper,
+	"lower":          strings.ToLower,
+	"replace":        strings.ReplaceAll,
+	"join":           strings.Join,
+	"hasPrefix":      strings.HasPrefix,
+	"hasSuffix":      strings.HasSuffix,
+	"trimPrefix":     strings.TrimPrefix,
+	"trimSuffix":     strings.TrimSuffix,
+	"hex":            func(id uint16) string { return fmt.Sprintf("0x%04X", id) },
+}
+
+type templateGenerator struct {
+	templatePath string
+	outputName   string
+}
+
+func init() {
+	registerLangGenerator(&templateGenerator{})
+}
+
+func (*templateGenerator) langName() string {
+	return "template"
+}
+
+func (gen *templateGenerator) initGenerator(context *generateContext) error {
+	var ok bool
+	gen.templatePath, ok = context.popArg("template")
+	if !ok || gen.templatePath == "" {
+		return errors.New("template is not specified")
+	}
+
+	gen.outputName, ok = context.popArg("out")
+	if !ok || gen.outputName == "" {
+		gen.outputName = strings.TrimSuffix(filepath.Base(gen.templatePath), ".tmpl")
+	}
+	return nil
+}
+
+func (gen *templateGenerator) generate(context *generateContext) error {
+	err := gen.initGenerator(context)
+	if err != nil {
+		return err
+	}
+
+	buf, err := os.ReadFile(gen.templatePath)
+	if err != nil {
+		return err
+	}
+
+	tmpl, err := template.New(filepath.Base(gen.templatePath)).Funcs(templateFuncs).Parse(string(buf))
+	if err != nil {
+		return err
+	}
+
+	files, err := context.filterFilesToGenerate()
+	if err != nil {
+		return err
+	}
+
+	gf := genFile{}
+	if err := tmpl.Execute(&gf.buf, buildTemplateData(context, files)); err != nil {
+		return err
+	}
+	context.addGenFile(gen.outputName, &gf)
+	return nil
+}
@@ -0,0 +1,248 @@
+package main
+
+import (
+	"flag"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "update golden files")
+
+type generatorTestCase struct {
+	name           string
+	descriptorSets []string
+	files          []string
+	parameter      string
+	wantErr        string // if not empty, the generator must fail with an error containing it
+}
+
+var generatorTestCases = []generatorTestCase{
+	{
+		name:           "go_examples",
+		descriptorSets: []string{"examples.pb"},
+		parameter:      "lang=go,config=testdata/cmd.yaml,module=github.com/stalomeow/protocmd/examples/go",
+	},
+	{
+		name:           "go_nested",
+		descriptorSets: []string{"nested.pb"},
+		parameter:      "lang=go,config=testdata/cmd.yaml,paths=source_relative",
+	},
+	{
+		name:           "csharp_examples",
+		descriptorSets: []string{"examples.pb"},
+		parameter:      "lang=csharp,config=testdata/cmd.yaml,base_namespace=Examples.CSharp",
+	},
+	{
+		name:           "csharp_only_test_proto",
+		descriptorSets: []string{"examples.pb"},
+		files:          []string{"test.proto"},
+		parameter:      "lang=csharp,config=testdata/cmd.yaml",
+	},
+	{
+		name:           "csharp_nested_namespace",
+		descriptorSets: []string{"nested.pb"},
+		parameter:      "lang=csharp,config=testdata/cmd.yaml,base_namespace=,msg_helpers_name=Helpers,msg_helpers_ns=Fixtures",
+	},
+	{
+		name:           "template",
+		descriptorSets: []string{"examples.pb", "nested.pb"},
+		parameter:      "lang=template,config=testdata/cmd.yaml,template=testdata/cmd_list.txt.tmpl,suffix=!",
+	},
+	{
+		name:           "doc_markdown",
+		descriptorSets: []string{"examples.pb", "nested.pb"},
+		parameter:      "lang=doc,config=testdata/cmd.yaml",
+	},
+	{
+		name:           "doc_html",
+		descriptorSets: []string{"nested.pb"},
+		parameter:      "lang=doc,config=testdata/cmd.yaml,format=html,title=Nested",
+	},
+	{
+		name:           "manifest",
+		descriptorSets: []string{"examples.pb", "nested.pb"},
+		parameter:      "lang=manifest,config=testdata/cmd.yaml",
+	},
+	{
+		name:           "wireshark",
+		descriptorSets: []string{"examples.pb"},
+		parameter:      "lang=wireshark,config=testdata/cmd.yaml,ports=7777+7778",
+	},
+	{
+		name:           "missing_config",
+		descriptorSets: []string{"examples.pb"},
+		parameter:      "lang=go,config=testdata/missing.yaml",
+		wantErr:        "missing.yaml",
+	},
+	{
+		name:           "duplicated_cmd_id",
+		descriptorSets: []string{"examples.pb"},
+		parameter:      "lang=go,config=testdata/cmd_dup.yaml",
+		wantErr:        "duplicated cmdId 1010",
+	},
+	{
+		name:           "reserved_cmd_id",
+		descriptorSets: []string{"examples.pb"},
+		parameter:      "lang=go,config=testdata/cmd_reserved.yaml",
+		wantErr:        "cmdId 65280 of protocmd.examples.TestRsp is reserved for control messages",
+	},
+	{
+		name:           "csharp_invalid_base_namespace",
+		descriptorSets: []string{"examples.pb"},
+		parameter:      "lang=csharp,config=testdata/cmd.yaml,base_namespace=Other",
+		wantErr:        "namespace Examples.CSharp.Protos does not have base namespace Other",
+	},
+	{
+		name:           "unknown_lang",
+		descriptorSets: []string{"examples.pb"},
+		parameter:      "lang=rust,config=testdata/cmd.yaml",
+		wantErr:        "lang 'rust' was not registered",
+	},
+}
+
+func TestGenerators(t *testing.T) {
+	for _, tc := range generatorTestCases {
+		t.Run(tc.name, func(t *testing.T) {
+			sets := make([]string, len(tc.descriptorSets))
+			for i, s := range tc.descriptorSets {
+				sets[i] = filepath.Join("testdata", s)
+			}
+
+			req, err := newCodeGeneratorRequest(sets, tc.files, tc.parameter)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			rsp, err := response(req)
+			if tc.wantErr != "" {
+				if err == nil {
+					t.Fatalf("expected error containing %q, got nil", tc.wantErr)
+				}
+				if !strings.Contains(err.Error(), tc.wantErr) {
+					t.Fatalf("expected error containing %q, got %q", tc.wantErr, err.Error())
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if rsp.Error != nil {
+				t.Fatal(rsp.GetError())
+			}
+
+			goldenDir := filepath.Join("testdata", "golden", tc.name)
+			if *update {
+				if err := os.RemoveAll(goldenDir); err != nil {
+					t.Fatal(err)
+				}
+				if err := writeResponseFiles(goldenDir, rsp); err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+
+			got := make(map[string]string)
+			for _, f := range rsp.File {
+				got[filepath.FromSlash(f.GetName())] = f.GetContent()
+			}
+
+			err = filepath.WalkDir(goldenDir, func(p string, d os.DirEntry, err error) error {
+				if err != nil || d.IsDir() {
+					return err
+				}
+
+				name, err := filepath.Rel(goldenDir, p)
+				if err != nil {
+					return err
+				}
+				want, err := os.ReadFile(p)
+				if err != nil {
+					return err
+				}
+
+				content, ok := got[name]
+				if !ok {
+					t.Errorf("file %s was not generated", name)
+					return nil
+				}
+				delete(got, name)
+
+				if content != string(want) {
+					t.Errorf("file %s does not match the golden file; run go test with -update if the change is intended\ngot:\n%s\nwant:\n%s", name, content, want)
+				}
+				return nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for name := range got {
+				t.Errorf("unexpected file %s was generated", name)
+			}
+		})
+	}
+}
+
+// hashMessages returns the descriptor hashes of the messages in the set.
+func hashMessages(t *testing.T, set *descriptorpb.FileDescriptorSet, names ...protoreflect.FullName) []string {
+	t.Helper()
+
+	files, err := protodesc.NewFiles(set)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hashes := make([]string, len(names))
+	for i, name := range names {
+		d, err := files.FindDescriptorByName(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if hashes[i], err = hashMessageDescriptor(d.(protoreflect.MessageDescriptor)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return hashes
+}
+
+func TestHashMessageDescriptor(t *testing.T) {
+	buf, err := os.ReadFile(filepath.Join("testdata", "examples.pb"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	set := &descriptorpb.FileDescriptorSet{}
+	if err := proto.Unmarshal(buf, set); err != nil {
+		t.Fatal(err)
+	}
+
+	names := []protoreflect.FullName{
+		"protocmd.examples.TestReq",
+		"protocmd.examples.TestRsp",
+		"protocmd.examples.TestRsp.TransformInfo",
+	}
+	want := hashMessages(t, set, names...)
+	if again := hashMessages(t, set, names...); strings.Join(again, ",") != strings.Join(want, ",") {
+		t.Fatalf("got %v, then %v", want, again)
+	}
+
+	// Changing Vector3 changes the messages using it, even through a nested type.
+	changed := proto.Clone(set).(*descriptorpb.FileDescriptorSet)
+	for _, f := range changed.File {
+		for _, m := range f.MessageType {
+			if m.GetName() == "Vector3" {
+				m.Field[0].Name = proto.String("w")
+			}
+		}
+	}
+	got := hashMessages(t, changed, names...)
+	for i, name := range names {
+		if (got[i] != want[i]) != (name != "protocmd.examples.TestReq") {
+			t.Errorf("%s: got %s, was %s", name, got[i], want[i])
+		}
+	}
+}
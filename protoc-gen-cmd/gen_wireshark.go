@@ -0,0 +1,310 @@
+package main
+
+import (
+	"fmt"
+	"github.com/stalomeow/protocmd"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+type wiresharkGenerator struct {
+	outputName string
+	protoName  string
+	ports      []uint16
+}
+
+// controlMessages are named in the dissector even if control.proto is not
+// in the files to generate.
+var controlMessages = []protocmd.CmdMessage{
+	&protocmd.Ping{},
+	&protocmd.Pong{},
+	&protocmd.Disconnect{},
+	&protocmd.KeyExchange{},
+}
+
+func init() {
+	registerLangGenerator(&wiresharkGenerator{})
+}
+
+func (*wiresharkGenerator) langName() string {
+	return "wireshark"
+}
+
+func (gen *wiresharkGenerator) initGenerator(context *generateContext) error {
+	gen.protoName = "protocmd"
+	if v, ok := context.popArg("proto_name"); ok && v != "" {
+		gen.protoName = v
+	}
+
+	gen.outputName = gen.protoName + ".lua"
+	if v, ok := context.popArg("out"); ok && v != "" {
+		gen.outputName = v
+	}
+
+	// Ports are separated by '+' because ',' separates the options.
+	gen.ports = make([]uint16, 0)
+	if v, ok := context.popArg("ports"); ok && v != "" {
+		for _, p := range strings.Split(v, "+") {
+			port, err := strconv.ParseUint(p, 10, 16)
+			if err != nil {
+				return fmt.Errorf("invalid port '%s'", p)
+			}
+			gen.ports = append(gen.ports, uint16(port))
+		}
+	}
+	return nil
+}
+
+func (gen *wiresharkGenerator) generate(context *generateContext) error {
+	err := gen.initGenerator(context)
+	if err != nil {
+		return err
+	}
+
+	files, err := context.filterFilesToGenerate()
+	if err != nil {
+		return err
+	}
+
+	messages := buildTemplateData(context, files).Messages
+	sort.SliceStable(messages, func(i, j int) bool {
+		return messages[i].CmdId < messages[j].CmdId
+	})
+
+	gf := genFile{}
+	gf.println("-- Generated by protoc-gen-cmd v", genVersion, ".  DO NOT EDIT!")
+	gf.println("--")
+	gf.println("-- Wireshark dissector for protocmd frames. Copy this file into the plugin")
+	gf.println("-- folder of Wireshark, and add the folders of the .proto files to")
+	gf.println("-- \"Protobuf search paths\" in the preferences of the Protobuf protocol so")
+	gf.println("-- that the payload is decoded as well.")
+	gf.println()
+	gf.println("local proto = Proto(\"", gen.protoName, "\", \"protocmd\")")
+	gf.println()
+
+	gf.println("local cmd_names = {")
+	gf.indent(1)
+	names := make(map[uint16]bool)
+	for _, msg := range messages {
+		gf.println("[", msg.CmdId, "] = \"", msg.CmdName, "\",")
+		names[msg.CmdId] = true
+	}
+	for _, msg := range controlMessages {
+		if !names[msg.CmdId()] {
+			gf.println("[", msg.CmdId(), "] = \"", msg.CmdName(), "\",")
+		}
+	}
+	gf.println("[", protocmd.BatchCmdId, "] = \"Batch\",")
+	gf.indent(-1)
+	gf.println("}")
+	gf.println()
+
+	gf.println("local cmd_full_names = {")
+	gf.indent(1)
+	for _, msg := range messages {
+		gf.println("[", msg.CmdId, "] = \"", msg.FullName, "\",")
+	}
+	for _, msg := range controlMessages {
+		if !names[msg.CmdId()] {
+			gf.println("[", msg.CmdId(), "] = \"", msg.ProtoReflect().Descriptor().FullName(), "\",")
+		}
+	}
+	gf.indent(-1)
+	gf.println("}")
+	gf.println()
+
+	gf.println("local compressor_names = {")
+	gf.indent(1)
+	gf.println("[", protocmd.GzipCompressorId, "] = \"gzip\",")
+	gf.println("[", protocmd.ZlibCompressorId, "] = \"zlib\",")
+	gf.indent(-1)
+	gf.println("}")
+	gf.println()
+
+	gf.println("local payload_codec_names = {")
+	gf.indent(1)
+	gf.println("[", protocmd.ProtoPayloadCodecId, "] = \"proto\",")
+	gf.println("[", protocmd.JSONPayloadCodecId, "] = \"json\",")
+	gf.indent(-1)
+	gf.println("}")
+	gf.println()
+
+	gf.println("local f_length = ProtoField.uint32(\"", gen.protoName, ".length\", \"Length\", base.DEC)")
+	gf.println("local f_cmd_id = ProtoField.uint16(\"", gen.protoName, ".cmd_id\", \"Cmd Id\", base.DEC, cmd_names)")
+	gf.println("local f_flags = ProtoField.uint8(\"", gen.protoName, ".flags\", \"Flags\", base.HEX)")
+	gf.println("local f_flag_compressed = ProtoField.bool(\"", gen.protoName, ".flags.compressed\", \"Compressed\", 8, nil, ", protocmd.FlagCompressed, ")")
+	gf.println("local f_flag_encrypted = ProtoField.bool(\"", gen.protoName, ".flags.encrypted\", \"Encrypted\", 8, nil, ", protocmd.FlagEncrypted, ")")
+	gf.println("local f_flag_sequence = ProtoField.bool(\"", gen.protoName, ".flags.sequence\", \"Sequence\", 8, nil, ", protocmd.FlagSequence, ")")
+	gf.println("local f_flag_crc32 = ProtoField.bool(\"", gen.protoName, ".flags.crc32\", \"CRC32\", 8, nil, ", protocmd.FlagCRC32, ")")
+	gf.println("local f_flag_xxh32 = ProtoField.bool(\"", gen.protoName, ".flags.xxh32\", \"XXH32\", 8, nil, ", protocmd.FlagXXH32, ")")
+	gf.println("local f_flag_payload_codec = ProtoField.bool(\"", gen.protoName, ".flags.payload_codec\", \"Payload Codec\", 8, nil, ", protocmd.FlagPayloadCodec, ")")
+	gf.println("local f_seq = ProtoField.uint32(\"", gen.protoName, ".seq\", \"Sequence\", base.DEC)")
+	gf.println("local f_checksum = ProtoField.uint32(\"", gen.protoName, ".checksum\", \"Checksum\", base.HEX)")
+	gf.println("local f_payload_codec = ProtoField.uint8(\"", gen.protoName, ".payload_codec\", \"Payload Codec\", base.DEC, payload_codec_names)")
+	gf.println("local f_compressor = ProtoField.uint8(\"", gen.protoName, ".compressor\", \"Compressor\", base.DEC, compressor_names)")
+	gf.println("local f_message = ProtoField.string(\"", gen.protoName, ".message\", \"Message\")")
+	gf.println("local f_payload = ProtoField.bytes(\"", gen.protoName, ".payload\", \"Payload\")")
+	gf.println("proto.fields = { f_length, f_cmd_id, f_flags, f_flag_compressed, f_flag_encrypted, f_flag_sequence, f_flag_crc32, f_flag_xxh32, f_flag_payload_codec, f_seq, f_checksum, f_payload_codec, f_compressor, f_message, f_payload }")
+	gf.println()
+	gf.println("local HEADER_SIZE = ", protocmd.FrameHeaderSize)
+	gf.println("local FLAG_COMPRESSED = ", protocmd.FlagCompressed)
+	gf.println("local FLAG_ENCODING = ", protocmd.FlagCompressed|protocmd.FlagEncrypted)
+	gf.println("local FLAG_SEQUENCE = ", protocmd.FlagSequence)
+	gf.println("local FLAG_CHECKSUM = ", protocmd.FlagCRC32|protocmd.FlagXXH32)
+	gf.println("local FLAG_PAYLOAD_CODEC = ", protocmd.FlagPayloadCodec)
+	gf.println("local JSON_PAYLOAD_CODEC = ", protocmd.JSONPayloadCodecId)
+	gf.println("local BATCH_CMD_ID = ", protocmd.BatchCmdId)
+	gf.println("local has_protobuf, protobuf_dissector = pcall(Dissector.get, \"protobuf\")")
+	gf.println("if not has_protobuf then")
+	gf.indent(1)
+	gf.println("protobuf_dissector = nil")
+	gf.indent(-1)
+	gf.println("end")
+	gf.println("local has_json, json_dissector = pcall(Dissector.get, \"json\")")
+	gf.println("if not has_json then")
+	gf.indent(1)
+	gf.println("json_dissector = nil")
+	gf.indent(-1)
+	gf.println("end")
+	gf.println()
+
+	gf.println("local function get_frame_length(tvb, pinfo, offset)")
+	gf.indent(1)
+	gf.println("return HEADER_SIZE + tvb(offset, 4):uint()")
+	gf.indent(-1)
+	gf.println("end")
+	gf.println()
+
+	gf.println("local function dissect_frame(tvb, pinfo, tree)")
+	gf.indent(1)
+	gf.println("local length = tvb(0, 4):uint()")
+	gf.println("local cmd_id = tvb(4, 2):uint()")
+	gf.println("local flags = tvb(6, 1):uint()")
+	gf.println("local name = cmd_names[cmd_id] or (\"Unknown(\" .. cmd_id .. \")\")")
+	gf.println("local full_name = cmd_full_names[cmd_id]")
+	gf.println()
+	gf.println("pinfo.cols.protocol = \"", gen.protoName, "\"")
+	gf.println("pinfo.cols.info:append(name .. \" \")")
+	gf.println()
+	gf.println("local subtree = tree:add(proto, tvb(0, HEADER_SIZE + length), \"protocmd, \" .. name)")
+	gf.println("subtree:add(f_length, tvb(0, 4))")
+	gf.println("subtree:add(f_cmd_id, tvb(4, 2))")
+	gf.println("local flags_tree = subtree:add(f_flags, tvb(6, 1))")
+	gf.println("flags_tree:add(f_flag_compressed, tvb(6, 1))")
+	gf.println("flags_tree:add(f_flag_encrypted, tvb(6, 1))")
+	gf.println("flags_tree:add(f_flag_sequence, tvb(6, 1))")
+	gf.println("flags_tree:add(f_flag_crc32, tvb(6, 1))")
+	gf.println("flags_tree:add(f_flag_xxh32, tvb(6, 1))")
+	gf.println("flags_tree:add(f_flag_payload_codec, tvb(6, 1))")
+	gf.println("if full_name then")
+	gf.indent(1)
+	gf.println("subtree:add(f_message, full_name)")
+	gf.indent(-1)
+	gf.println("end")
+	gf.println()
+	gf.println("local offset = HEADER_SIZE")
+	gf.println("local size = length")
+	gf.println("if bit.band(flags, FLAG_SEQUENCE) ~= 0 and size >= 4 then")
+	gf.indent(1)
+	gf.println("subtree:add(f_seq, tvb(offset, 4))")
+	gf.println("offset = offset + 4")
+	gf.println("size = size - 4")
+	gf.indent(-1)
+	gf.println("end")
+	gf.println("local payload_codec = 0")
+	gf.println("if bit.band(flags, FLAG_PAYLOAD_CODEC) ~= 0 and size >= 1 then")
+	gf.indent(1)
+	gf.println("subtree:add(f_payload_codec, tvb(offset, 1))")
+	gf.println("payload_codec = tvb(offset, 1):uint()")
+	gf.println("offset = offset + 1")
+	gf.println("size = size - 1")
+	gf.indent(-1)
+	gf.println("end")
+	gf.println("if bit.band(flags, FLAG_CHECKSUM) ~= 0 and size >= 4 then")
+	gf.indent(1)
+	gf.println("size = size - 4")
+	gf.println("subtree:add(f_checksum, tvb(offset + size, 4))")
+	gf.indent(-1)
+	gf.println("end")
+	gf.println()
+	gf.println("if size > 0 then")
+	gf.indent(1)
+	gf.println("local payload = tvb(offset, size)")
+	gf.println("subtree:add(f_payload, payload)")
+	gf.println()
+	gf.println("local encoding = bit.band(flags, FLAG_ENCODING)")
+	gf.println("local message = nil")
+	gf.println("if encoding == 0 then")
+	gf.indent(1)
+	gf.println("message = payload:tvb()")
+	gf.indent(-1)
+	gf.println("elseif encoding == FLAG_COMPRESSED and size > 1 then")
+	gf.indent(1)
+	gf.println("subtree:add(f_compressor, tvb(offset, 1))")
+	gf.println("local ok, data = pcall(function()")
+	gf.indent(1)
+	gf.println("return tvb(offset + 1, size - 1):uncompress(\"Decompressed payload\")")
+	gf.indent(-1)
+	gf.println("end)")
+	gf.println("if ok then")
+	gf.indent(1)
+	gf.println("message = data")
+	gf.indent(-1)
+	gf.println("end")
+	gf.indent(-1)
+	gf.println("end")
+	gf.println()
+	gf.println("-- The payload of a batch frame is a sequence of frames.")
+	gf.println("if cmd_id == BATCH_CMD_ID and message then")
+	gf.indent(1)
+	gf.println("local item_offset = 0")
+	gf.println("while item_offset + HEADER_SIZE <= message:len() do")
+	gf.indent(1)
+	gf.println("local item_size = HEADER_SIZE + message(item_offset, 4):uint()")
+	gf.println("if item_offset + item_size > message:len() then")
+	gf.indent(1)
+	gf.println("break")
+	gf.indent(-1)
+	gf.println("end")
+	gf.println("dissect_frame(message(item_offset, item_size):tvb(), pinfo, subtree)")
+	gf.println("item_offset = item_offset + item_size")
+	gf.indent(-1)
+	gf.println("end")
+	gf.indent(-1)
+	gf.println("elseif payload_codec == 0 and full_name and protobuf_dissector and message then")
+	gf.indent(1)
+	gf.println("pinfo.private[\"pb_msg_type\"] = \"message,\" .. full_name")
+	gf.println("pcall(Dissector.call, protobuf_dissector, message, pinfo, subtree)")
+	gf.indent(-1)
+	gf.println("elseif payload_codec == JSON_PAYLOAD_CODEC and json_dissector and message then")
+	gf.indent(1)
+	gf.println("pcall(Dissector.call, json_dissector, message, pinfo, subtree)")
+	gf.indent(-1)
+	gf.println("end")
+	gf.indent(-1)
+	gf.println("end")
+	gf.println("return HEADER_SIZE + length")
+	gf.indent(-1)
+	gf.println("end")
+	gf.println()
+
+	gf.println("function proto.dissector(tvb, pinfo, tree)")
+	gf.indent(1)
+	gf.println("pinfo.cols.info:clear()")
+	gf.println("dissect_tcp_pdus(tvb, tree, HEADER_SIZE, get_frame_length, dissect_frame, true)")
+	gf.println("return tvb:len()")
+	gf.indent(-1)
+	gf.println("end")
+
+	if len(gen.ports) > 0 {
+		gf.println()
+		gf.println("local tcp_port_table = DissectorTable.get(\"tcp.port\")")
+		for _, port := range gen.ports {
+			gf.println("tcp_port_table:add(", port, ", proto)")
+		}
+	}
+
+	context.addGenFile(gen.outputName, &gf)
+	return nil
+}
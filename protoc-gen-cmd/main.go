@@ -19,7 +19,10 @@ func main() {
 
 func run() error {
 	if len(os.Args) > 1 {
-		return fmt.Errorf("unknown argument %q (this program should be run by protoc, not directly)", os.Args[1])
+		if os.Args[1] == "generate" {
+			return runGenerate(os.Args[2:])
+		}
+		return fmt.Errorf("unknown argument %q (this program should be run by protoc, or with the generate command)", os.Args[1])
 	}
 
 	in, err := io.ReadAll(os.Stdin)
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/pluginpb"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const generateUsage = `usage: protoc-gen-cmd generate --descriptor_set_in=FILES --lang=LANG [options] [PROTO_FILES...]
+
+Generates code without protoc. PROTO_FILES are the names of files in the
+descriptor sets to generate; all files in the descriptor sets are generated
+if none is given.
+
+options:
+`
+
+// runGenerate runs protoc-gen-cmd in standalone mode. It builds the
+// CodeGeneratorRequest from FileDescriptorSets instead of reading it from protoc.
+func runGenerate(args []string) error {
+	flags := flag.NewFlagSet("generate", flag.ContinueOnError)
+	descriptorSetIn := flags.String("descriptor_set_in", "", "FileDescriptorSets to read, delimited by '"+string(os.PathListSeparator)+"'")
+	lang := flags.String("lang", "", "output language")
+	opt := flags.String("opt", "", "comma-separated options, the same as --cmd_opt of protoc")
+	out := flags.String("out", ".", "output directory")
+	flags.Usage = func() {
+		fmt.Fprint(flags.Output(), generateUsage)
+		flags.PrintDefaults()
+	}
+
+	if err := flags.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return nil
+		}
+		return err
+	}
+	if *descriptorSetIn == "" {
+		return errors.New("descriptor_set_in is not specified")
+	}
+	if *lang == "" {
+		return errors.New("lang is not specified")
+	}
+
+	parameter := "lang=" + *lang
+	if *opt != "" {
+		parameter += "," + *opt
+	}
+
+	req, err := newCodeGeneratorRequest(filepath.SplitList(*descriptorSetIn), flags.Args(), parameter)
+	if err != nil {
+		return err
+	}
+
+	rsp, err := response(req)
+	if err != nil {
+		return err
+	}
+	if rsp.Error != nil {
+		return errors.New(rsp.GetError())
+	}
+	return writeResponseFiles(*out, rsp)
+}
+
+func newCodeGeneratorRequest(descriptorSetPaths []string, filesToGenerate []string, parameter string) (*pluginpb.CodeGeneratorRequest, error) {
+	fileMap := make(map[string]*descriptorpb.FileDescriptorProto)
+	fileNames := make([]string, 0)
+
+	for _, p := range descriptorSetPaths {
+		buf, err := os.ReadFile(p)
+		if err != nil {
+			return nil, err
+		}
+
+		set := &descriptorpb.FileDescriptorSet{}
+		if err := proto.Unmarshal(buf, set); err != nil {
+			return nil, fmt.Errorf("invalid FileDescriptorSet %q: %v", p, err)
+		}
+
+		for _, f := range set.File {
+			if _, ok := fileMap[f.GetName()]; ok {
+				continue
+			}
+			fileMap[f.GetName()] = f
+			fileNames = append(fileNames, f.GetName())
+		}
+	}
+
+	if len(filesToGenerate) <= 0 {
+		filesToGenerate = fileNames
+	}
+	for _, name := range filesToGenerate {
+		if _, ok := fileMap[name]; !ok {
+			return nil, fmt.Errorf("file %q was not found in the descriptor sets", name)
+		}
+	}
+
+	// protoc sends files in topological order, so that every file comes after its dependencies.
+	protoFiles := make([]*descriptorpb.FileDescriptorProto, 0, len(fileMap))
+	visited := make(map[string]bool)
+	var visit func(name string) error
+	visit = func(name string) error {
+		if done, ok := visited[name]; ok {
+			if !done {
+				return fmt.Errorf("import cycle detected at %q", name)
+			}
+			return nil
+		}
+
+		f, ok := fileMap[name]
+		if !ok {
+			return fmt.Errorf("dependency %q was not found in the descriptor sets", name)
+		}
+
+		visited[name] = false
+		for _, dep := range f.Dependency {
+			if err := visit(dep); err != nil {
+				return err
+			}
+		}
+		visited[name] = true
+		protoFiles = append(protoFiles, f)
+		return nil
+	}
+	for _, name := range filesToGenerate {
+		if err := visit(name); err != nil {
+			return nil, err
+		}
+	}
+
+	return &pluginpb.CodeGeneratorRequest{
+		FileToGenerate: filesToGenerate,
+		Parameter:      proto.String(parameter),
+		ProtoFile:      protoFiles,
+	}, nil
+}
+
+func writeResponseFiles(outDir string, rsp *pluginpb.CodeGeneratorResponse) error {
+	for _, f := range rsp.File {
+		if f.GetInsertionPoint() != "" {
+			return fmt.Errorf("insertion point %q is not supported in standalone mode", f.GetInsertionPoint())
+		}
+
+		name := filepath.FromSlash(f.GetName())
+		if filepath.IsAbs(name) || strings.HasPrefix(filepath.Clean(name), "..") {
+			return fmt.Errorf("invalid output file name %q", f.GetName())
+		}
+
+		p := filepath.Join(outDir, name)
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			return err
+		}
+		if err := os.WriteFile(p, []byte(f.GetContent()), 0644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,21 @@
+
+
+vector.protoprotocmd.examples"3
+Vector3
+x (Rx
+y (Ry
+z (RzBKZ0github.com/stalomeow/protocmd/examples/go/protosExamples.CSharp.Protosbproto3
+
+
+test.protoprotocmd.examplesvector.proto"
+TestReq
+uid (	Ruid"
+TestRsp
+ret_code (RretCodeH
+
+transforms (2(.protocmd.examples.TestRsp.TransformInfoR
+transforms
+TransformInfo6
+position (2.protocmd.examples.Vector3Rposition<
+eulerAngles (2.protocmd.examples.Vector3ReulerAngles0
+scale (2.protocmd.examples.Vector3RscaleBKZ0github.com/stalomeow/protocmd/examples/go/protosExamples.CSharp.Protosbproto3
\ No newline at end of file
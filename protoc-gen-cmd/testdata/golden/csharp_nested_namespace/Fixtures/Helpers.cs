@@ -0,0 +1,18 @@
+// <auto-generated>
+//     Generated by protoc-gen-cmd v1.0.0.  DO NOT EDIT!
+// </auto-generated>
+
+using pb = global::Google.Protobuf;
+
+namespace Fixtures
+{
+    public partial class Helpers : pb::BaseMessageHelpers
+    {
+        public Helpers()
+        {
+            this.Register(Fixtures.NestedPkg.Outer.CmdId, Fixtures.NestedPkg.Outer.CmdName, Fixtures.NestedPkg.Outer.Parser, () => Fixtures.NestedPkg.Outer.Descriptor);
+            this.Register(Fixtures.NestedPkg.Outer.Types.Middle.CmdId, Fixtures.NestedPkg.Outer.Types.Middle.CmdName, Fixtures.NestedPkg.Outer.Types.Middle.Parser, () => Fixtures.NestedPkg.Outer.Types.Middle.Descriptor);
+            this.Register(Fixtures.NestedPkg.Outer.Types.Middle.Types.Inner.CmdId, Fixtures.NestedPkg.Outer.Types.Middle.Types.Inner.CmdName, Fixtures.NestedPkg.Outer.Types.Middle.Types.Inner.Parser, () => Fixtures.NestedPkg.Outer.Types.Middle.Types.Inner.Descriptor);
+        }
+    }
+}
@@ -0,0 +1,42 @@
+// <auto-generated>
+//     Generated by protoc-gen-cmd v1.0.0.  DO NOT EDIT!
+//     source: nested.proto
+// </auto-generated>
+
+using pb = global::Google.Protobuf;
+
+namespace Fixtures.NestedPkg
+{
+    partial class Outer : pb::ICmdMessage
+    {
+        public static ushort CmdId { get { return 100; } }
+        ushort pb::ICmdMessage.CmdId { get { return 100; } }
+
+        public static string CmdName { get { return "Outer"; } }
+        string pb::ICmdMessage.CmdName { get { return "Outer"; } }
+
+        partial class Types
+        {
+            partial class Middle : pb::ICmdMessage
+            {
+                public static ushort CmdId { get { return 101; } }
+                ushort pb::ICmdMessage.CmdId { get { return 101; } }
+
+                public static string CmdName { get { return "Middle"; } }
+                string pb::ICmdMessage.CmdName { get { return "Middle"; } }
+
+                partial class Types
+                {
+                    partial class Inner : pb::ICmdMessage
+                    {
+                        public static ushort CmdId { get { return 102; } }
+                        ushort pb::ICmdMessage.CmdId { get { return 102; } }
+
+                        public static string CmdName { get { return "Inner"; } }
+                        string pb::ICmdMessage.CmdName { get { return "Inner"; } }
+                    }
+                }
+            }
+        }
+    }
+}
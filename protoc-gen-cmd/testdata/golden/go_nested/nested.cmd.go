@@ -0,0 +1,41 @@
+// Code generated by protoc-gen-cmdid. DO NOT EDIT.
+// versions:
+// 	protoc-gen-cmdid v1.0.0
+// 	protoc           (unknown)
+// source: nested.proto
+
+package nested
+
+import (
+	protocmd "github.com/stalomeow/protocmd"
+)
+
+const (
+	Outer_CmdId   uint16 = 100
+	Outer_CmdName string = "Outer"
+)
+
+func (*Outer) CmdId() uint16   { return Outer_CmdId }
+func (*Outer) CmdName() string { return Outer_CmdName }
+
+const (
+	Outer_Middle_CmdId   uint16 = 101
+	Outer_Middle_CmdName string = "Outer_Middle"
+)
+
+func (*Outer_Middle) CmdId() uint16   { return Outer_Middle_CmdId }
+func (*Outer_Middle) CmdName() string { return Outer_Middle_CmdName }
+
+const (
+	Outer_Middle_Inner_CmdId   uint16 = 102
+	Outer_Middle_Inner_CmdName string = "Outer_Middle_Inner"
+)
+
+func (*Outer_Middle_Inner) CmdId() uint16   { return Outer_Middle_Inner_CmdId }
+func (*Outer_Middle_Inner) CmdName() string { return Outer_Middle_Inner_CmdName }
+
+func init() {
+	protocmd.Register(func() protocmd.CmdMessage { return new(Outer) })
+	protocmd.Register(func() protocmd.CmdMessage { return new(Outer_Middle) })
+	protocmd.Register(func() protocmd.CmdMessage { return new(Outer_Middle_Inner) })
+}
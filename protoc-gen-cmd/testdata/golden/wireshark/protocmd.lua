@@ -0,0 +1,169 @@
+-- Generated by protoc-gen-cmd v1.0.0.  DO NOT EDIT!
+--
+-- Wireshark dissector for protocmd frames. Copy this file into the plugin
+-- folder of Wireshark, and add the folders of the .proto files to
+-- "Protobuf search paths" in the preferences of the Protobuf protocol so
+-- that the payload is decoded as well.
+
+local proto = Proto("protocmd", "protocmd")
+
+local cmd_names = {
+    [1010] = "TestReq",
+    [1011] = "TestRsp",
+    [2010] = "TestRsp_TransformInfo",
+    [65280] = "Ping",
+    [65281] = "Pong",
+    [65282] = "Disconnect",
+    [65283] = "KeyExchange",
+    [65535] = "Batch",
+}
+
+local cmd_full_names = {
+    [1010] = "protocmd.examples.TestReq",
+    [1011] = "protocmd.examples.TestRsp",
+    [2010] = "protocmd.examples.TestRsp.TransformInfo",
+    [65280] = "protocmd.Ping",
+    [65281] = "protocmd.Pong",
+    [65282] = "protocmd.Disconnect",
+    [65283] = "protocmd.KeyExchange",
+}
+
+local compressor_names = {
+    [1] = "gzip",
+    [2] = "zlib",
+}
+
+local payload_codec_names = {
+    [0] = "proto",
+    [1] = "json",
+}
+
+local f_length = ProtoField.uint32("protocmd.length", "Length", base.DEC)
+local f_cmd_id = ProtoField.uint16("protocmd.cmd_id", "Cmd Id", base.DEC, cmd_names)
+local f_flags = ProtoField.uint8("protocmd.flags", "Flags", base.HEX)
+local f_flag_compressed = ProtoField.bool("protocmd.flags.compressed", "Compressed", 8, nil, 1)
+local f_flag_encrypted = ProtoField.bool("protocmd.flags.encrypted", "Encrypted", 8, nil, 2)
+local f_flag_sequence = ProtoField.bool("protocmd.flags.sequence", "Sequence", 8, nil, 4)
+local f_flag_crc32 = ProtoField.bool("protocmd.flags.crc32", "CRC32", 8, nil, 8)
+local f_flag_xxh32 = ProtoField.bool("protocmd.flags.xxh32", "XXH32", 8, nil, 16)
+local f_flag_payload_codec = ProtoField.bool("protocmd.flags.payload_codec", "Payload Codec", 8, nil, 32)
+local f_seq = ProtoField.uint32("protocmd.seq", "Sequence", base.DEC)
+local f_checksum = ProtoField.uint32("protocmd.checksum", "Checksum", base.HEX)
+local f_payload_codec = ProtoField.uint8("protocmd.payload_codec", "Payload Codec", base.DEC, payload_codec_names)
+local f_compressor = ProtoField.uint8("protocmd.compressor", "Compressor", base.DEC, compressor_names)
+local f_message = ProtoField.string("protocmd.message", "Message")
+local f_payload = ProtoField.bytes("protocmd.payload", "Payload")
+proto.fields = { f_length, f_cmd_id, f_flags, f_flag_compressed, f_flag_encrypted, f_flag_sequence, f_flag_crc32, f_flag_xxh32, f_flag_payload_codec, f_seq, f_checksum, f_payload_codec, f_compressor, f_message, f_payload }
+
+local HEADER_SIZE = 7
+local FLAG_COMPRESSED = 1
+local FLAG_ENCODING = 3
+local FLAG_SEQUENCE = 4
+local FLAG_CHECKSUM = 24
+local FLAG_PAYLOAD_CODEC = 32
+local JSON_PAYLOAD_CODEC = 1
+local BATCH_CMD_ID = 65535
+local has_protobuf, protobuf_dissector = pcall(Dissector.get, "protobuf")
+if not has_protobuf then
+    protobuf_dissector = nil
+end
+local has_json, json_dissector = pcall(Dissector.get, "json")
+if not has_json then
+    json_dissector = nil
+end
+
+local function get_frame_length(tvb, pinfo, offset)
+    return HEADER_SIZE + tvb(offset, 4):uint()
+end
+
+local function dissect_frame(tvb, pinfo, tree)
+    local length = tvb(0, 4):uint()
+    local cmd_id = tvb(4, 2):uint()
+    local flags = tvb(6, 1):uint()
+    local name = cmd_names[cmd_id] or ("Unknown(" .. cmd_id .. ")")
+    local full_name = cmd_full_names[cmd_id]
+
+    pinfo.cols.protocol = "protocmd"
+    pinfo.cols.info:append(name .. " ")
+
+    local subtree = tree:add(proto, tvb(0, HEADER_SIZE + length), "protocmd, " .. name)
+    subtree:add(f_length, tvb(0, 4))
+    subtree:add(f_cmd_id, tvb(4, 2))
+    local flags_tree = subtree:add(f_flags, tvb(6, 1))
+    flags_tree:add(f_flag_compressed, tvb(6, 1))
+    flags_tree:add(f_flag_encrypted, tvb(6, 1))
+    flags_tree:add(f_flag_sequence, tvb(6, 1))
+    flags_tree:add(f_flag_crc32, tvb(6, 1))
+    flags_tree:add(f_flag_xxh32, tvb(6, 1))
+    flags_tree:add(f_flag_payload_codec, tvb(6, 1))
+    if full_name then
+        subtree:add(f_message, full_name)
+    end
+
+    local offset = HEADER_SIZE
+    local size = length
+    if bit.band(flags, FLAG_SEQUENCE) ~= 0 and size >= 4 then
+        subtree:add(f_seq, tvb(offset, 4))
+        offset = offset + 4
+        size = size - 4
+    end
+    local payload_codec = 0
+    if bit.band(flags, FLAG_PAYLOAD_CODEC) ~= 0 and size >= 1 then
+        subtree:add(f_payload_codec, tvb(offset, 1))
+        payload_codec = tvb(offset, 1):uint()
+        offset = offset + 1
+        size = size - 1
+    end
+    if bit.band(flags, FLAG_CHECKSUM) ~= 0 and size >= 4 then
+        size = size - 4
+        subtree:add(f_checksum, tvb(offset + size, 4))
+    end
+
+    if size > 0 then
+        local payload = tvb(offset, size)
+        subtree:add(f_payload, payload)
+
+        local encoding = bit.band(flags, FLAG_ENCODING)
+        local message = nil
+        if encoding == 0 then
+            message = payload:tvb()
+        elseif encoding == FLAG_COMPRESSED and size > 1 then
+            subtree:add(f_compressor, tvb(offset, 1))
+            local ok, data = pcall(function()
+                return tvb(offset + 1, size - 1):uncompress("Decompressed payload")
+            end)
+            if ok then
+                message = data
+            end
+        end
+
+        -- The payload of a batch frame is a sequence of frames.
+        if cmd_id == BATCH_CMD_ID and message then
+            local item_offset = 0
+            while item_offset + HEADER_SIZE <= message:len() do
+                local item_size = HEADER_SIZE + message(item_offset, 4):uint()
+                if item_offset + item_size > message:len() then
+                    break
+                end
+                dissect_frame(message(item_offset, item_size):tvb(), pinfo, subtree)
+                item_offset = item_offset + item_size
+            end
+        elseif payload_codec == 0 and full_name and protobuf_dissector and message then
+            pinfo.private["pb_msg_type"] = "message," .. full_name
+            pcall(Dissector.call, protobuf_dissector, message, pinfo, subtree)
+        elseif payload_codec == JSON_PAYLOAD_CODEC and json_dissector and message then
+            pcall(Dissector.call, json_dissector, message, pinfo, subtree)
+        end
+    end
+    return HEADER_SIZE + length
+end
+
+function proto.dissector(tvb, pinfo, tree)
+    pinfo.cols.info:clear()
+    dissect_tcp_pdus(tvb, tree, HEADER_SIZE, get_frame_length, dissect_frame, true)
+    return tvb:len()
+end
+
+local tcp_port_table = DissectorTable.get("tcp.port")
+tcp_port_table:add(7777, proto)
+tcp_port_table:add(7778, proto)
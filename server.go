@@ -0,0 +1,207 @@
+package protocmd
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	ErrServerClosed = errors.New("protocmd: server closed")
+	ErrTooManyConns = errors.New("protocmd: too many connections")
+)
+
+// Server accepts connections and runs a Session for each of them.
+type Server struct {
+	// Addr is the TCP address listened by ListenAndServe.
+	Addr string
+
+	// Handler handles the messages of all sessions.
+	Handler Handler
+
+	// SessionConfig configures the sessions. Its OnClose is still called.
+	SessionConfig *SessionConfig
+
+	// MaxConns limits the number of concurrent sessions. Connections over
+	// the limit are closed immediately. If it's zero, there's no limit.
+	MaxConns int
+
+	// OnConnect is called before a new session starts.
+	OnConnect func(s *Session)
+
+	mu         sync.Mutex
+	listeners  map[net.Listener]struct{}
+	sessions   map[*Session]struct{}
+	inShutdown atomic.Bool
+}
+
+// ListenAndServe listens on srv.Addr and calls Serve.
+func (srv *Server) ListenAndServe() error {
+	if srv.inShutdown.Load() {
+		return ErrServerClosed
+	}
+
+	l, err := net.Listen("tcp", srv.Addr)
+	if err != nil {
+		return err
+	}
+	return srv.Serve(l)
+}
+
+// Serve accepts connections on l until Shutdown or Close is called, and
+// then returns ErrServerClosed. l is closed when Serve returns.
+func (srv *Server) Serve(l net.Listener) error {
+	if !srv.trackListener(l, true) {
+		l.Close()
+		return ErrServerClosed
+	}
+	defer srv.trackListener(l, false)
+	defer l.Close()
+
+	var delay time.Duration
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			if srv.inShutdown.Load() {
+				return ErrServerClosed
+			}
+
+			// Retry on errors like EMFILE, as net/http does.
+			if ne, ok := err.(interface{ Temporary() bool }); ok && ne.Temporary() {
+				if delay == 0 {
+					delay = 5 * time.Millisecond
+				} else if delay *= 2; delay > time.Second {
+					delay = time.Second
+				}
+				time.Sleep(delay)
+				continue
+			}
+			return err
+		}
+
+		delay = 0
+		srv.ServeTransport(NewStreamTransport(conn, srv.SessionConfig.codec()))
+	}
+}
+
+// ServeTransport starts a session over t managed by the server. It's used
+// by transports which don't accept connections through a net.Listener.
+// t is closed if the session can't be started.
+func (srv *Server) ServeTransport(t Transport) (*Session, error) {
+	config := &SessionConfig{}
+	if srv.SessionConfig != nil {
+		*config = *srv.SessionConfig
+	}
+	onClose := config.OnClose
+	config.OnClose = func(s *Session, err error) {
+		srv.mu.Lock()
+		delete(srv.sessions, s)
+		srv.mu.Unlock()
+
+		if onClose != nil {
+			onClose(s, err)
+		}
+	}
+
+	s := NewTransportSession(t, srv.Handler, config)
+
+	srv.mu.Lock()
+	if srv.inShutdown.Load() {
+		srv.mu.Unlock()
+		t.Close()
+		return nil, ErrServerClosed
+	}
+	if srv.MaxConns > 0 && len(srv.sessions) >= srv.MaxConns {
+		srv.mu.Unlock()
+		t.Close()
+		return nil, ErrTooManyConns
+	}
+	if srv.sessions == nil {
+		srv.sessions = make(map[*Session]struct{})
+	}
+	srv.sessions[s] = struct{}{}
+	srv.mu.Unlock()
+
+	if srv.OnConnect != nil {
+		srv.OnConnect(s)
+	}
+	s.Start()
+	return s, nil
+}
+
+// SessionCount returns the number of running sessions.
+func (srv *Server) SessionCount() int {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	return len(srv.sessions)
+}
+
+// Shutdown stops accepting connections, sends a Disconnect to every session,
+// closes them gracefully and waits for them to finish. If ctx is done first,
+// the remaining sessions are closed without writing their queued messages
+// and ctx.Err() is returned.
+func (srv *Server) Shutdown(ctx context.Context) error {
+	sessions := srv.stop()
+	for _, s := range sessions {
+		s.CloseWithReason(DisconnectShutdown, "server shutdown")
+	}
+
+	for _, s := range sessions {
+		select {
+		case <-s.Done():
+		case <-ctx.Done():
+			for _, s := range sessions {
+				s.abort()
+			}
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+// Close stops accepting connections and closes all sessions immediately.
+func (srv *Server) Close() error {
+	for _, s := range srv.stop() {
+		s.abort()
+	}
+	return nil
+}
+
+// stop closes the listeners and returns the running sessions.
+func (srv *Server) stop() []*Session {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+
+	srv.inShutdown.Store(true)
+	for l := range srv.listeners {
+		l.Close()
+	}
+
+	sessions := make([]*Session, 0, len(srv.sessions))
+	for s := range srv.sessions {
+		sessions = append(sessions, s)
+	}
+	return sessions
+}
+
+func (srv *Server) trackListener(l net.Listener, add bool) bool {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+
+	if !add {
+		delete(srv.listeners, l)
+		return true
+	}
+
+	if srv.inShutdown.Load() {
+		return false
+	}
+	if srv.listeners == nil {
+		srv.listeners = make(map[net.Listener]struct{})
+	}
+	srv.listeners[l] = struct{}{}
+	return true
+}
@@ -0,0 +1,188 @@
+package protocmd_test
+
+import (
+	"context"
+	"errors"
+	"github.com/stalomeow/protocmd"
+	"github.com/stalomeow/protocmd/examples/go/protos"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+// echoHandler sends every message back.
+var echoHandler = protocmd.HandlerFunc(func(s *protocmd.Session, msg protocmd.CmdMessage) {
+	s.Send(msg)
+})
+
+// startServer serves srv on a localhost port and returns the address.
+func startServer(t *testing.T, srv *protocmd.Server) string {
+	t.Helper()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	errCh := make(chan error, 1)
+	go func() { errCh <- srv.Serve(l) }()
+	t.Cleanup(func() {
+		srv.Close()
+		if err := <-errCh; err != protocmd.ErrServerClosed {
+			t.Errorf("Serve returned %v", err)
+		}
+	})
+	return l.Addr().String()
+}
+
+// collect returns a handler sending the received messages to a channel.
+func collect() (protocmd.Handler, <-chan protocmd.CmdMessage) {
+	ch := make(chan protocmd.CmdMessage, 100)
+	return protocmd.HandlerFunc(func(s *protocmd.Session, msg protocmd.CmdMessage) { ch <- msg }), ch
+}
+
+func receive(t *testing.T, ch <-chan protocmd.CmdMessage) protocmd.CmdMessage {
+	t.Helper()
+
+	select {
+	case msg := <-ch:
+		return msg
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for a message")
+		return nil
+	}
+}
+
+func TestServerEcho(t *testing.T) {
+	addr := startServer(t, &protocmd.Server{Handler: echoHandler})
+
+	h, ch := collect()
+	c := &protocmd.Client{Addr: addr, Handler: h}
+	if err := c.Connect(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	for _, uid := range []string{"a", "b", "c"} {
+		if err := c.Send(&protos.TestReq{Uid: uid}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, uid := range []string{"a", "b", "c"} {
+		req, ok := receive(t, ch).(*protos.TestReq)
+		if !ok || req.Uid != uid {
+			t.Fatalf("got %v, want TestReq %q", req, uid)
+		}
+	}
+}
+
+func TestServerMaxConns(t *testing.T) {
+	connected := make(chan *protocmd.Session, 1)
+	srv := &protocmd.Server{
+		Handler:   echoHandler,
+		MaxConns:  1,
+		OnConnect: func(s *protocmd.Session) { connected <- s },
+	}
+	addr := startServer(t, srv)
+
+	first, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer first.Close()
+	<-connected
+
+	second, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer second.Close()
+
+	second.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := second.Read(make([]byte, 1)); err != io.EOF {
+		t.Fatalf("expected the connection over the limit to be closed, got %v", err)
+	}
+	if n := srv.SessionCount(); n != 1 {
+		t.Fatalf("SessionCount() = %d, want 1", n)
+	}
+}
+
+func TestServerShutdown(t *testing.T) {
+	const count = 50
+
+	queued := make(chan struct{})
+	srv := &protocmd.Server{
+		Handler: protocmd.HandlerFunc(func(s *protocmd.Session, msg protocmd.CmdMessage) {
+			for i := 0; i < count; i++ {
+				if err := s.Send(&protos.TestRsp{RetCode: int32(i)}); err != nil {
+					t.Error(err)
+				}
+			}
+			close(queued)
+		}),
+		SessionConfig: &protocmd.SessionConfig{SendQueueSize: count},
+	}
+	addr := startServer(t, srv)
+
+	h, ch := collect()
+	c := &protocmd.Client{Addr: addr, Handler: h}
+	if err := c.Connect(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	c.Send(&protos.TestReq{Uid: "hi"})
+	<-queued
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := srv.Shutdown(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	// Every queued message must be written before the connection is closed.
+	for i := 0; i < count; i++ {
+		if rsp := receive(t, ch).(*protos.TestRsp); rsp.RetCode != int32(i) {
+			t.Fatalf("got RetCode %d, want %d", rsp.RetCode, i)
+		}
+	}
+	if err := srv.ListenAndServe(); err != protocmd.ErrServerClosed {
+		t.Fatalf("ListenAndServe after Shutdown returned %v", err)
+	}
+}
+
+func TestServerOverPipe(t *testing.T) {
+	srv := &protocmd.Server{Handler: echoHandler}
+	defer srv.Close()
+
+	h, ch := collect()
+	c := &protocmd.Client{
+		Handler: h,
+		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			client, server := net.Pipe()
+			if _, err := srv.ServeTransport(protocmd.NewStreamTransport(server, nil)); err != nil {
+				return nil, err
+			}
+			return client, nil
+		},
+	}
+	if err := c.Connect(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	c.Send(&protos.TestReq{Uid: "pipe"})
+	if req := receive(t, ch).(*protos.TestReq); req.Uid != "pipe" {
+		t.Fatalf("got %q, want %q", req.Uid, "pipe")
+	}
+
+	srv.Close()
+	if _, err := srv.ServeTransport(protocmd.NewStreamTransport(nopConn{}, nil)); !errors.Is(err, protocmd.ErrServerClosed) {
+		t.Fatalf("ServeTransport after Close returned %v", err)
+	}
+}
+
+type nopConn struct{ net.Conn }
+
+func (nopConn) Close() error { return nil }
@@ -0,0 +1,730 @@
+package protocmd
+
+import (
+	"context"
+	"crypto/ecdh"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// DefaultSendQueueSize is the default capacity of the outbound queue of a Session.
+	DefaultSendQueueSize = 64
+
+	// DefaultFlushTimeout is the default time a closing Session spends on
+	// writing pending messages.
+	DefaultFlushTimeout = 5 * time.Second
+
+	// DefaultMaxBatchSize is the default limit of the payload of a batch frame.
+	DefaultMaxBatchSize = 64 << 10
+)
+
+var (
+	ErrSessionClosed  = errors.New("protocmd: session closed")
+	ErrSendQueueFull  = errors.New("protocmd: send queue full")
+	ErrSessionStarted = errors.New("protocmd: session already started")
+
+	ErrEncryptionStarted = errors.New("protocmd: encryption already started")
+)
+
+// SessionConfig configures a Session. A nil *SessionConfig is valid and
+// uses the default values.
+type SessionConfig struct {
+	// Codec converts between messages and frames. If it's nil,
+	// DefaultFrameCodec is used.
+	Codec *FrameCodec
+
+	// SendQueueSize is the capacity of the outbound queue. If it's zero,
+	// DefaultSendQueueSize is used.
+	SendQueueSize int
+
+	// FlushTimeout limits the time Close spends on writing pending messages.
+	// If it's zero, DefaultFlushTimeout is used.
+	FlushTimeout time.Duration
+
+	// PingInterval is the interval of sending Pings to measure the latency
+	// and keep the connection alive. If it's zero, no Ping is sent.
+	PingInterval time.Duration
+
+	// IdleTimeout closes the session with ErrIdleTimeout if nothing is
+	// received for that long. If it's zero, idle sessions are kept open.
+	IdleTimeout time.Duration
+
+	// BatchWindow is how long the writer waits for more messages after
+	// taking one from the queue, to send them together in a batch frame.
+	// If it's zero, messages aren't batched.
+	BatchWindow time.Duration
+
+	// MaxBatchSize limits the payload of a batch frame before compression.
+	// If it's zero, DefaultMaxBatchSize is used.
+	MaxBatchSize int
+
+	// LazyDecode makes the session deliver a *LazyMessage for each received
+	// message, which is decoded only when it's accessed. Control messages
+	// are always decoded.
+	LazyDecode bool
+
+	// NewCipher creates the Cipher of each direction from a key derived by
+	// the key exchange. If it's nil, NewAESGCMCipher is used.
+	NewCipher func(key []byte) (Cipher, error)
+
+	// OnClose is called once after both loops of the session exit.
+	// err is nil if the session was closed by Close.
+	OnClose func(s *Session, err error)
+}
+
+func (c *SessionConfig) codec() *FrameCodec {
+	if c == nil || c.Codec == nil {
+		return DefaultFrameCodec
+	}
+	return c.Codec
+}
+
+func (c *SessionConfig) sendQueueSize() int {
+	if c == nil || c.SendQueueSize <= 0 {
+		return DefaultSendQueueSize
+	}
+	return c.SendQueueSize
+}
+
+func (c *SessionConfig) flushTimeout() time.Duration {
+	if c == nil || c.FlushTimeout <= 0 {
+		return DefaultFlushTimeout
+	}
+	return c.FlushTimeout
+}
+
+func (c *SessionConfig) pingInterval() time.Duration {
+	if c == nil {
+		return 0
+	}
+	return c.PingInterval
+}
+
+func (c *SessionConfig) idleTimeout() time.Duration {
+	if c == nil {
+		return 0
+	}
+	return c.IdleTimeout
+}
+
+func (c *SessionConfig) batchWindow() time.Duration {
+	if c == nil {
+		return 0
+	}
+	return c.BatchWindow
+}
+
+func (c *SessionConfig) maxBatchSize() int {
+	if c == nil || c.MaxBatchSize <= 0 {
+		return DefaultMaxBatchSize
+	}
+	return c.MaxBatchSize
+}
+
+func (c *SessionConfig) lazyDecode() bool {
+	return c != nil && c.LazyDecode
+}
+
+func (c *SessionConfig) newCipher(key []byte) (Cipher, error) {
+	if c == nil || c.NewCipher == nil {
+		return NewAESGCMCipher(key)
+	}
+	return c.NewCipher(key)
+}
+
+var lastSessionId atomic.Uint64
+
+// Session exchanges messages with a peer over a Transport.
+//
+// Inbound messages are decoded by a reader goroutine and passed to the
+// handler one by one, so the handler must not block for long. Outbound
+// messages are queued by Send and written by a writer goroutine, which
+// flushes the transport whenever the queue becomes empty.
+//
+// With BatchWindow set, the writer packs the messages queued within the
+// window into batch frames. Inbound batch frames are unpacked, and their
+// messages are passed to the handler in order like the others.
+//
+// Control messages are handled by the session itself: a Ping is replied with
+// a Pong, a Pong updates Latency, and a Disconnect closes the session with a
+// *DisconnectError.
+//
+// After StartEncryption is called on either side, the peers exchange keys
+// with KeyExchange messages, and every frame sent after a KeyExchange is
+// encrypted. Encryption needs frames to arrive in order.
+type Session struct {
+	id        uint64
+	transport Transport
+	handler   Handler
+	config    *SessionConfig
+	codec     *FrameCodec
+
+	sendCh chan CmdMessage
+	batch  []CmdMessage // used by the writer
+
+	// mu is held for reading while a message is being queued, so that the
+	// writer can wait for in-flight sends before draining the queue.
+	mu sync.RWMutex
+
+	lastRecv atomic.Int64 // Unix nanoseconds
+	latency  atomic.Int64
+
+	// kxMu guards kxKey, the private key of our KeyExchange. seal is set
+	// before keysReady is closed, and open is only used by the reader.
+	kxMu      sync.Mutex
+	kxKey     *ecdh.PrivateKey
+	seal      Cipher
+	open      Cipher
+	keysReady chan struct{}
+	sealing   bool // whether the writer has written our KeyExchange
+
+	started   atomic.Bool
+	closing   chan struct{}
+	closeOnce sync.Once
+	err       error
+	graceful  bool // whether the queued messages are written before closing
+	done      chan struct{}
+}
+
+// NewSession creates a Session sending frames over conn. Call Start to run it.
+// Inbound messages are passed to handler; if it's nil, they are dropped.
+func NewSession(conn net.Conn, handler Handler, config *SessionConfig) *Session {
+	return NewTransportSession(NewStreamTransport(conn, config.codec()), handler, config)
+}
+
+// NewTransportSession creates a Session over t. Call Start to run it.
+func NewTransportSession(t Transport, handler Handler, config *SessionConfig) *Session {
+	return &Session{
+		id:        lastSessionId.Add(1),
+		transport: t,
+		handler:   handler,
+		config:    config,
+		codec:     config.codec(),
+		sendCh:    make(chan CmdMessage, config.sendQueueSize()),
+		keysReady: make(chan struct{}),
+		closing:   make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+}
+
+// Id returns the process-wide unique id of the session.
+func (s *Session) Id() uint64 {
+	return s.id
+}
+
+func (s *Session) LocalAddr() net.Addr {
+	return s.transport.LocalAddr()
+}
+
+func (s *Session) RemoteAddr() net.Addr {
+	return s.transport.RemoteAddr()
+}
+
+// Start runs the reader and writer goroutines.
+func (s *Session) Start() error {
+	if !s.started.CompareAndSwap(false, true) {
+		return ErrSessionStarted
+	}
+
+	s.lastRecv.Store(time.Now().UnixNano())
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		s.readLoop()
+	}()
+	go func() {
+		defer wg.Done()
+		s.writeLoop()
+	}()
+
+	if s.config.pingInterval() > 0 || s.config.idleTimeout() > 0 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.keepAliveLoop()
+		}()
+	}
+
+	go func() {
+		wg.Wait()
+		if s.config != nil && s.config.OnClose != nil {
+			s.config.OnClose(s, s.err)
+		}
+		close(s.done)
+	}()
+	return nil
+}
+
+// Send queues msg without blocking. It returns ErrSendQueueFull if the queue
+// is full, and ErrSessionClosed if the session is closing. A message queued
+// before Close is called is still written.
+func (s *Session) Send(msg CmdMessage) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	select {
+	case <-s.closing:
+		return ErrSessionClosed
+	default:
+	}
+
+	select {
+	case s.sendCh <- msg:
+		return nil
+	case <-s.closing:
+		return ErrSessionClosed
+	default:
+		return ErrSendQueueFull
+	}
+}
+
+// SendContext queues msg, waiting for room in the queue until ctx is done.
+func (s *Session) SendContext(ctx context.Context, msg CmdMessage) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	select {
+	case <-s.closing:
+		return ErrSessionClosed
+	default:
+	}
+
+	select {
+	case s.sendCh <- msg:
+		return nil
+	case <-s.closing:
+		return ErrSessionClosed
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Close stops accepting messages, writes the queued ones and then closes
+// the transport. It doesn't wait for the session to finish; use Done for that.
+// It's safe to call Close from a handler.
+func (s *Session) Close() error {
+	s.shutdown(nil, true)
+	if !s.started.Load() {
+		// Nobody else is going to close the transport.
+		return s.transport.Close()
+	}
+	return nil
+}
+
+// CloseWithReason sends a Disconnect to the peer and then closes the session
+// like Close.
+func (s *Session) CloseWithReason(code uint32, reason string) error {
+	s.Send(&Disconnect{Code: code, Reason: reason})
+	return s.Close()
+}
+
+// StartEncryption sends a KeyExchange to the peer, which answers with its own
+// one automatically. The messages sent after StartEncryption returns are
+// encrypted; the writer holds them until the answer arrives. It returns
+// ErrEncryptionStarted if either peer has started the key exchange.
+func (s *Session) StartEncryption() error {
+	s.kxMu.Lock()
+	defer s.kxMu.Unlock()
+
+	if s.kxKey != nil {
+		return ErrEncryptionStarted
+	}
+	key, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return err
+	}
+	if err := s.Send(&KeyExchange{PublicKey: key.PublicKey().Bytes()}); err != nil {
+		return err
+	}
+	s.kxKey = key
+	return nil
+}
+
+// Encrypted reports whether the keys of both directions are established.
+func (s *Session) Encrypted() bool {
+	select {
+	case <-s.keysReady:
+		return true
+	default:
+		return false
+	}
+}
+
+// Latency returns the round-trip time measured by the last Pong, or zero
+// if no Pong has been received.
+func (s *Session) Latency() time.Duration {
+	return time.Duration(s.latency.Load())
+}
+
+// Done returns a channel closed after the session finishes.
+func (s *Session) Done() <-chan struct{} {
+	return s.done
+}
+
+// Err returns the error that closed the session. It's nil if the session
+// is running or was closed by Close. It's io.EOF if the peer closed the
+// connection, and a *DisconnectError if the peer sent a Disconnect.
+func (s *Session) Err() error {
+	select {
+	case <-s.closing:
+		return s.err
+	default:
+		return nil
+	}
+}
+
+// close closes the session because of err, dropping the queued messages.
+func (s *Session) close(err error) {
+	s.shutdown(err, false)
+}
+
+func (s *Session) shutdown(err error, graceful bool) {
+	s.closeOnce.Do(func() {
+		s.err = err
+		s.graceful = graceful
+		close(s.closing)
+	})
+}
+
+func (s *Session) readLoop() {
+	for {
+		f, err := s.transport.ReadFrame()
+		if err != nil {
+			s.close(err) // no effect if the transport was closed by the writer
+			return
+		}
+
+		s.lastRecv.Store(time.Now().UnixNano())
+
+		if s.open != nil {
+			if err := OpenFrame(s.open, f); err != nil {
+				s.close(err)
+				return
+			}
+		}
+
+		if f.CmdId == BatchCmdId {
+			items, err := s.codec.splitBatch(f)
+			if err != nil {
+				s.close(err)
+				return
+			}
+			for _, item := range items {
+				if err := s.receive(item); err != nil {
+					s.close(err)
+					return
+				}
+			}
+			continue
+		}
+
+		if err := s.receive(f); err != nil {
+			s.close(err)
+			return
+		}
+	}
+}
+
+// receive decodes f and dispatches the message.
+func (s *Session) receive(f *Frame) error {
+	var msg CmdMessage
+	var err error
+	if s.config.lazyDecode() && !IsControlCmdId(f.CmdId) {
+		msg, err = s.codec.DecodeLazy(f)
+	} else {
+		msg, err = s.codec.Decode(f)
+	}
+	if err != nil {
+		return err
+	}
+	s.dispatch(msg)
+	return nil
+}
+
+func (s *Session) dispatch(msg CmdMessage) {
+	if IsControlCmdId(msg.CmdId()) {
+		s.handleControl(msg)
+	} else if s.handler != nil {
+		s.handler.ServeCmd(s, msg)
+	}
+}
+
+func (s *Session) handleControl(msg CmdMessage) {
+	switch m := msg.(type) {
+	case *Ping:
+		s.Send(&Pong{Time: m.Time})
+	case *Pong:
+		if rtt := time.Now().UnixNano() - m.Time; rtt >= 0 {
+			s.latency.Store(rtt)
+		}
+	case *Disconnect:
+		s.close(&DisconnectError{Code: m.Code, Reason: m.Reason})
+	case *KeyExchange:
+		if err := s.handleKeyExchange(m); err != nil {
+			s.close(err)
+		}
+	}
+}
+
+// handleKeyExchange derives the keys from the KeyExchange of the peer,
+// answering it if we haven't sent ours.
+func (s *Session) handleKeyExchange(m *KeyExchange) error {
+	s.kxMu.Lock()
+	defer s.kxMu.Unlock()
+
+	if s.open != nil {
+		return ErrEncryptionStarted
+	}
+
+	key := s.kxKey
+	answer := key == nil
+	if answer {
+		var err error
+		if key, err = ecdh.X25519().GenerateKey(rand.Reader); err != nil {
+			return err
+		}
+	}
+
+	sendKey, recvKey, err := DeriveSessionKeys(key, m.PublicKey)
+	if err != nil {
+		return err
+	}
+	if s.seal, err = s.config.newCipher(sendKey); err != nil {
+		return err
+	}
+	if s.open, err = s.config.newCipher(recvKey); err != nil {
+		return err
+	}
+	s.kxKey = key
+	close(s.keysReady)
+
+	if answer {
+		// The writer starts sealing after writing this message.
+		return s.SendContext(context.Background(), &KeyExchange{PublicKey: key.PublicKey().Bytes()})
+	}
+	return nil
+}
+
+// keepAliveLoop sends Pings and closes the session when it's idle.
+func (s *Session) keepAliveLoop() {
+	pingInterval := s.config.pingInterval()
+	idleTimeout := s.config.idleTimeout()
+
+	period := pingInterval
+	if idleTimeout > 0 && (period <= 0 || idleTimeout/4 < period) {
+		period = idleTimeout / 4
+	}
+	ticker := time.NewTicker(period)
+	defer ticker.Stop()
+
+	var lastPing time.Time
+	for {
+		select {
+		case now := <-ticker.C:
+			if idleTimeout > 0 && now.Sub(time.Unix(0, s.lastRecv.Load())) >= idleTimeout {
+				s.Send(&Disconnect{Code: DisconnectIdleTimeout, Reason: "idle timeout"})
+				s.shutdown(ErrIdleTimeout, true)
+				return
+			}
+			if pingInterval > 0 && now.Sub(lastPing) >= pingInterval {
+				s.Send(&Ping{Time: now.UnixNano()})
+				lastPing = now
+			}
+		case <-s.closing:
+			return
+		}
+	}
+}
+
+func (s *Session) writeLoop() {
+	defer s.transport.Close()
+
+	for {
+		select {
+		case msg := <-s.sendCh:
+			var err error
+			if s.config.batchWindow() > 0 {
+				err = s.writeBatched(msg)
+			} else if err = s.write(msg); err == nil {
+				err = s.writeQueued()
+			}
+			if err != nil {
+				s.close(err)
+				return
+			}
+			if err := s.transport.Flush(); err != nil {
+				s.close(fmt.Errorf("failed to flush: %w", err))
+				return
+			}
+		case <-s.closing:
+			if s.graceful {
+				s.flushOnClose()
+			}
+			return
+		}
+	}
+}
+
+// flushOnClose writes the queued messages when the session is closed by Close.
+func (s *Session) flushOnClose() {
+	// Wait for the in-flight sends. No message can be queued after this.
+	s.mu.Lock()
+	s.mu.Unlock()
+
+	// A peer which stops reading must not block the session forever.
+	timer := time.AfterFunc(s.config.flushTimeout(), func() { s.transport.Close() })
+	defer timer.Stop()
+
+	if err := s.writeQueued(); err == nil {
+		s.transport.Flush()
+	}
+}
+
+// writeQueued writes the messages in the queue without blocking.
+func (s *Session) writeQueued() error {
+	for {
+		select {
+		case msg := <-s.sendCh:
+			if err := s.write(msg); err != nil {
+				return err
+			}
+		default:
+			return nil
+		}
+	}
+}
+
+// writeBatched writes first and the messages queued within the batch window.
+func (s *Session) writeBatched(first CmdMessage) error {
+	timer := time.NewTimer(s.config.batchWindow())
+	defer timer.Stop()
+
+	batch := append(s.batch[:0], first)
+	defer func() {
+		clear(batch)
+		s.batch = batch[:0]
+	}()
+
+	// A KeyExchange ends the batch and is written in a frame of its own,
+	// as the messages after it are encrypted.
+collect:
+	for len(batch) < cap(s.sendCh) {
+		if _, ok := batch[len(batch)-1].(*KeyExchange); ok {
+			break
+		}
+		select {
+		case msg := <-s.sendCh:
+			batch = append(batch, msg)
+		case <-timer.C:
+			break collect
+		case <-s.closing:
+			break collect
+		}
+	}
+
+	// Split the batch by MaxBatchSize.
+	maxSize := s.config.maxBatchSize()
+	var payload []byte
+	start := 0
+	for i, msg := range batch {
+		if _, ok := msg.(*KeyExchange); ok {
+			if i > start {
+				if err := s.writeBatch(payload, batch[start:i]); err != nil {
+					return err
+				}
+			}
+			return s.write(msg)
+		}
+
+		n := len(payload)
+		var err error
+		if payload, err = s.codec.appendBatchItem(payload, msg); err != nil {
+			return err
+		}
+		if len(payload) > maxSize && i > start {
+			if err := s.writeBatch(payload[:n], batch[start:i]); err != nil {
+				return err
+			}
+			payload = append(payload[:0], payload[n:]...)
+			start = i
+		}
+	}
+	return s.writeBatch(payload, batch[start:])
+}
+
+// writeBatch writes msgs, whose batch payload is given, in a batch frame.
+// A single message is written in a frame of its own.
+func (s *Session) writeBatch(payload []byte, msgs []CmdMessage) error {
+	if len(msgs) == 1 {
+		return s.write(msgs[0])
+	}
+
+	f, err := s.codec.batchFrame(payload)
+	if err != nil {
+		return err
+	}
+	return s.writeFrame(f)
+}
+
+func (s *Session) write(msg CmdMessage) error {
+	f, err := s.codec.Encode(msg)
+	if err != nil {
+		return err
+	}
+	if err := s.writeFrame(f); err != nil {
+		return err
+	}
+	if _, ok := msg.(*KeyExchange); ok {
+		s.sealing = true
+	}
+	return nil
+}
+
+func (s *Session) writeFrame(f *Frame) error {
+	if s.sealing {
+		if err := s.waitKeys(); err != nil {
+			return err
+		}
+		if err := SealFrame(s.seal, f); err != nil {
+			return err
+		}
+	}
+	if err := s.transport.WriteFrame(f); err != nil {
+		return fmt.Errorf("failed to write cmdId '%v': %w", f.CmdId, err)
+	}
+	return nil
+}
+
+// waitKeys waits for the KeyExchange of the peer.
+func (s *Session) waitKeys() error {
+	select {
+	case <-s.keysReady:
+		return nil
+	default:
+	}
+
+	// The peer can't answer a KeyExchange still in our buffer.
+	if err := s.transport.Flush(); err != nil {
+		return fmt.Errorf("failed to flush: %w", err)
+	}
+	select {
+	case <-s.keysReady:
+		return nil
+	case <-s.closing:
+		return ErrSessionClosed
+	}
+}
+
+// abort closes the session without writing the queued messages.
+func (s *Session) abort() {
+	s.close(ErrSessionClosed)
+	s.transport.Close()
+}
@@ -0,0 +1,167 @@
+package protocmd_test
+
+import (
+	"errors"
+	"github.com/stalomeow/protocmd"
+	"github.com/stalomeow/protocmd/examples/go/protos"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+// pipeSessions starts two sessions connected by net.Pipe.
+func pipeSessions(t *testing.T, h1, h2 protocmd.Handler, c1, c2 *protocmd.SessionConfig) (*protocmd.Session, *protocmd.Session) {
+	t.Helper()
+
+	a, b := net.Pipe()
+	s1 := protocmd.NewSession(a, h1, c1)
+	s2 := protocmd.NewSession(b, h2, c2)
+	s1.Start()
+	s2.Start()
+	t.Cleanup(func() {
+		s1.Close()
+		s2.Close()
+	})
+	return s1, s2
+}
+
+func waitDone(t *testing.T, s *protocmd.Session) {
+	t.Helper()
+
+	select {
+	case <-s.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the session to finish")
+	}
+}
+
+func TestSessionSendReceive(t *testing.T) {
+	d := protocmd.NewDispatcher()
+	reqs, rsps := make(chan string, 10), make(chan int32, 10)
+	d.HandleFunc(protos.TestReq_CmdId, func(s *protocmd.Session, msg protocmd.CmdMessage) {
+		uid := msg.(*protos.TestReq).Uid
+		reqs <- uid
+		s.Send(&protos.TestRsp{RetCode: int32(len(uid))})
+	})
+	h := protocmd.HandlerFunc(func(s *protocmd.Session, msg protocmd.CmdMessage) {
+		rsps <- msg.(*protos.TestRsp).RetCode
+	})
+	client, _ := pipeSessions(t, h, d, nil, nil)
+
+	uids := []string{"a", "bb", "ccc"}
+	for _, uid := range uids {
+		if err := client.Send(&protos.TestReq{Uid: uid}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, uid := range uids {
+		select {
+		case got := <-reqs:
+			if got != uid {
+				t.Fatalf("server received %v, want %v", got, uid)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out waiting for a request")
+		}
+		select {
+		case got := <-rsps:
+			if got != int32(len(uid)) {
+				t.Fatalf("client received %v, want %v", got, len(uid))
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out waiting for a response")
+		}
+	}
+}
+
+func TestSessionGracefulClose(t *testing.T) {
+	h, ch := collect()
+	closed := make(chan error, 1)
+	config := &protocmd.SessionConfig{OnClose: func(s *protocmd.Session, err error) { closed <- err }}
+	s1, s2 := pipeSessions(t, nil, h, nil, config)
+
+	// Messages queued before Close are still delivered.
+	for i := 0; i < 50; i++ {
+		if err := s1.Send(&protos.TestRsp{RetCode: int32(i)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	s1.Close()
+
+	for i := 0; i < 50; i++ {
+		if got := receive(t, ch).(*protos.TestRsp).RetCode; got != int32(i) {
+			t.Fatalf("got %v, want %v", got, i)
+		}
+	}
+	// The peer sees the connection closed.
+	waitDone(t, s1)
+	waitDone(t, s2)
+	if s1.Err() != nil || s2.Err() != io.EOF {
+		t.Fatalf("sessions ended with %v and %v, want nil and io.EOF", s1.Err(), s2.Err())
+	}
+	if err := <-closed; err != io.EOF {
+		t.Fatalf("OnClose got %v, want io.EOF", err)
+	}
+}
+
+func TestSessionSendAfterClose(t *testing.T) {
+	s, _ := pipeSessions(t, nil, nil, &protocmd.SessionConfig{SendQueueSize: 1}, nil)
+
+	s.Close()
+	if err := s.Send(&protos.TestReq{}); err != protocmd.ErrSessionClosed {
+		t.Fatalf("Send after Close returned %v", err)
+	}
+	waitDone(t, s)
+	if err := s.Err(); err != nil {
+		t.Fatalf("Err() = %v after Close", err)
+	}
+}
+
+func TestSessionPing(t *testing.T) {
+	received := make(chan protocmd.CmdMessage, 10)
+	h := protocmd.HandlerFunc(func(s *protocmd.Session, msg protocmd.CmdMessage) { received <- msg })
+	s, _ := pipeSessions(t, nil, h, &protocmd.SessionConfig{PingInterval: 10 * time.Millisecond}, nil)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for s.Latency() == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for a Pong")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	// Control messages never reach the handler.
+	select {
+	case msg := <-received:
+		t.Fatalf("handler received %v", protocmd.CmdString(msg))
+	default:
+	}
+}
+
+func TestSessionIdleTimeout(t *testing.T) {
+	idle, peer := pipeSessions(t, nil, nil, &protocmd.SessionConfig{IdleTimeout: 50 * time.Millisecond}, nil)
+
+	waitDone(t, idle)
+	if err := idle.Err(); err != protocmd.ErrIdleTimeout {
+		t.Fatalf("Err() = %v, want %v", err, protocmd.ErrIdleTimeout)
+	}
+
+	waitDone(t, peer)
+	var de *protocmd.DisconnectError
+	if !errors.As(peer.Err(), &de) || de.Code != protocmd.DisconnectIdleTimeout {
+		t.Fatalf("peer Err() = %v, want a DisconnectError of idle timeout", peer.Err())
+	}
+}
+
+func TestSessionCloseWithReason(t *testing.T) {
+	s1, s2 := pipeSessions(t, nil, nil, nil, nil)
+
+	s1.CloseWithReason(1001, "kicked")
+	waitDone(t, s2)
+
+	var de *protocmd.DisconnectError
+	if !errors.As(s2.Err(), &de) || de.Code != 1001 || de.Reason != "kicked" {
+		t.Fatalf("Err() = %v, want a DisconnectError of 1001 kicked", s2.Err())
+	}
+}
@@ -0,0 +1,48 @@
+package protocmd
+
+import (
+	"bufio"
+	"net"
+)
+
+// Transport carries frames between two peers. ReadFrame is called by one
+// goroutine, and WriteFrame and Flush by another one. Close may be called
+// at any time and unblocks both of them.
+type Transport interface {
+	ReadFrame() (*Frame, error)
+
+	// WriteFrame may buffer f until Flush is called.
+	WriteFrame(f *Frame) error
+	Flush() error
+
+	Close() error
+	LocalAddr() net.Addr
+	RemoteAddr() net.Addr
+}
+
+// streamTransport sends frames over a stream-oriented connection such as TCP.
+type streamTransport struct {
+	conn net.Conn
+	r    *FrameReader
+	bw   *bufio.Writer
+	w    *FrameWriter
+}
+
+// NewStreamTransport creates a Transport sending frames over conn.
+// If codec is nil, DefaultFrameCodec is used.
+func NewStreamTransport(conn net.Conn, codec *FrameCodec) Transport {
+	bw := bufio.NewWriter(conn)
+	return &streamTransport{
+		conn: conn,
+		r:    NewFrameReader(conn, codec),
+		bw:   bw,
+		w:    NewFrameWriter(bw, codec),
+	}
+}
+
+func (t *streamTransport) ReadFrame() (*Frame, error) { return t.r.ReadFrame() }
+func (t *streamTransport) WriteFrame(f *Frame) error  { return t.w.WriteFrame(f) }
+func (t *streamTransport) Flush() error               { return t.bw.Flush() }
+func (t *streamTransport) Close() error               { return t.conn.Close() }
+func (t *streamTransport) LocalAddr() net.Addr        { return t.conn.LocalAddr() }
+func (t *streamTransport) RemoteAddr() net.Addr       { return t.conn.RemoteAddr() }
@@ -0,0 +1,515 @@
+package udp
+
+import (
+	"encoding/binary"
+	"errors"
+	"github.com/stalomeow/protocmd"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	kindUnreliable = 0
+	kindReliable   = 1
+	kindAck        = 2
+	kindClose      = 3
+)
+
+const (
+	unreliableHeaderSize = 1
+	reliableHeaderSize   = 6
+)
+
+const (
+	DefaultResendInterval = 100 * time.Millisecond
+	DefaultMaxResends     = 20
+	DefaultMaxUnacked     = 256
+)
+
+const (
+	// maxRecvQueue limits the frames waiting for ReadFrame. Unreliable frames
+	// over the limit are dropped, and reliable ones are left unacknowledged.
+	maxRecvQueue = 1024
+
+	// maxReorderWindow limits how far ahead of the next expected frame of a
+	// channel a reliable frame is buffered.
+	maxReorderWindow = 1024
+
+	maxDatagramSize = 64 << 10
+)
+
+var (
+	ErrPeerLost            = errors.New("udp: peer lost")
+	ErrSequenceUnsupported = errors.New("udp: Codec.Sequence isn't supported")
+)
+
+// Config configures a Conn. A nil *Config is valid and uses the default values.
+type Config struct {
+	// MTU limits the size of datagrams. If it's zero, DefaultMTU is used.
+	MTU int
+
+	// Codec serializes frames. If it's nil, protocmd.DefaultFrameCodec is used.
+	// Its Sequence must be false: channel 0 may lose and reorder frames, and
+	// reliable channels number their frames by themselves.
+	Codec *protocmd.FrameCodec
+
+	// Channel returns the channel of the frames with cmdId. Channel 0 is
+	// unreliable and unordered; each other channel is reliable and ordered.
+	// If it's nil, every frame is sent on channel 0.
+	Channel func(cmdId uint16) uint8
+
+	// ResendInterval is the time to wait for an acknowledgement before
+	// resending. If it's zero, DefaultResendInterval is used.
+	ResendInterval time.Duration
+
+	// MaxResends is the number of resends without any progress after which
+	// the peer is considered lost. If it's zero, DefaultMaxResends is used.
+	MaxResends int
+
+	// MaxUnacked limits the frames of a reliable channel which are queued
+	// or waiting for an acknowledgement. WriteFrame blocks while the channel
+	// is full. If it's zero, DefaultMaxUnacked is used. It can't exceed the
+	// number of frames the peer buffers ahead, 1024.
+	MaxUnacked int
+}
+
+func (c *Config) validate() error {
+	if c != nil && c.Codec != nil && c.Codec.Sequence {
+		return ErrSequenceUnsupported
+	}
+	return nil
+}
+
+func (c *Config) datagramCodec() *DatagramCodec {
+	if c == nil {
+		return nil
+	}
+	return &DatagramCodec{MTU: c.MTU, Codec: c.Codec}
+}
+
+func (c *Config) channel(cmdId uint16) uint8 {
+	if c == nil || c.Channel == nil {
+		return 0
+	}
+	return c.Channel(cmdId)
+}
+
+func (c *Config) resendInterval() time.Duration {
+	if c == nil || c.ResendInterval <= 0 {
+		return DefaultResendInterval
+	}
+	return c.ResendInterval
+}
+
+func (c *Config) maxResends() int {
+	if c == nil || c.MaxResends <= 0 {
+		return DefaultMaxResends
+	}
+	return c.MaxResends
+}
+
+func (c *Config) maxUnacked() int {
+	if c == nil || c.MaxUnacked <= 0 {
+		return DefaultMaxUnacked
+	}
+	return min(c.MaxUnacked, maxReorderWindow)
+}
+
+type sendChannel struct {
+	// unacked holds the frames from the oldest unacknowledged one, with
+	// consecutive seqs. The first `sent` of them have been sent.
+	unacked []*protocmd.Frame
+	seq     uint32 // seq of unacked[0]
+	sent    int
+	sentAt  time.Time
+	resends int
+}
+
+type recvChannel struct {
+	next  uint32
+	ahead map[uint32]*protocmd.Frame
+}
+
+// Conn is a protocmd.Transport exchanging frames with a single peer over UDP.
+type Conn struct {
+	pc      net.PacketConn
+	raddr   net.Addr
+	config  *Config
+	dc      *DatagramCodec
+	onClose func(c *Conn)
+
+	mu         sync.Mutex
+	unreliable []*protocmd.Frame
+	send       map[uint8]*sendChannel
+	recv       map[uint8]*recvChannel
+	queue      []*protocmd.Frame
+	readable   chan struct{}
+	acked      chan struct{} // closed and replaced on each acknowledgement
+
+	closeOnce sync.Once
+	closed    chan struct{}
+	err       error
+}
+
+// Dial creates a Conn exchanging frames with the UDP address addr. It
+// returns ErrSequenceUnsupported if config.Codec enables Sequence.
+func Dial(addr string, config *Config) (*Conn, error) {
+	if err := config.validate(); err != nil {
+		return nil, err
+	}
+	raddr, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	pc, err := net.ListenPacket("udp", ":0")
+	if err != nil {
+		return nil, err
+	}
+	return NewConn(pc, raddr, config)
+}
+
+// NewConn creates a Conn exchanging frames with raddr over pc. Datagrams
+// from other addresses are ignored. pc is closed with the Conn. It returns
+// ErrSequenceUnsupported if config.Codec enables Sequence.
+func NewConn(pc net.PacketConn, raddr net.Addr, config *Config) (*Conn, error) {
+	if err := config.validate(); err != nil {
+		return nil, err
+	}
+	c := newConn(pc, raddr, config, func(*Conn) { pc.Close() })
+
+	go func() {
+		buf := make([]byte, maxDatagramSize)
+		for {
+			n, addr, err := pc.ReadFrom(buf)
+			if err != nil {
+				if errors.Is(err, net.ErrClosed) {
+					c.closeWithError(err)
+					return
+				}
+				continue // e.g. ICMP port unreachable
+			}
+			if addr.String() == raddr.String() {
+				c.input(append([]byte(nil), buf[:n]...))
+			}
+		}
+	}()
+	return c, nil
+}
+
+func newConn(pc net.PacketConn, raddr net.Addr, config *Config, onClose func(c *Conn)) *Conn {
+	c := &Conn{
+		pc:       pc,
+		raddr:    raddr,
+		config:   config,
+		dc:       config.datagramCodec(),
+		onClose:  onClose,
+		send:     make(map[uint8]*sendChannel),
+		recv:     make(map[uint8]*recvChannel),
+		readable: make(chan struct{}, 1),
+		acked:    make(chan struct{}),
+		closed:   make(chan struct{}),
+	}
+	go c.resendLoop()
+	return c
+}
+
+func (c *Conn) LocalAddr() net.Addr  { return c.pc.LocalAddr() }
+func (c *Conn) RemoteAddr() net.Addr { return c.raddr }
+
+// ReadFrame returns the next received frame. Frames of a reliable channel
+// are returned in order.
+func (c *Conn) ReadFrame() (*protocmd.Frame, error) {
+	for {
+		c.mu.Lock()
+		if len(c.queue) > 0 {
+			f := c.queue[0]
+			c.queue[0] = nil
+			c.queue = c.queue[1:]
+			c.mu.Unlock()
+			return f, nil
+		}
+		c.mu.Unlock()
+
+		select {
+		case <-c.readable:
+		case <-c.closed:
+			return nil, c.err
+		}
+	}
+}
+
+// WriteFrame queues f until Flush is called. It returns
+// protocmd.ErrFrameTooLarge if f doesn't fit in a datagram. If the reliable
+// channel of f has Config.MaxUnacked frames, WriteFrame sends them and
+// blocks until the peer acknowledges some.
+func (c *Conn) WriteFrame(f *protocmd.Frame) error {
+	ch := c.config.channel(f.CmdId)
+
+	headerSize := unreliableHeaderSize
+	if ch != 0 {
+		headerSize = reliableHeaderSize
+	}
+	if headerSize+c.dc.codec().EncodedSize(f) > c.dc.mtu() {
+		return protocmd.ErrFrameTooLarge
+	}
+
+	for {
+		c.mu.Lock()
+		select {
+		case <-c.closed:
+			c.mu.Unlock()
+			return c.err
+		default:
+		}
+
+		if ch == 0 {
+			c.unreliable = append(c.unreliable, f)
+			c.mu.Unlock()
+			return nil
+		}
+		sc := c.sendChannel(ch)
+		if len(sc.unacked) < c.config.maxUnacked() {
+			sc.unacked = append(sc.unacked, f)
+			c.mu.Unlock()
+			return nil
+		}
+
+		// The window is full. Nothing is acknowledged until it's sent.
+		if err := c.flushChannel(ch, sc, time.Now()); err != nil {
+			c.mu.Unlock()
+			return err
+		}
+		acked := c.acked
+		c.mu.Unlock()
+
+		select {
+		case <-acked:
+		case <-c.closed:
+			return c.err
+		}
+	}
+}
+
+// Flush sends the queued frames.
+func (c *Conn) Flush() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	frames := c.unreliable
+	c.unreliable = nil
+	err := c.dc.pack(frames, func(int) []byte { return []byte{kindUnreliable} }, c.write)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	for ch, sc := range c.send {
+		if err := c.flushChannel(ch, sc, now); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// flushChannel sends the queued frames of a reliable channel. c.mu must be held.
+func (c *Conn) flushChannel(ch uint8, sc *sendChannel, now time.Time) error {
+	if sc.sent == len(sc.unacked) {
+		return nil
+	}
+	if err := c.sendReliable(ch, sc, sc.sent, len(sc.unacked)); err != nil {
+		return err
+	}
+	if sc.sent == 0 {
+		sc.sentAt = now
+	}
+	sc.sent = len(sc.unacked)
+	return nil
+}
+
+// Close tells the peer that the connection is closed and releases it.
+func (c *Conn) Close() error {
+	select {
+	case <-c.closed:
+		return nil
+	default:
+	}
+
+	c.mu.Lock()
+	c.write([]byte{kindClose})
+	c.mu.Unlock()
+
+	c.closeWithError(net.ErrClosed)
+	return nil
+}
+
+func (c *Conn) closeWithError(err error) {
+	c.closeOnce.Do(func() {
+		c.err = err
+		close(c.closed)
+		c.onClose(c)
+	})
+}
+
+// input handles a datagram from the peer.
+func (c *Conn) input(p []byte) {
+	if len(p) == 0 {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	switch p[0] {
+	case kindUnreliable:
+		frames, err := c.dc.Unpack(p[unreliableHeaderSize:])
+		if err != nil {
+			return
+		}
+		for _, f := range frames {
+			if len(c.queue) < maxRecvQueue {
+				c.queue = append(c.queue, f)
+			}
+		}
+		c.notify()
+
+	case kindReliable:
+		if len(p) < reliableHeaderSize {
+			return
+		}
+		ch, seq := p[1], binary.BigEndian.Uint32(p[2:])
+		frames, err := c.dc.Unpack(p[reliableHeaderSize:])
+		if err != nil {
+			return
+		}
+
+		rc := c.recvChannel(ch)
+		for i, f := range frames {
+			s := seq + uint32(i)
+			if d := int32(s - rc.next); d >= 0 && d < maxReorderWindow {
+				rc.ahead[s] = f
+			}
+		}
+		for len(c.queue) < maxRecvQueue {
+			f, ok := rc.ahead[rc.next]
+			if !ok {
+				break
+			}
+			delete(rc.ahead, rc.next)
+			c.queue = append(c.queue, f)
+			rc.next++
+		}
+		c.notify()
+
+		ack := []byte{kindAck, ch, 0, 0, 0, 0}
+		binary.BigEndian.PutUint32(ack[2:], rc.next-1)
+		c.write(ack)
+
+	case kindAck:
+		if len(p) < reliableHeaderSize {
+			return
+		}
+		sc, ok := c.send[p[1]]
+		if !ok {
+			return
+		}
+		n := int32(binary.BigEndian.Uint32(p[2:]) - sc.seq + 1)
+		if n <= 0 || int(n) > sc.sent {
+			return // stale or bogus
+		}
+		clear(sc.unacked[:n])
+		sc.unacked = sc.unacked[n:]
+		sc.seq += uint32(n)
+		sc.sent -= int(n)
+		sc.sentAt = time.Now()
+		sc.resends = 0
+		close(c.acked)
+		c.acked = make(chan struct{})
+
+	case kindClose:
+		go c.closeWithError(io.EOF)
+	}
+}
+
+func (c *Conn) resendLoop() {
+	interval := c.config.resendInterval()
+	ticker := time.NewTicker(interval / 2)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-c.closed:
+			return
+		}
+
+		if !c.resend(interval) {
+			c.closeWithError(ErrPeerLost)
+			return
+		}
+	}
+}
+
+// resend resends the overdue frames. It returns false if the peer is lost.
+func (c *Conn) resend(interval time.Duration) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	for ch, sc := range c.send {
+		if sc.sent == 0 || now.Sub(sc.sentAt) < interval {
+			continue
+		}
+		if sc.resends >= c.config.maxResends() {
+			return false
+		}
+
+		// Acknowledgements are cumulative, so everything sent is resent, but
+		// not the frames queued after it.
+		c.sendReliable(ch, sc, 0, sc.sent)
+		sc.sentAt = now
+		sc.resends++
+	}
+	return true
+}
+
+// sendReliable sends sc.unacked[from:to] of a channel. c.mu must be held.
+func (c *Conn) sendReliable(ch uint8, sc *sendChannel, from, to int) error {
+	prefix := func(i int) []byte {
+		b := []byte{kindReliable, ch, 0, 0, 0, 0}
+		binary.BigEndian.PutUint32(b[2:], sc.seq+uint32(from+i))
+		return b
+	}
+	return c.dc.pack(sc.unacked[from:to], prefix, c.write)
+}
+
+func (c *Conn) write(b []byte) error {
+	_, err := c.pc.WriteTo(b, c.raddr)
+	return err
+}
+
+func (c *Conn) notify() {
+	select {
+	case c.readable <- struct{}{}:
+	default:
+	}
+}
+
+func (c *Conn) sendChannel(ch uint8) *sendChannel {
+	sc, ok := c.send[ch]
+	if !ok {
+		sc = &sendChannel{}
+		c.send[ch] = sc
+	}
+	return sc
+}
+
+func (c *Conn) recvChannel(ch uint8) *recvChannel {
+	rc, ok := c.recv[ch]
+	if !ok {
+		rc = &recvChannel{ahead: make(map[uint32]*protocmd.Frame)}
+		c.recv[ch] = rc
+	}
+	return rc
+}
@@ -0,0 +1,115 @@
+// Package udp carries cmd frames over UDP.
+//
+// DatagramCodec packs frames into datagrams no larger than an MTU; frames are
+// never split across datagrams. Conn builds a protocmd.Transport on top of
+// it, with an optional reliability layer: frames can be sent on numbered
+// channels, where they are acknowledged, resent when lost and delivered in
+// order. Channel 0 stays unreliable and unordered, which suits state that is
+// sent again anyway, such as movement updates.
+//
+// Each datagram sent by Conn starts with a packet kind:
+//
+//	0 (unreliable)  frames...
+//	1 (reliable)    channel (1), seq of the first frame (4), frames...
+//	2 (ack)         channel (1), seq (4), acknowledging every frame up to seq
+//	3 (close)
+package udp
+
+import (
+	"errors"
+	"github.com/stalomeow/protocmd"
+)
+
+// DefaultMTU is the default size limit of datagrams. It's small enough to
+// avoid IP fragmentation on most paths.
+const DefaultMTU = 1200
+
+var ErrPartialFrame = errors.New("udp: datagram ends in the middle of a frame")
+
+// DatagramCodec packs frames into datagrams. A nil *DatagramCodec is valid
+// and uses the default values.
+type DatagramCodec struct {
+	// MTU limits the size of datagrams. If it's zero, DefaultMTU is used.
+	MTU int
+
+	// Codec serializes frames. If it's nil, protocmd.DefaultFrameCodec is used.
+	Codec *protocmd.FrameCodec
+}
+
+func (c *DatagramCodec) mtu() int {
+	if c == nil || c.MTU <= 0 {
+		return DefaultMTU
+	}
+	return c.MTU
+}
+
+func (c *DatagramCodec) codec() *protocmd.FrameCodec {
+	if c == nil {
+		return nil
+	}
+	return c.Codec
+}
+
+// Pack packs frames into as few datagrams as possible, keeping their order.
+// It returns protocmd.ErrFrameTooLarge if a frame doesn't fit in a datagram.
+func (c *DatagramCodec) Pack(frames []*protocmd.Frame) ([][]byte, error) {
+	var datagrams [][]byte
+	err := c.pack(frames, func(i int) []byte { return nil }, func(d []byte) error {
+		datagrams = append(datagrams, d)
+		return nil
+	})
+	return datagrams, err
+}
+
+// pack packs frames into datagrams starting with prefix(i), where i is the
+// index of the first frame in the datagram, and passes them to emit.
+func (c *DatagramCodec) pack(frames []*protocmd.Frame, prefix func(i int) []byte, emit func(d []byte) error) error {
+	mtu := c.mtu()
+
+	var d []byte
+	empty := 0 // length of d without frames
+	for i, f := range frames {
+		if d == nil {
+			d = append(make([]byte, 0, mtu), prefix(i)...)
+			empty = len(d)
+		}
+
+		size := c.codec().EncodedSize(f)
+		if empty+size > mtu {
+			return protocmd.ErrFrameTooLarge
+		}
+		if len(d)+size > mtu {
+			if err := emit(d); err != nil {
+				return err
+			}
+			d = append(make([]byte, 0, mtu), prefix(i)...)
+		}
+
+		var err error
+		if d, err = c.codec().AppendFrame(d, f); err != nil {
+			return err
+		}
+	}
+
+	if d != nil {
+		return emit(d)
+	}
+	return nil
+}
+
+// Unpack parses the frames in a datagram. The payloads of the frames alias datagram.
+func (c *DatagramCodec) Unpack(datagram []byte) ([]*protocmd.Frame, error) {
+	var frames []*protocmd.Frame
+	for len(datagram) > 0 {
+		f, n, err := c.codec().ParseFrame(datagram)
+		if err == protocmd.ErrShortFrame {
+			return nil, ErrPartialFrame
+		}
+		if err != nil {
+			return nil, err
+		}
+		frames = append(frames, f)
+		datagram = datagram[n:]
+	}
+	return frames, nil
+}
@@ -0,0 +1,160 @@
+package udp
+
+import (
+	"errors"
+	"github.com/stalomeow/protocmd"
+	"net"
+	"sync"
+)
+
+// acceptBacklog limits the new peers waiting for Accept. Datagrams from
+// further new peers are dropped.
+const acceptBacklog = 128
+
+// Listener demultiplexes the datagrams received on a socket into a Conn per
+// peer address.
+type Listener struct {
+	pc     net.PacketConn
+	config *Config
+
+	mu     sync.Mutex
+	conns  map[string]*Conn
+	accept chan *Conn
+
+	closeOnce sync.Once
+	closed    chan struct{}
+}
+
+// Listen listens on the UDP address addr. It returns ErrSequenceUnsupported
+// if config.Codec enables Sequence.
+func Listen(addr string, config *Config) (*Listener, error) {
+	if err := config.validate(); err != nil {
+		return nil, err
+	}
+	pc, err := net.ListenPacket("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	return NewListener(pc, config)
+}
+
+// NewListener creates a Listener over pc. pc is closed with the Listener.
+// It returns ErrSequenceUnsupported if config.Codec enables Sequence.
+func NewListener(pc net.PacketConn, config *Config) (*Listener, error) {
+	if err := config.validate(); err != nil {
+		return nil, err
+	}
+	l := &Listener{
+		pc:     pc,
+		config: config,
+		conns:  make(map[string]*Conn),
+		accept: make(chan *Conn, acceptBacklog),
+		closed: make(chan struct{}),
+	}
+	go l.readLoop()
+	return l, nil
+}
+
+// Accept waits for a new peer and returns its Conn.
+func (l *Listener) Accept() (*Conn, error) {
+	select {
+	case c := <-l.accept:
+		return c, nil
+	case <-l.closed:
+		return nil, net.ErrClosed
+	}
+}
+
+// Close closes the socket and every Conn of the Listener.
+func (l *Listener) Close() error {
+	var err error
+	l.closeOnce.Do(func() {
+		close(l.closed)
+		err = l.pc.Close()
+
+		l.mu.Lock()
+		conns := make([]*Conn, 0, len(l.conns))
+		for _, c := range l.conns {
+			conns = append(conns, c)
+		}
+		l.mu.Unlock()
+
+		for _, c := range conns {
+			c.closeWithError(net.ErrClosed)
+		}
+	})
+	return err
+}
+
+func (l *Listener) Addr() net.Addr {
+	return l.pc.LocalAddr()
+}
+
+func (l *Listener) readLoop() {
+	buf := make([]byte, maxDatagramSize)
+	for {
+		n, addr, err := l.pc.ReadFrom(buf)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				l.Close()
+				return
+			}
+			continue
+		}
+		if n == 0 {
+			continue
+		}
+
+		if c := l.conn(addr, buf[0]); c != nil {
+			c.input(append([]byte(nil), buf[:n]...))
+		}
+	}
+}
+
+// conn returns the Conn of addr, creating it for a new peer unless kind
+// closes the connection.
+func (l *Listener) conn(addr net.Addr, kind byte) *Conn {
+	key := addr.String()
+
+	l.mu.Lock()
+	if c, ok := l.conns[key]; ok {
+		l.mu.Unlock()
+		return c
+	}
+	if kind == kindClose {
+		l.mu.Unlock()
+		return nil
+	}
+
+	c := newConn(l.pc, addr, l.config, func(c *Conn) { l.remove(key, c) })
+	select {
+	case l.accept <- c:
+		l.conns[key] = c
+		l.mu.Unlock()
+		return c
+	default:
+		l.mu.Unlock()
+		c.closeWithError(net.ErrClosed) // the backlog is full
+		return nil
+	}
+}
+
+func (l *Listener) remove(key string, c *Conn) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.conns[key] == c {
+		delete(l.conns, key)
+	}
+}
+
+// Serve runs a session of srv for each peer accepted by l until l is closed.
+// Close l along with shutting down srv.
+func Serve(srv *protocmd.Server, l *Listener) error {
+	for {
+		c, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		srv.ServeTransport(c)
+	}
+}
@@ -0,0 +1,318 @@
+package udp_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"github.com/stalomeow/protocmd"
+	"github.com/stalomeow/protocmd/examples/go/protos"
+	"github.com/stalomeow/protocmd/udp"
+	"io"
+	"net"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestDatagramCodec(t *testing.T) {
+	codec := &udp.DatagramCodec{MTU: 1000}
+
+	frames := make([]*protocmd.Frame, 10)
+	for i := range frames {
+		frames[i] = &protocmd.Frame{CmdId: uint16(i), Payload: bytes.Repeat([]byte{byte(i)}, 300)}
+	}
+
+	// 3 frames of 307 bytes fit in a datagram.
+	datagrams, err := codec.Pack(frames)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(datagrams) != 4 {
+		t.Fatalf("got %d datagrams, want 4", len(datagrams))
+	}
+
+	var got []*protocmd.Frame
+	for _, d := range datagrams {
+		if len(d) > 1000 {
+			t.Fatalf("datagram of %d bytes exceeds the MTU", len(d))
+		}
+		fs, err := codec.Unpack(d)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, fs...)
+	}
+	for i, f := range got {
+		if f.CmdId != frames[i].CmdId || !bytes.Equal(f.Payload, frames[i].Payload) {
+			t.Fatalf("frame %d does not match", i)
+		}
+	}
+
+	if _, err := codec.Pack([]*protocmd.Frame{{Payload: make([]byte, 1000)}}); err != protocmd.ErrFrameTooLarge {
+		t.Fatalf("Pack of a large frame returned %v", err)
+	}
+	if _, err := codec.Unpack(datagrams[0][:500]); err != udp.ErrPartialFrame {
+		t.Fatalf("Unpack of a partial frame returned %v", err)
+	}
+}
+
+func TestDatagramCodecFrameOverhead(t *testing.T) {
+	// Each frame takes 7 + 4 (seq) + 1 (payload codec) + 4 (checksum) bytes besides its payload.
+	codec := &udp.DatagramCodec{MTU: 100, Codec: &protocmd.FrameCodec{Checksum: protocmd.ChecksumCRC32}}
+	frame := func(n int) *protocmd.Frame {
+		return &protocmd.Frame{CmdId: 1, Seq: 1, Codec: protocmd.JSONPayloadCodecId, Payload: bytes.Repeat([]byte{'1'}, n)}
+	}
+
+	datagrams, err := codec.Pack([]*protocmd.Frame{frame(84)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(datagrams) != 1 || len(datagrams[0]) != 100 {
+		t.Fatalf("got %d datagrams of %d bytes, want 1 of 100", len(datagrams), len(datagrams[0]))
+	}
+	if _, err := codec.Pack([]*protocmd.Frame{frame(85)}); err != protocmd.ErrFrameTooLarge {
+		t.Fatalf("Pack of a frame over the MTU returned %v", err)
+	}
+
+	datagrams, err = codec.Pack([]*protocmd.Frame{frame(34), frame(35)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(datagrams) != 2 {
+		t.Fatalf("got %d datagrams, want 2", len(datagrams))
+	}
+
+	seq := &udp.Config{Codec: &protocmd.FrameCodec{Sequence: true}}
+	if _, err := udp.Dial("127.0.0.1:1", seq); err != udp.ErrSequenceUnsupported {
+		t.Fatalf("Dial with Sequence returned %v", err)
+	}
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pc.Close()
+	if _, err := udp.NewConn(pc, pc.LocalAddr(), seq); err != udp.ErrSequenceUnsupported {
+		t.Fatalf("NewConn with Sequence returned %v", err)
+	}
+	if _, err := udp.NewListener(pc, seq); err != udp.ErrSequenceUnsupported {
+		t.Fatalf("NewListener with Sequence returned %v", err)
+	}
+}
+
+// lossyConn drops every third datagram it sends.
+type lossyConn struct {
+	net.PacketConn
+	n atomic.Int32
+}
+
+func (c *lossyConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	if c.n.Add(1)%3 == 0 {
+		return len(b), nil
+	}
+	return c.PacketConn.WriteTo(b, addr)
+}
+
+func listenLossy(t *testing.T) *lossyConn {
+	t.Helper()
+
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &lossyConn{PacketConn: pc}
+}
+
+func TestReliableChannel(t *testing.T) {
+	const count = 200
+
+	config := &udp.Config{
+		ResendInterval: 20 * time.Millisecond,
+		Channel: func(cmdId uint16) uint8 {
+			if cmdId == protos.TestReq_CmdId {
+				return 1
+			}
+			return 0
+		},
+	}
+
+	srv := &protocmd.Server{
+		Handler: protocmd.HandlerFunc(func(s *protocmd.Session, msg protocmd.CmdMessage) {
+			s.Send(msg)
+		}),
+		SessionConfig: &protocmd.SessionConfig{SendQueueSize: count},
+	}
+	l, err := udp.NewListener(listenLossy(t), config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go udp.Serve(srv, l)
+
+	received := make(chan protocmd.CmdMessage, count)
+	conn, err := udp.NewConn(listenLossy(t), l.Addr(), config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := protocmd.NewTransportSession(conn, protocmd.HandlerFunc(func(s *protocmd.Session, msg protocmd.CmdMessage) {
+		received <- msg
+	}), &protocmd.SessionConfig{SendQueueSize: count})
+	s.Start()
+
+	for i := 0; i < count; i++ {
+		if err := s.SendContext(context.Background(), &protos.TestReq{Uid: strconv.Itoa(i)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// A third of the datagrams are lost, but every message arrives in order.
+	for i := 0; i < count; i++ {
+		select {
+		case msg := <-received:
+			if uid := msg.(*protos.TestReq).Uid; uid != strconv.Itoa(i) {
+				t.Fatalf("got uid %s, want %d", uid, i)
+			}
+		case <-time.After(10 * time.Second):
+			t.Fatalf("timed out waiting for message %d", i)
+		}
+	}
+
+	s.Close()
+	<-s.Done()
+}
+
+func TestUnreliableChannel(t *testing.T) {
+	l, err := udp.Listen("127.0.0.1:0", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	c, err := udp.Dial(l.Addr().String(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	f := &protocmd.Frame{CmdId: 1, Payload: []byte("move")}
+	if err := c.WriteFrame(f); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	peer, err := l.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := peer.ReadFrame()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.CmdId != 1 || string(got.Payload) != "move" {
+		t.Fatalf("got %v, want %v", got, f)
+	}
+
+	if err := c.WriteFrame(&protocmd.Frame{Payload: make([]byte, udp.DefaultMTU)}); err != protocmd.ErrFrameTooLarge {
+		t.Fatalf("WriteFrame of a large frame returned %v", err)
+	}
+
+	c.Close()
+	if _, err := peer.ReadFrame(); err != io.EOF {
+		t.Fatalf("ReadFrame after the peer closed returned %v, want io.EOF", err)
+	}
+}
+
+func TestPeerLost(t *testing.T) {
+	// Nobody listens on the address after the socket is closed.
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := pc.LocalAddr().String()
+	pc.Close()
+
+	c, err := udp.Dial(addr, &udp.Config{
+		ResendInterval: 10 * time.Millisecond,
+		MaxResends:     3,
+		Channel:        func(uint16) uint8 { return 1 },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	c.WriteFrame(&protocmd.Frame{CmdId: 1})
+	c.Flush()
+
+	if _, err := c.ReadFrame(); err != udp.ErrPeerLost {
+		t.Fatalf("ReadFrame returned %v, want %v", err, udp.ErrPeerLost)
+	}
+}
+
+func TestSendWindow(t *testing.T) {
+	peer, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer peer.Close()
+
+	c, err := udp.Dial(peer.LocalAddr().String(), &udp.Config{
+		ResendInterval: 20 * time.Millisecond,
+		MaxUnacked:     4,
+		Channel:        func(uint16) uint8 { return 1 },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	for i := 0; i < 4; i++ {
+		if err := c.WriteFrame(&protocmd.Frame{CmdId: uint16(i)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The fifth frame waits for an acknowledgement.
+	written := make(chan error, 1)
+	go func() { written <- c.WriteFrame(&protocmd.Frame{CmdId: 4}) }()
+
+	// The full window is sent, and resent, but never more.
+	var addr net.Addr
+	buf := make([]byte, 2048)
+	for i := 0; i < 3; i++ {
+		peer.SetReadDeadline(time.Now().Add(5 * time.Second))
+		n, from, err := peer.ReadFrom(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		addr = from
+
+		frames, err := (&udp.DatagramCodec{}).Unpack(buf[6:n])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if seq := binary.BigEndian.Uint32(buf[2:]); buf[0] != 1 || int(seq)+len(frames) > 4 {
+			t.Fatalf("got %d frames from seq %d, want no more than the window", len(frames), seq)
+		}
+	}
+	select {
+	case err := <-written:
+		t.Fatalf("WriteFrame returned %v with the window full", err)
+	default:
+	}
+
+	// Acknowledging the first frame makes room for the fifth.
+	if _, err := peer.WriteTo([]byte{2, 1, 0, 0, 0, 0}, addr); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err := <-written:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for WriteFrame")
+	}
+}
@@ -0,0 +1,319 @@
+// Package websocket carries cmd frames over WebSocket connections, so that
+// clients which can't open raw TCP connections, such as browsers, can talk
+// to a protocmd.Server.
+//
+// Each binary WebSocket message carries exactly one frame. Text messages are
+// rejected. Only the parts of RFC 6455 needed for that are implemented; no
+// extension is negotiated.
+package websocket
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"github.com/stalomeow/protocmd"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	opContinuation = 0x0
+	opText         = 0x1
+	opBinary       = 0x2
+	opClose        = 0x8
+	opPing         = 0x9
+	opPong         = 0xa
+)
+
+// closeTimeout limits the time spent on sending the close message.
+const closeTimeout = time.Second
+
+var (
+	ErrProtocol       = errors.New("websocket: protocol error")
+	ErrTextMessage    = errors.New("websocket: unexpected text message")
+	ErrInvalidMessage = errors.New("websocket: message is not a single frame")
+)
+
+// conn is a protocmd.Transport over a WebSocket connection.
+type conn struct {
+	conn   net.Conn
+	br     *bufio.Reader
+	codec  *protocmd.FrameCodec
+	client bool // clients mask the messages they send
+	seq    protocmd.Sequencer
+
+	wmu       sync.Mutex
+	bw        *bufio.Writer
+	wbuf      []byte
+	mbuf      []byte
+	closeSent bool
+}
+
+func newConn(c net.Conn, br *bufio.Reader, codec *protocmd.FrameCodec, client bool) *conn {
+	return &conn{
+		conn:   c,
+		br:     br,
+		codec:  codec,
+		client: client,
+		bw:     bufio.NewWriter(c),
+	}
+}
+
+func (c *conn) ReadFrame() (*protocmd.Frame, error) {
+	msg, err := c.readMessage()
+	if err != nil {
+		return nil, err
+	}
+
+	f, n, err := c.codec.ParseFrame(msg)
+	if err == protocmd.ErrShortFrame || (err == nil && n != len(msg)) {
+		return nil, ErrInvalidMessage
+	}
+	if err != nil {
+		return nil, err
+	}
+	if c.sequence() {
+		if err := c.seq.Check(f); err != nil {
+			return nil, err
+		}
+	}
+	return f, nil
+}
+
+func (c *conn) WriteFrame(f *protocmd.Frame) error {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+
+	if c.sequence() {
+		stamped := *f
+		c.seq.Stamp(&stamped)
+		f = &stamped
+	}
+
+	buf, err := c.codec.AppendFrame(c.wbuf[:0], f)
+	if err != nil {
+		return err
+	}
+	c.wbuf = buf
+	return c.writeMessage(opBinary, buf)
+}
+
+func (c *conn) sequence() bool {
+	return c.codec != nil && c.codec.Sequence
+}
+
+func (c *conn) Flush() error {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	return c.bw.Flush()
+}
+
+// Close sends a close message and closes the connection.
+func (c *conn) Close() error {
+	// Unblock a pending write, and don't wait for a peer which stops reading.
+	c.conn.SetWriteDeadline(time.Now().Add(closeTimeout))
+	c.writeControl(opClose, closePayload(1000))
+	return c.conn.Close()
+}
+
+func (c *conn) LocalAddr() net.Addr  { return c.conn.LocalAddr() }
+func (c *conn) RemoteAddr() net.Addr { return c.conn.RemoteAddr() }
+
+// readMessage reads a binary message, handling the control messages before it.
+// It returns io.EOF after the peer sends a close message.
+func (c *conn) readMessage() ([]byte, error) {
+	maxSize := protocmd.FrameHeaderSize + codecMaxFrameSize(c.codec)
+
+	var msg []byte
+	started := false
+	for {
+		fin, op, payload, err := c.readFragment(maxSize - len(msg))
+		if err != nil {
+			if started {
+				return nil, truncated(err)
+			}
+			return nil, err
+		}
+
+		switch op {
+		case opPing:
+			if err := c.writeControl(opPong, payload); err != nil {
+				return nil, err
+			}
+			continue
+		case opPong:
+			continue
+		case opClose:
+			code := uint16(1000)
+			if len(payload) >= 2 {
+				code = binary.BigEndian.Uint16(payload)
+			}
+			c.writeControl(opClose, closePayload(code))
+			return nil, io.EOF
+		case opText:
+			return nil, ErrTextMessage
+		case opBinary:
+			if started {
+				return nil, ErrProtocol
+			}
+			started = true
+		case opContinuation:
+			if !started {
+				return nil, ErrProtocol
+			}
+		default:
+			return nil, ErrProtocol
+		}
+
+		msg = append(msg, payload...)
+		if fin {
+			return msg, nil
+		}
+	}
+}
+
+// readFragment reads a WebSocket frame whose payload is at most maxSize bytes
+// unless it's a control frame.
+func (c *conn) readFragment(maxSize int) (fin bool, op byte, payload []byte, err error) {
+	var header [2]byte
+	if _, err = io.ReadFull(c.br, header[:]); err != nil {
+		return
+	}
+
+	fin = header[0]&0x80 != 0
+	op = header[0] & 0x0f
+	masked := header[1]&0x80 != 0
+	length := uint64(header[1] & 0x7f)
+
+	if header[0]&0x70 != 0 || masked == c.client {
+		// No extension defines RSV bits, and only clients mask their frames.
+		return false, 0, nil, ErrProtocol
+	}
+
+	switch length {
+	case 126:
+		var ext [2]byte
+		if _, err = io.ReadFull(c.br, ext[:]); err != nil {
+			return false, 0, nil, truncated(err)
+		}
+		length = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err = io.ReadFull(c.br, ext[:]); err != nil {
+			return false, 0, nil, truncated(err)
+		}
+		length = binary.BigEndian.Uint64(ext[:])
+	}
+
+	if op >= opClose {
+		if !fin || length > 125 {
+			return false, 0, nil, ErrProtocol
+		}
+	} else if length > uint64(maxSize) {
+		return false, 0, nil, protocmd.ErrFrameTooLarge
+	}
+
+	var mask [4]byte
+	if masked {
+		if _, err = io.ReadFull(c.br, mask[:]); err != nil {
+			return false, 0, nil, truncated(err)
+		}
+	}
+
+	payload = make([]byte, length)
+	if _, err = io.ReadFull(c.br, payload); err != nil {
+		return false, 0, nil, truncated(err)
+	}
+	if masked {
+		maskBytes(payload, mask)
+	}
+	return fin, op, payload, nil
+}
+
+// writeControl writes and flushes a control message. Nothing is written
+// after a close message.
+func (c *conn) writeControl(op byte, payload []byte) error {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+
+	if c.closeSent {
+		return nil
+	}
+	if op == opClose {
+		c.closeSent = true
+	}
+
+	if err := c.writeMessage(op, payload); err != nil {
+		return err
+	}
+	return c.bw.Flush()
+}
+
+// writeMessage writes an unfragmented message. c.wmu must be held.
+func (c *conn) writeMessage(op byte, payload []byte) error {
+	if c.closeSent && op != opClose {
+		return net.ErrClosed
+	}
+
+	var header [14]byte
+	header[0] = 0x80 | op
+	n := 2
+	switch {
+	case len(payload) <= 125:
+		header[1] = byte(len(payload))
+	case len(payload) <= 0xffff:
+		header[1] = 126
+		binary.BigEndian.PutUint16(header[2:], uint16(len(payload)))
+		n += 2
+	default:
+		header[1] = 127
+		binary.BigEndian.PutUint64(header[2:], uint64(len(payload)))
+		n += 8
+	}
+
+	if c.client {
+		var mask [4]byte
+		if _, err := rand.Read(mask[:]); err != nil {
+			return err
+		}
+		header[1] |= 0x80
+		n += copy(header[n:], mask[:])
+
+		c.mbuf = append(c.mbuf[:0], payload...)
+		maskBytes(c.mbuf, mask)
+		payload = c.mbuf
+	}
+
+	if _, err := c.bw.Write(header[:n]); err != nil {
+		return err
+	}
+	_, err := c.bw.Write(payload)
+	return err
+}
+
+func maskBytes(b []byte, mask [4]byte) {
+	for i := range b {
+		b[i] ^= mask[i&3]
+	}
+}
+
+func closePayload(code uint16) []byte {
+	return binary.BigEndian.AppendUint16(nil, code)
+}
+
+func codecMaxFrameSize(codec *protocmd.FrameCodec) int {
+	if codec == nil || codec.MaxFrameSize <= 0 {
+		return protocmd.DefaultMaxFrameSize
+	}
+	return codec.MaxFrameSize
+}
+
+func truncated(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
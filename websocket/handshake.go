@@ -0,0 +1,201 @@
+package websocket
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/tls"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"github.com/stalomeow/protocmd"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Subprotocol is offered by Dial and accepted by Upgrade if the client asks for it.
+const Subprotocol = "protocmd"
+
+const acceptGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+var ErrBadHandshake = errors.New("websocket: bad handshake")
+
+// Upgrade upgrades an HTTP request to a WebSocket connection and returns a
+// transport over it. If the request isn't a valid WebSocket handshake, an
+// error response is written. The Origin header isn't checked; wrap the
+// handler doing the upgrade if it matters.
+//
+// codec is used to serialize frames; if it's nil, protocmd.DefaultFrameCodec is used.
+func Upgrade(w http.ResponseWriter, r *http.Request, codec *protocmd.FrameCodec) (protocmd.Transport, error) {
+	if r.Method != http.MethodGet ||
+		!headerContainsToken(r.Header, "Connection", "upgrade") ||
+		!headerContainsToken(r.Header, "Upgrade", "websocket") {
+		http.Error(w, "not a websocket handshake", http.StatusBadRequest)
+		return nil, ErrBadHandshake
+	}
+	if r.Header.Get("Sec-WebSocket-Version") != "13" {
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		http.Error(w, "unsupported websocket version", http.StatusUpgradeRequired)
+		return nil, ErrBadHandshake
+	}
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if key == "" {
+		http.Error(w, "missing Sec-WebSocket-Key", http.StatusBadRequest)
+		return nil, ErrBadHandshake
+	}
+
+	hj, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "websocket is not supported", http.StatusInternalServerError)
+		return nil, errors.New("websocket: response does not implement http.Hijacker")
+	}
+	c, brw, err := hj.Hijack()
+	if err != nil {
+		return nil, fmt.Errorf("failed to hijack the connection: %w", err)
+	}
+	c.SetDeadline(time.Time{}) // clear the timeouts of the http.Server
+
+	var b strings.Builder
+	b.WriteString("HTTP/1.1 101 Switching Protocols\r\n")
+	b.WriteString("Upgrade: websocket\r\n")
+	b.WriteString("Connection: Upgrade\r\n")
+	b.WriteString("Sec-WebSocket-Accept: " + acceptKey(key) + "\r\n")
+	if headerContainsToken(r.Header, "Sec-WebSocket-Protocol", Subprotocol) {
+		b.WriteString("Sec-WebSocket-Protocol: " + Subprotocol + "\r\n")
+	}
+	b.WriteString("\r\n")
+
+	if _, err := c.Write([]byte(b.String())); err != nil {
+		c.Close()
+		return nil, err
+	}
+	return newConn(c, brw.Reader, codec, false), nil
+}
+
+// Handler returns an http.Handler running a session of srv for each
+// WebSocket connection. The frames are serialized with the codec in
+// srv.SessionConfig.
+func Handler(srv *protocmd.Server) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var codec *protocmd.FrameCodec
+		if srv.SessionConfig != nil {
+			codec = srv.SessionConfig.Codec
+		}
+
+		t, err := Upgrade(w, r, codec)
+		if err != nil {
+			return
+		}
+		srv.ServeTransport(t) // closes t on failure
+	})
+}
+
+// Dial connects to a ws:// or wss:// URL and returns a transport over the
+// WebSocket connection. codec is used to serialize frames; if it's nil,
+// protocmd.DefaultFrameCodec is used.
+func Dial(ctx context.Context, rawURL string, codec *protocmd.FrameCodec) (protocmd.Transport, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+
+	var port string
+	switch u.Scheme {
+	case "ws":
+		port = "80"
+	case "wss":
+		port = "443"
+	default:
+		return nil, fmt.Errorf("unsupported scheme '%s'", u.Scheme)
+	}
+	addr := u.Host
+	if u.Port() == "" {
+		addr = net.JoinHostPort(u.Hostname(), port)
+	}
+
+	var d net.Dialer
+	c, err := d.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	// Abort the handshake when ctx is done.
+	stop := context.AfterFunc(ctx, func() { c.SetDeadline(time.Now()) })
+	t, err := handshake(ctx, c, u, codec)
+	if !stop() && err == nil {
+		err = ctx.Err()
+	}
+	if err != nil {
+		c.Close()
+		return nil, err
+	}
+	c.SetDeadline(time.Time{})
+	return t, nil
+}
+
+func handshake(ctx context.Context, c net.Conn, u *url.URL, codec *protocmd.FrameCodec) (*conn, error) {
+	if u.Scheme == "wss" {
+		tc := tls.Client(c, &tls.Config{ServerName: u.Hostname()})
+		if err := tc.HandshakeContext(ctx); err != nil {
+			return nil, err
+		}
+		c = tc
+	}
+
+	var nonce [16]byte
+	if _, err := rand.Read(nonce[:]); err != nil {
+		return nil, err
+	}
+	key := base64.StdEncoding.EncodeToString(nonce[:])
+
+	req := &http.Request{
+		Method: http.MethodGet,
+		URL:    &url.URL{Path: u.Path, RawQuery: u.RawQuery},
+		Host:   u.Host,
+		Header: http.Header{
+			"Upgrade":                {"websocket"},
+			"Connection":             {"Upgrade"},
+			"Sec-WebSocket-Key":      {key},
+			"Sec-WebSocket-Version":  {"13"},
+			"Sec-WebSocket-Protocol": {Subprotocol},
+		},
+	}
+	if req.URL.Path == "" {
+		req.URL.Path = "/"
+	}
+	if err := req.Write(c); err != nil {
+		return nil, err
+	}
+
+	br := bufio.NewReader(c)
+	rsp, err := http.ReadResponse(br, req)
+	if err != nil {
+		return nil, err
+	}
+	if rsp.StatusCode != http.StatusSwitchingProtocols ||
+		!headerContainsToken(rsp.Header, "Upgrade", "websocket") ||
+		rsp.Header.Get("Sec-WebSocket-Accept") != acceptKey(key) {
+		return nil, fmt.Errorf("%w: %s", ErrBadHandshake, rsp.Status)
+	}
+	return newConn(c, br, codec, true), nil
+}
+
+func acceptKey(key string) string {
+	h := sha1.Sum([]byte(key + acceptGUID))
+	return base64.StdEncoding.EncodeToString(h[:])
+}
+
+func headerContainsToken(h http.Header, name, token string) bool {
+	for _, v := range h.Values(name) {
+		for _, t := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(t), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,164 @@
+package websocket_test
+
+import (
+	"bufio"
+	"context"
+	"github.com/stalomeow/protocmd"
+	"github.com/stalomeow/protocmd/examples/go/protos"
+	"github.com/stalomeow/protocmd/websocket"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func startServer(t *testing.T) (*protocmd.Server, string) {
+	t.Helper()
+
+	srv := &protocmd.Server{
+		Handler: protocmd.HandlerFunc(func(s *protocmd.Session, msg protocmd.CmdMessage) {
+			s.Send(msg)
+		}),
+	}
+	hs := httptest.NewServer(websocket.Handler(srv))
+	t.Cleanup(func() {
+		srv.Close()
+		hs.Close()
+	})
+	return srv, "ws" + strings.TrimPrefix(hs.URL, "http")
+}
+
+func TestEcho(t *testing.T) {
+	srv, url := startServer(t)
+
+	received := make(chan protocmd.CmdMessage, 10)
+	c := &protocmd.Client{
+		Handler: protocmd.HandlerFunc(func(s *protocmd.Session, msg protocmd.CmdMessage) { received <- msg }),
+		DialTransport: func(ctx context.Context) (protocmd.Transport, error) {
+			return websocket.Dial(ctx, url, nil)
+		},
+	}
+	if err := c.Connect(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	// The large message is sent with a 64-bit length.
+	uids := []string{"a", strings.Repeat("b", 300), strings.Repeat("c", 70000)}
+	for _, uid := range uids {
+		if err := c.Send(&protos.TestReq{Uid: uid}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, uid := range uids {
+		select {
+		case msg := <-received:
+			if req := msg.(*protos.TestReq); req.Uid != uid {
+				t.Fatalf("got a uid of %d bytes, want %d bytes", len(req.Uid), len(uid))
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out waiting for a message")
+		}
+	}
+
+	if n := srv.SessionCount(); n != 1 {
+		t.Fatalf("SessionCount() = %d, want 1", n)
+	}
+}
+
+// TestRawMessages checks fragmented messages, pings and closing by hand.
+func TestRawMessages(t *testing.T) {
+	_, url := startServer(t)
+
+	conn, err := net.Dial("tcp", strings.TrimPrefix(url, "ws://"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	io.WriteString(conn, "GET / HTTP/1.1\r\nHost: test\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n"+
+		"Sec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\nSec-WebSocket-Version: 13\r\n\r\n")
+	br := bufio.NewReader(conn)
+	rsp, err := http.ReadResponse(br, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The example in RFC 6455.
+	if got := rsp.Header.Get("Sec-WebSocket-Accept"); got != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+		t.Fatalf("Sec-WebSocket-Accept = %q", got)
+	}
+
+	f, err := protocmd.DefaultFrameCodec.Encode(&protos.TestReq{Uid: "fragmented"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	frame, err := protocmd.DefaultFrameCodec.AppendFrame(nil, f)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	writeFragment(conn, 0x02, frame[:5])
+	writeFragment(conn, 0x89, []byte("ping")) // a ping between the fragments
+	writeFragment(conn, 0x80, frame[5:])
+
+	op, payload := readFragment(t, br)
+	if op != 0x8a || string(payload) != "ping" {
+		t.Fatalf("got opcode %#x %q, want a pong", op, payload)
+	}
+	op, payload = readFragment(t, br)
+	if op != 0x82 || string(payload) != string(frame) {
+		t.Fatalf("got opcode %#x %x, want the echoed frame %x", op, payload, frame)
+	}
+
+	writeFragment(conn, 0x88, []byte{0x03, 0xe8})
+	if op, _ = readFragment(t, br); op != 0x88 {
+		t.Fatalf("got opcode %#x, want a close", op)
+	}
+}
+
+func TestBadHandshake(t *testing.T) {
+	_, url := startServer(t)
+
+	rsp, err := http.Get("http" + strings.TrimPrefix(url, "ws"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsp.Body.Close()
+	if rsp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("got status %d, want %d", rsp.StatusCode, http.StatusBadRequest)
+	}
+
+	if _, err := websocket.Dial(context.Background(), "http"+strings.TrimPrefix(url, "ws"), nil); err == nil {
+		t.Fatal("expected an error for an http:// URL")
+	}
+}
+
+// writeFragment writes a masked client frame with a short payload.
+func writeFragment(w io.Writer, b0 byte, payload []byte) {
+	mask := [4]byte{1, 2, 3, 4}
+	buf := []byte{b0, 0x80 | byte(len(payload))}
+	buf = append(buf, mask[:]...)
+	for i, b := range payload {
+		buf = append(buf, b^mask[i&3])
+	}
+	w.Write(buf)
+}
+
+// readFragment reads an unmasked server frame with a short payload.
+func readFragment(t *testing.T, r io.Reader) (byte, []byte) {
+	t.Helper()
+
+	var header [2]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		t.Fatal(err)
+	}
+	payload := make([]byte, header[1])
+	if _, err := io.ReadFull(r, payload); err != nil {
+		t.Fatal(err)
+	}
+	return header[0], payload
+}